@@ -1,15 +1,22 @@
 package main
 
 import (
+	"encoding/json"
 	"log"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/mysticrenji/surfer/backend/internal/audit"
 	"github.com/mysticrenji/surfer/backend/internal/auth"
 	"github.com/mysticrenji/surfer/backend/internal/database"
 	"github.com/mysticrenji/surfer/backend/internal/handlers"
+	"github.com/mysticrenji/surfer/backend/internal/k8s"
 	"github.com/mysticrenji/surfer/backend/internal/middleware"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"github.com/mysticrenji/surfer/backend/internal/secrets"
+	"gorm.io/gorm"
 )
 
 func main() {
@@ -29,13 +36,86 @@ func main() {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
 
+	// Load JWT signing keys
+	keySet, err := auth.LoadKeySet()
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
+	// Load the master key kubeconfigs are encrypted with
+	kubeconfigKeys, err := secrets.LoadKeyProvider()
+	if err != nil {
+		log.Fatalf("Failed to load kubeconfig encryption key: %v", err)
+	}
+
+	// Commands that run instead of the server
+	if len(os.Args) > 1 {
+		runCommand(db, keySet, kubeconfigKeys, os.Args[1:])
+		return
+	}
+
+	// Encrypt kubeconfigs stored before encryption was introduced
+	if encrypted, err := secrets.EncryptClusters(db, kubeconfigKeys, false); err != nil {
+		log.Fatalf("Failed to encrypt stored kubeconfigs: %v", err)
+	} else if encrypted > 0 {
+		log.Printf("Encrypted %d stored kubeconfigs", encrypted)
+	}
+	if encrypted, err := secrets.EncryptAuditSinks(db, kubeconfigKeys, false); err != nil {
+		log.Fatalf("Failed to encrypt stored audit sink secrets: %v", err)
+	} else if encrypted > 0 {
+		log.Printf("Encrypted %d stored audit sink secrets", encrypted)
+	}
+
+	// Load login providers
+	providers, err := auth.LoadProviders()
+	if err != nil {
+		log.Fatalf("Failed to configure login providers: %v", err)
+	}
+	if len(providers) == 0 {
+		log.Println("WARNING: no login providers configured, set GOOGLE_CLIENT_ID or OIDC_PROVIDERS")
+	}
+
 	// Initialize auth service
-	authService := auth.NewAuthService()
+	sessionStore := auth.NewGormSessionStore(db)
+	stateStore := auth.NewGormStateStore(db)
+	tokenStore := auth.NewGormAccessTokenStore(db)
+	permissionStore := auth.NewGormPermissionStore(db)
+	roleStore := auth.NewGormRoleStore(db)
+	authService := auth.NewAuthService(db, keySet, sessionStore, stateStore, providers)
+
+	// Expire access requests and change requests in the background
+	go auth.RunRequestReaper(db, time.Minute)
+
+	// Write request audit logs in the background, sign checkpoints of the
+	// audit chain periodically and forward entries to the audit sinks
+	auditWriter := audit.NewWriter(db, 1000)
+	go auditWriter.Run()
+	go audit.RunCheckpoints(db, keySet, checkpointInterval())
+	sinkOptions, err := audit.LoadSinkOptions(kubeconfigKeys)
+	if err != nil {
+		log.Fatalf("Failed to load audit sink options: %v", err)
+	}
+	go audit.NewDispatcher(db, sinkOptions).Run(5 * time.Second)
 
 	// Initialize handlers
-	userHandler := handlers.NewUserHandler(db)
-	clusterHandler := handlers.NewClusterHandler(db)
-	k8sHandler := handlers.NewK8sHandler(db)
+	userHandler := handlers.NewUserHandler(db, roleStore)
+	sessionHandler := handlers.NewSessionHandler(sessionStore, tokenStore)
+	tokenHandler := handlers.NewTokenHandler(tokenStore)
+	serviceAccountHandler := handlers.NewServiceAccountHandler(db, tokenStore, permissionStore, roleStore)
+	approvalRuleHandler := handlers.NewApprovalRuleHandler(db, roleStore)
+	roleHandler := handlers.NewRoleHandler(db)
+	teamHandler := handlers.NewTeamHandler(db, permissionStore)
+	accessRequestHandler := handlers.NewAccessRequestHandler(db, permissionStore)
+	clientOptions, err := k8s.LoadClientOptions()
+	if err != nil {
+		log.Fatalf("Failed to load Kubernetes client options: %v", err)
+	}
+	clientFactory := k8s.NewClientFactory(kubeconfigKeys, clientOptions)
+	clusterHandler := handlers.NewClusterHandler(db, permissionStore, kubeconfigKeys, clientFactory)
+	k8sHandler := handlers.NewK8sHandler(db, clientFactory)
+	auditHandler := handlers.NewAuditHandler(db, keySet)
+	auditSinkHandler := handlers.NewAuditSinkHandler(db, sinkOptions)
+	changeRequestHandler := handlers.NewChangeRequestHandler(db, permissionStore, roleStore, k8sHandler)
 
 	// Setup router
 	router := gin.Default()
@@ -48,58 +128,185 @@ func main() {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	// Public keys for verifying Surfer tokens
+	router.GET("/.well-known/jwks.json", keySet.HandleJWKS)
+
 	// API v1 routes
 	v1 := router.Group("/api/v1")
 	{
 		// Auth routes (public)
 		auth := v1.Group("/auth")
 		{
-			auth.GET("/google/login", authService.HandleGoogleLogin)
-			auth.GET("/google/callback", authService.HandleGoogleCallback)
+			auth.GET("/providers", authService.HandleListProviders)
+			auth.GET("/:provider/login", authService.HandleLogin)
+			auth.GET("/:provider/callback", authService.HandleCallback)
+			auth.POST("/refresh", authService.HandleRefresh)
 			auth.POST("/logout", authService.HandleLogout)
 		}
 
 		// Protected routes
 		protected := v1.Group("")
-		protected.Use(middleware.AuthRequired())
+		protected.Use(middleware.AuthRequired(keySet, sessionStore, tokenStore, roleStore), middleware.Audit(auditWriter))
 		{
 			// User routes
 			users := protected.Group("/users")
 			{
 				users.GET("/me", userHandler.GetCurrentUser)
-				users.GET("", userHandler.ListUsers)
+				users.GET("/me/permissions", userHandler.GetMyPermissions)
+				users.GET("", middleware.PermissionRequired(models.PermUsersRead), userHandler.ListUsers)
+			}
+
+			// Link an identity at another login provider to the caller
+			protected.POST("/auth/:provider/link", authService.HandleLink)
+
+			// Session routes
+			sessions := protected.Group("/sessions")
+			{
+				sessions.GET("", sessionHandler.ListSessions)
+				sessions.DELETE("", sessionHandler.RevokeAllSessions)
+				sessions.DELETE("/:id", sessionHandler.RevokeSession)
+			}
+
+			// Personal access token routes
+			tokens := protected.Group("/tokens")
+			{
+				tokens.GET("", tokenHandler.ListTokens)
+				tokens.POST("", tokenHandler.CreateToken)
+				tokens.DELETE("/:id", tokenHandler.RevokeToken)
+			}
+
+			// Access request routes
+			accessRequests := protected.Group("/access-requests")
+			{
+				reviewRequests := middleware.PermissionRequired(models.PermAccessRequestsApprove)
+
+				accessRequests.GET("", accessRequestHandler.ListMyRequests)
+				accessRequests.POST("", middleware.PermissionRequired(models.PermAccessRequestsCreate), accessRequestHandler.CreateRequest)
+				accessRequests.DELETE("/:id", accessRequestHandler.CancelRequest)
+				accessRequests.GET("/review", reviewRequests, accessRequestHandler.ListReviewable)
+				accessRequests.POST("/:id/approve", reviewRequests, accessRequestHandler.ApproveRequest)
+				accessRequests.POST("/:id/deny", reviewRequests, accessRequestHandler.DenyRequest)
+				accessRequests.POST("/:id/revoke", reviewRequests, accessRequestHandler.RevokeRequest)
+			}
+
+			// Change request routes, reviewers need the permission of the change
+			changeRequests := protected.Group("/change-requests")
+			{
+				changeRequests.GET("", changeRequestHandler.ListMyRequests)
+				changeRequests.DELETE("/:id", changeRequestHandler.CancelRequest)
+				changeRequests.GET("/review", changeRequestHandler.ListReviewable)
+				changeRequests.POST("/:id/approve", changeRequestHandler.ApproveRequest)
+				changeRequests.POST("/:id/reject", changeRequestHandler.RejectRequest)
 			}
 
 			// Admin routes
+			approveUsers := middleware.PermissionRequired(models.PermUsersApprove)
+			manageUsers := middleware.PermissionRequired(models.PermUsersManage)
+			manageRoles := middleware.PermissionRequired(models.PermRolesManage)
+			manageRules := middleware.PermissionRequired(models.PermApprovalRulesManage)
+			manageServiceAccounts := middleware.PermissionRequired(models.PermServiceAccountsManage)
+			manageTeams := middleware.PermissionRequired(models.PermTeamsManage)
+			readAudit := middleware.PermissionRequired(models.PermAuditRead)
+			manageAudit := middleware.PermissionRequired(models.PermAuditManage)
+
 			admin := protected.Group("/admin")
-			admin.Use(middleware.AdminRequired())
 			{
-				admin.GET("/pending-users", userHandler.GetPendingUsers)
-				admin.POST("/approve-user/:id", userHandler.ApproveUser)
-				admin.POST("/reject-user/:id", userHandler.RejectUser)
-				admin.PUT("/users/:id/role", userHandler.UpdateUserRole)
+				admin.GET("/pending-users", approveUsers, userHandler.GetPendingUsers)
+				admin.POST("/approve-user/:id", approveUsers, userHandler.ApproveUser)
+				admin.POST("/reject-user/:id", approveUsers, userHandler.RejectUser)
+				admin.PUT("/users/:id/role", manageUsers, userHandler.UpdateUserRole)
+				admin.POST("/users/:id/logout", manageUsers, sessionHandler.ForceLogout)
+
+				// Roles
+				admin.GET("/permissions", manageRoles, roleHandler.ListPermissions)
+				admin.GET("/roles", manageRoles, roleHandler.ListRoles)
+				admin.POST("/roles", manageRoles, roleHandler.CreateRole)
+				admin.PUT("/roles/:id", manageRoles, roleHandler.UpdateRole)
+				admin.DELETE("/roles/:id", manageRoles, roleHandler.DeleteRole)
+
+				// Auto-approval rules
+				admin.GET("/approval-rules", manageRules, approvalRuleHandler.ListRules)
+				admin.POST("/approval-rules", manageRules, approvalRuleHandler.CreateRule)
+				admin.POST("/approval-rules/dry-run", manageRules, approvalRuleHandler.DryRun)
+				admin.PUT("/approval-rules/:id", manageRules, approvalRuleHandler.UpdateRule)
+				admin.DELETE("/approval-rules/:id", manageRules, approvalRuleHandler.DeleteRule)
+				admin.POST("/approval-rules/:id/apply", manageRules, approvalRuleHandler.ApplyRule)
+
+				// Audit log
+				admin.GET("/audit", readAudit, auditHandler.ListEntries)
+				admin.GET("/audit/export", middleware.SensitiveRead(), readAudit, auditHandler.Export)
+				admin.GET("/audit/verify", readAudit, auditHandler.Verify)
+				admin.GET("/audit/checkpoints", readAudit, auditHandler.ListCheckpoints)
+
+				// Audit sinks
+				auditSinks := admin.Group("/audit/sinks", manageAudit)
+				auditSinks.GET("", auditSinkHandler.ListSinks)
+				auditSinks.POST("", auditSinkHandler.CreateSink)
+				auditSinks.PUT("/:id", auditSinkHandler.UpdateSink)
+				auditSinks.DELETE("/:id", auditSinkHandler.DeleteSink)
+				auditSinks.POST("/:id/test", auditSinkHandler.TestSink)
+
+				// Teams
+				teams := admin.Group("/teams", manageTeams)
+				teams.GET("", teamHandler.ListTeams)
+				teams.POST("", teamHandler.CreateTeam)
+				teams.GET("/:id", teamHandler.GetTeam)
+				teams.PUT("/:id", teamHandler.UpdateTeam)
+				teams.DELETE("/:id", teamHandler.DeleteTeam)
+				teams.POST("/:id/members", teamHandler.AddMember)
+				teams.DELETE("/:id/members/:userId", teamHandler.RemoveMember)
+				teams.GET("/:id/clusters", teamHandler.ListClusterPermissions)
+
+				// Service accounts
+				serviceAccounts := admin.Group("/service-accounts", manageServiceAccounts)
+				serviceAccounts.GET("", serviceAccountHandler.ListServiceAccounts)
+				serviceAccounts.POST("", serviceAccountHandler.CreateServiceAccount)
+				serviceAccounts.PUT("/:id", serviceAccountHandler.UpdateServiceAccount)
+				serviceAccounts.DELETE("/:id", serviceAccountHandler.DeleteServiceAccount)
+				serviceAccounts.GET("/:id/clusters", serviceAccountHandler.ListClusterPermissions)
+				serviceAccounts.PUT("/:id/clusters/:clusterId", serviceAccountHandler.GrantClusterPermission)
+				serviceAccounts.DELETE("/:id/clusters/:clusterId", serviceAccountHandler.RevokeClusterPermission)
+				serviceAccounts.GET("/:id/tokens", serviceAccountHandler.ListTokens)
+				serviceAccounts.POST("/:id/tokens", serviceAccountHandler.CreateToken)
+				serviceAccounts.DELETE("/:id/tokens/:tokenId", serviceAccountHandler.RevokeToken)
 			}
 
 			// Cluster routes
-			clusters := protected.Group("/clusters")
+			viewCluster := middleware.ClusterAccessRequired(permissionStore, models.ClusterLevelViewer)
+			// Access requests do not let users change the cluster or who has access to it
+			manageCluster := middleware.StandingAccessRequired(permissionStore, models.ClusterLevelAdmin)
+			viewNamespace := middleware.NamespaceAccessRequired(permissionStore, models.ClusterLevelViewer)
+			operateNamespace := middleware.NamespaceAccessRequired(permissionStore, models.ClusterLevelOperator)
+
+			clusters := protected.Group("/clusters", middleware.PermissionRequired(models.PermClustersRead))
 			{
 				clusters.GET("", clusterHandler.ListClusters)
-				clusters.POST("", clusterHandler.AddCluster)
-				clusters.GET("/:id", clusterHandler.GetCluster)
-				clusters.PUT("/:id", clusterHandler.UpdateCluster)
-				clusters.DELETE("/:id", clusterHandler.DeleteCluster)
-				clusters.POST("/:id/test", clusterHandler.TestConnection)
+				clusters.POST("", middleware.PermissionRequired(models.PermClustersCreate), clusterHandler.AddCluster)
+				clusters.POST("/contexts", middleware.PermissionRequired(models.PermClustersCreate), clusterHandler.ListContexts)
+				clusters.POST("/import", middleware.PermissionRequired(models.PermClustersCreate), clusterHandler.ImportClusters)
+				clusters.GET("/:id", viewNamespace, clusterHandler.GetCluster)
+				clusters.PUT("/:id", middleware.PermissionRequired(models.PermClustersUpdate), manageCluster, clusterHandler.UpdateCluster)
+				clusters.DELETE("/:id", middleware.PermissionRequired(models.PermClustersDelete), manageCluster, clusterHandler.DeleteCluster)
+				clusters.POST("/:id/test", viewCluster, clusterHandler.TestConnection)
+
+				grantAccess := middleware.PermissionRequired(models.PermClustersGrant)
+				clusters.GET("/:id/access", grantAccess, manageCluster, clusterHandler.ListAccess)
+				clusters.POST("/:id/access", grantAccess, manageCluster, clusterHandler.GrantAccess)
+				clusters.DELETE("/:id/access/:permissionId", grantAccess, manageCluster, clusterHandler.RevokeAccess)
 			}
 
 			// Kubernetes resource routes
-			k8s := protected.Group("/k8s")
+			k8s := protected.Group("/k8s", middleware.PermissionRequired(models.PermWorkloadsRead))
 			{
-				k8s.GET("/clusters/:clusterId/namespaces", k8sHandler.ListNamespaces)
-				k8s.GET("/clusters/:clusterId/namespaces/:namespace/pods", k8sHandler.ListPods)
-				k8s.GET("/clusters/:clusterId/namespaces/:namespace/deployments", k8sHandler.ListDeployments)
-				k8s.GET("/clusters/:clusterId/namespaces/:namespace/services", k8sHandler.ListServices)
-				k8s.GET("/clusters/:clusterId/namespaces/:namespace/pods/:pod/logs", k8sHandler.GetPodLogs)
-				k8s.DELETE("/clusters/:clusterId/namespaces/:namespace/pods/:pod", k8sHandler.DeletePod)
+				k8s.GET("/clusters/:clusterId/namespaces", viewNamespace, k8sHandler.ListNamespaces)
+				k8s.GET("/clusters/:clusterId/namespaces/:namespace/pods", viewNamespace, k8sHandler.ListPods)
+				k8s.GET("/clusters/:clusterId/namespaces/:namespace/deployments", viewNamespace, k8sHandler.ListDeployments)
+				k8s.GET("/clusters/:clusterId/namespaces/:namespace/services", viewNamespace, k8sHandler.ListServices)
+				k8s.GET("/clusters/:clusterId/namespaces/:namespace/pods/:pod/logs", middleware.SensitiveRead(), middleware.PermissionRequired(models.PermLogsRead), viewNamespace, k8sHandler.GetPodLogs)
+				k8s.DELETE("/clusters/:clusterId/namespaces/:namespace/pods/:pod", middleware.PermissionRequired(models.PermPodsDelete), operateNamespace, k8sHandler.DeletePod)
+				k8s.PUT("/clusters/:clusterId/namespaces/:namespace/deployments/:deployment/scale", middleware.PermissionRequired(models.PermDeploymentsScale), operateNamespace, k8sHandler.ScaleDeployment)
+				k8s.DELETE("/clusters/:clusterId/namespaces/:namespace/resources/:kind/:name", middleware.PermissionRequired(models.PermResourcesDelete), operateNamespace, k8sHandler.DeleteResource)
+				k8s.POST("/clusters/:clusterId/namespaces/:namespace/apply", middleware.PermissionRequired(models.PermResourcesApply), operateNamespace, k8sHandler.ApplyResource)
 			}
 		}
 	}
@@ -114,3 +321,64 @@ func main() {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// defaultCheckpointInterval is how often the audit chain is checkpointed
+// without AUDIT_CHECKPOINT_INTERVAL
+const defaultCheckpointInterval = time.Hour
+
+// runCommand runs a maintenance command given on the command line:
+//
+//	verify-audit           verify the audit chain and its checkpoints, exit 1 on a break
+//	checkpoint-audit       sign a checkpoint of the audit chain now
+//	reencrypt-kubeconfigs  encrypt every kubeconfig and audit sink secret with the current master key
+func runCommand(db *gorm.DB, keySet *auth.KeySet, kubeconfigKeys secrets.KeyProvider, args []string) {
+	switch args[0] {
+	case "verify-audit":
+		report, err := audit.Verify(db, keySet)
+		if err != nil {
+			log.Fatalf("Failed to verify audit log: %v", err)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		if !report.Valid {
+			os.Exit(1)
+		}
+	case "checkpoint-audit":
+		checkpoint, err := audit.CreateCheckpoint(db, keySet)
+		if err != nil {
+			log.Fatalf("Failed to create audit checkpoint: %v", err)
+		}
+		if checkpoint == nil {
+			log.Println("No audit log entries since the last checkpoint")
+			return
+		}
+		log.Printf("Created audit checkpoint %d at entry %d with hash %s", checkpoint.ID, checkpoint.LastEntryID, checkpoint.LastHash)
+	case "reencrypt-kubeconfigs":
+		reencrypted, err := secrets.EncryptClusters(db, kubeconfigKeys, true)
+		if err != nil {
+			log.Fatalf("Failed to re-encrypt kubeconfigs after %d clusters: %v", reencrypted, err)
+		}
+		log.Printf("Re-encrypted %d kubeconfigs with key %s", reencrypted, kubeconfigKeys.KeyID())
+		reencrypted, err = secrets.EncryptAuditSinks(db, kubeconfigKeys, true)
+		if err != nil {
+			log.Fatalf("Failed to re-encrypt audit sink secrets after %d sinks: %v", reencrypted, err)
+		}
+		log.Printf("Re-encrypted %d audit sink secrets with key %s", reencrypted, kubeconfigKeys.KeyID())
+	default:
+		log.Fatalf("Unknown command %q, expected verify-audit, checkpoint-audit or reencrypt-kubeconfigs", args[0])
+	}
+}
+
+// checkpointInterval reads AUDIT_CHECKPOINT_INTERVAL, such as "30m"
+func checkpointInterval() time.Duration {
+	raw := os.Getenv("AUDIT_CHECKPOINT_INTERVAL")
+	if raw == "" {
+		return defaultCheckpointInterval
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil || interval < time.Minute {
+		log.Fatalf("AUDIT_CHECKPOINT_INTERVAL must be a duration of at least 1m, such as 1h")
+	}
+	return interval
+}
@@ -0,0 +1,318 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// chainLock is the Postgres advisory lock key that serializes appends to the
+// audit chain
+const chainLock = 0x61756469740a
+
+// verifyBatch is how many entries Verify loads at a time
+const verifyBatch = 1000
+
+// hashedFields are the fields of an entry its hash covers, in a fixed order
+type hashedFields struct {
+	PrevHash   string `json:"prev_hash"`
+	UserID     uint   `json:"user_id"`
+	ActorType  string `json:"actor_type"`
+	Action     string `json:"action"`
+	Resource   string `json:"resource"`
+	ResourceID string `json:"resource_id"`
+	Details    string `json:"details"`
+	IPAddress  string `json:"ip_address"`
+	ClusterID  *uint  `json:"cluster_id"`
+	Namespace  string `json:"namespace"`
+	Method     string `json:"method"`
+	Route      string `json:"route"`
+	StatusCode int    `json:"status_code"`
+	Outcome    string `json:"outcome"`
+	LatencyMs  int64  `json:"latency_ms"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// EntryHash returns the hex SHA-256 over the entry's PrevHash and content
+func EntryHash(entry *models.AuditLog) string {
+	data, _ := json.Marshal(hashedFields{
+		PrevHash:   entry.PrevHash,
+		UserID:     entry.UserID,
+		ActorType:  entry.ActorType,
+		Action:     entry.Action,
+		Resource:   entry.Resource,
+		ResourceID: entry.ResourceID,
+		Details:    entry.Details,
+		IPAddress:  entry.IPAddress,
+		ClusterID:  entry.ClusterID,
+		Namespace:  entry.Namespace,
+		Method:     entry.Method,
+		Route:      entry.Route,
+		StatusCode: entry.StatusCode,
+		Outcome:    entry.Outcome,
+		LatencyMs:  entry.LatencyMs,
+		CreatedAt:  entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Append stores entries at the end of the audit chain and queues them for the
+// enabled sinks. Appends are serialized with an advisory lock so that every
+// entry links to the one stored before it. Inside a transaction the lock is
+// held until it commits.
+func Append(db *gorm.DB, entries ...*models.AuditLog) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", chainLock).Error; err != nil {
+			return err
+		}
+
+		var last []models.AuditLog
+		if err := tx.Select("hash").Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		prevHash := ""
+		if len(last) > 0 {
+			prevHash = last[0].Hash
+		}
+
+		// Postgres keeps microseconds, the hash must cover what is stored
+		now := time.Now().UTC().Truncate(time.Microsecond)
+		for _, entry := range entries {
+			if entry.CreatedAt.IsZero() {
+				entry.CreatedAt = now
+			}
+			entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Microsecond)
+			if entry.ActorType == "" {
+				entry.ActorType = models.UserTypeHuman
+			}
+			entry.PrevHash = prevHash
+			entry.Hash = EntryHash(entry)
+			prevHash = entry.Hash
+		}
+
+		if err := tx.Create(entries).Error; err != nil {
+			return err
+		}
+		return enqueue(tx, entries)
+	})
+}
+
+// Seal chains the entries written before the chain existed. It does nothing
+// once any entry has a hash.
+func Seal(db *gorm.DB) (int, error) {
+	var chained int64
+	if err := db.Model(&models.AuditLog{}).Where("hash <> ''").Count(&chained).Error; err != nil {
+		return 0, err
+	}
+	if chained > 0 {
+		return 0, nil
+	}
+
+	sealed := 0
+	prevHash := ""
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", chainLock).Error; err != nil {
+			return err
+		}
+
+		var batch []models.AuditLog
+		return tx.FindInBatches(&batch, verifyBatch, func(batchTx *gorm.DB, _ int) error {
+			for i := range batch {
+				entry := &batch[i]
+				entry.PrevHash = prevHash
+				entry.Hash = EntryHash(entry)
+				prevHash = entry.Hash
+				if err := tx.Model(entry).UpdateColumns(map[string]interface{}{"prev_hash": entry.PrevHash, "hash": entry.Hash}).Error; err != nil {
+					return err
+				}
+				sealed++
+			}
+			return nil
+		}).Error
+	})
+	return sealed, err
+}
+
+// Break describes where the audit chain stops being trustworthy
+type Break struct {
+	EntryID uint   `json:"entry_id"`
+	Reason  string `json:"reason"`
+}
+
+// chainVerifier checks entries in ID order against the hashes they store
+type chainVerifier struct {
+	prevID   uint
+	prevHash string
+	checked  int
+}
+
+// check returns the break at entry, if any
+func (v *chainVerifier) check(entry *models.AuditLog) *Break {
+	defer func() {
+		v.prevID = entry.ID
+		v.prevHash = entry.Hash
+		v.checked++
+	}()
+
+	switch {
+	case entry.Hash == "":
+		return &Break{EntryID: entry.ID, Reason: "entry has no hash, it was not written through the audit chain"}
+	case entry.PrevHash != v.prevHash && v.checked == 0:
+		return &Break{EntryID: entry.ID, Reason: "first entry does not start the chain, earlier entries were deleted"}
+	case entry.PrevHash != v.prevHash:
+		return &Break{EntryID: entry.ID, Reason: fmt.Sprintf("previous hash does not match entry %d, entries between them were deleted or changed", v.prevID)}
+	case EntryHash(entry) != entry.Hash:
+		return &Break{EntryID: entry.ID, Reason: "content does not match its hash, the entry was modified"}
+	}
+	return nil
+}
+
+// Signer signs and verifies checkpoint claims. auth.KeySet implements it.
+type Signer interface {
+	SignClaims(claims map[string]interface{}) (string, error)
+	VerifyClaims(signed string) (map[string]interface{}, error)
+}
+
+// Report is the result of Verify
+type Report struct {
+	Valid       bool   `json:"valid"`
+	Entries     int    `json:"entries"`     // Entries checked
+	Checkpoints int    `json:"checkpoints"` // Checkpoints checked
+	LastEntryID uint   `json:"last_entry_id,omitempty"`
+	LastHash    string `json:"last_hash,omitempty"`
+	FirstBreak  *Break `json:"first_break,omitempty"`
+}
+
+// Verify walks the audit chain in ID order from the first entry, and checks
+// the signature of every checkpoint and that its entry is still in the chain.
+// It reports the first break it finds.
+func Verify(db *gorm.DB, signer Signer) (*Report, error) {
+	var checkpoints []models.AuditCheckpoint
+	if err := db.Order("id").Find(&checkpoints).Error; err != nil {
+		return nil, err
+	}
+	checkpointed := make(map[uint]string, len(checkpoints))
+	for _, checkpoint := range checkpoints {
+		checkpointed[checkpoint.LastEntryID] = ""
+	}
+
+	report := &Report{}
+	verifier := &chainVerifier{}
+	var batch []models.AuditLog
+	err := db.FindInBatches(&batch, verifyBatch, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			entry := &batch[i]
+			if problem := verifier.check(entry); problem != nil && report.FirstBreak == nil {
+				report.FirstBreak = problem
+			}
+			if _, ok := checkpointed[entry.ID]; ok {
+				checkpointed[entry.ID] = entry.Hash
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	report.Entries = verifier.checked
+	report.LastEntryID = verifier.prevID
+	report.LastHash = verifier.prevHash
+
+	for i := range checkpoints {
+		checkpoint := &checkpoints[i]
+		report.Checkpoints++
+		if report.FirstBreak != nil && report.FirstBreak.EntryID <= checkpoint.LastEntryID {
+			continue
+		}
+		if problem := checkCheckpoint(checkpoint, checkpointed[checkpoint.LastEntryID], signer); problem != nil {
+			if report.FirstBreak == nil || problem.EntryID < report.FirstBreak.EntryID {
+				report.FirstBreak = problem
+			}
+		}
+	}
+
+	report.Valid = report.FirstBreak == nil
+	return report, nil
+}
+
+// checkCheckpoint checks the signature of a checkpoint and that entryHash, the
+// hash now stored for its entry, is the one it recorded
+func checkCheckpoint(checkpoint *models.AuditCheckpoint, entryHash string, signer Signer) *Break {
+	claims, err := signer.VerifyClaims(checkpoint.Signature)
+	if err != nil {
+		return &Break{EntryID: checkpoint.LastEntryID, Reason: fmt.Sprintf("checkpoint %d has an invalid signature: %v", checkpoint.ID, err)}
+	}
+
+	signedID, _ := claims["last_entry_id"].(float64)
+	signedHash, _ := claims["last_hash"].(string)
+	if uint(signedID) != checkpoint.LastEntryID || signedHash != checkpoint.LastHash {
+		return &Break{EntryID: checkpoint.LastEntryID, Reason: fmt.Sprintf("checkpoint %d does not match its signature", checkpoint.ID)}
+	}
+
+	switch entryHash {
+	case "":
+		return &Break{EntryID: checkpoint.LastEntryID, Reason: fmt.Sprintf("entry of checkpoint %d was deleted", checkpoint.ID)}
+	case checkpoint.LastHash:
+		return nil
+	}
+	return &Break{EntryID: checkpoint.LastEntryID, Reason: fmt.Sprintf("entry of checkpoint %d no longer has the signed hash", checkpoint.ID)}
+}
+
+// CreateCheckpoint signs the current end of the chain. It returns nil when
+// nothing was appended since the last checkpoint.
+func CreateCheckpoint(db *gorm.DB, signer Signer) (*models.AuditCheckpoint, error) {
+	var last []models.AuditLog
+	if err := db.Select("id", "hash").Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+		return nil, err
+	}
+	if len(last) == 0 {
+		return nil, nil
+	}
+
+	var previous []models.AuditCheckpoint
+	if err := db.Order("id DESC").Limit(1).Find(&previous).Error; err != nil {
+		return nil, err
+	}
+	if len(previous) > 0 && previous[0].LastEntryID == last[0].ID {
+		return nil, nil
+	}
+
+	signature, err := signer.SignClaims(map[string]interface{}{
+		"last_entry_id": last[0].ID,
+		"last_hash":     last[0].Hash,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := &models.AuditCheckpoint{LastEntryID: last[0].ID, LastHash: last[0].Hash, Signature: signature}
+	if err := db.Create(checkpoint).Error; err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// RunCheckpoints creates a checkpoint every interval. It does not return.
+func RunCheckpoints(db *gorm.DB, signer Signer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		checkpoint, err := CreateCheckpoint(db, signer)
+		if err != nil {
+			log.Printf("Failed to create audit checkpoint: %v", err)
+			continue
+		}
+		if checkpoint != nil {
+			log.Printf("Created audit checkpoint %d at entry %d with hash %s", checkpoint.ID, checkpoint.LastEntryID, checkpoint.LastHash)
+		}
+	}
+}
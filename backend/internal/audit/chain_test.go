@@ -0,0 +1,162 @@
+package audit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+// testChain returns n entries linked the way Append links them
+func testChain(n int) []models.AuditLog {
+	entries := make([]models.AuditLog, n)
+	prevHash := ""
+	for i := range entries {
+		entries[i] = models.AuditLog{
+			ID:        uint(i + 1),
+			UserID:    3,
+			ActorType: models.UserTypeHuman,
+			Action:    "delete",
+			Resource:  "pods",
+			CreatedAt: time.Date(2024, 1, 1, 0, i, 0, 0, time.UTC),
+			PrevHash:  prevHash,
+		}
+		entries[i].Hash = EntryHash(&entries[i])
+		prevHash = entries[i].Hash
+	}
+	return entries
+}
+
+// verify runs the chain verifier over entries and returns the first break
+func verify(entries []models.AuditLog) *Break {
+	verifier := &chainVerifier{}
+	var first *Break
+	for i := range entries {
+		if problem := verifier.check(&entries[i]); problem != nil && first == nil {
+			first = problem
+		}
+	}
+	return first
+}
+
+func TestEntryHashCoversContent(t *testing.T) {
+	entry := testChain(1)[0]
+	hash := EntryHash(&entry)
+
+	if EntryHash(&entry) != hash {
+		t.Error("Expected the hash to be deterministic")
+	}
+
+	// The zone the time is read back in does not matter
+	entry.CreatedAt = entry.CreatedAt.In(time.FixedZone("CET", 3600))
+	if EntryHash(&entry) != hash {
+		t.Error("Expected the hash to ignore the time zone")
+	}
+
+	entry.Details = "edited"
+	if EntryHash(&entry) == hash {
+		t.Error("Expected the hash to change with the content")
+	}
+}
+
+func TestVerifyIntactChain(t *testing.T) {
+	if problem := verify(testChain(5)); problem != nil {
+		t.Errorf("Expected an intact chain, got %+v", problem)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	testCases := []struct {
+		name    string
+		tamper  func([]models.AuditLog) []models.AuditLog
+		entryID uint
+		reason  string
+	}{
+		{"edited entry", func(e []models.AuditLog) []models.AuditLog {
+			e[2].Resource = "configmaps"
+			return e
+		}, 3, "modified"},
+		{"deleted entry", func(e []models.AuditLog) []models.AuditLog {
+			return append(e[:2], e[3:]...)
+		}, 4, "deleted"},
+		{"deleted first entry", func(e []models.AuditLog) []models.AuditLog {
+			return e[1:]
+		}, 2, "earlier entries"},
+		{"rehashed entry", func(e []models.AuditLog) []models.AuditLog {
+			e[1].Action = "read"
+			e[1].Hash = EntryHash(&e[1])
+			return e
+		}, 3, "does not match entry 2"},
+		{"inserted entry", func(e []models.AuditLog) []models.AuditLog {
+			e[3].Hash = ""
+			return e
+		}, 4, "no hash"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			problem := verify(tc.tamper(testChain(5)))
+			if problem == nil {
+				t.Fatal("Expected a break")
+			}
+			if problem.EntryID != tc.entryID || !strings.Contains(problem.Reason, tc.reason) {
+				t.Errorf("Expected a break at entry %d mentioning %q, got %+v", tc.entryID, tc.reason, problem)
+			}
+		})
+	}
+}
+
+// fakeSigner signs claims by keeping them in memory
+type fakeSigner struct {
+	signed map[string]map[string]interface{}
+}
+
+func (s *fakeSigner) SignClaims(claims map[string]interface{}) (string, error) {
+	signature := "sig-" + claims["last_hash"].(string)
+	s.signed[signature] = map[string]interface{}{
+		"last_entry_id": float64(claims["last_entry_id"].(uint)),
+		"last_hash":     claims["last_hash"],
+	}
+	return signature, nil
+}
+
+func (s *fakeSigner) VerifyClaims(signed string) (map[string]interface{}, error) {
+	claims, ok := s.signed[signed]
+	if !ok {
+		return nil, errors.New("bad signature")
+	}
+	return claims, nil
+}
+
+func TestCheckCheckpoint(t *testing.T) {
+	signer := &fakeSigner{signed: make(map[string]map[string]interface{})}
+	signature, _ := signer.SignClaims(map[string]interface{}{"last_entry_id": uint(5), "last_hash": "h5"})
+	checkpoint := models.AuditCheckpoint{ID: 1, LastEntryID: 5, LastHash: "h5", Signature: signature}
+
+	if problem := checkCheckpoint(&checkpoint, "h5", signer); problem != nil {
+		t.Errorf("Expected a valid checkpoint, got %+v", problem)
+	}
+
+	testCases := []struct {
+		name       string
+		checkpoint models.AuditCheckpoint
+		entryHash  string
+		reason     string
+	}{
+		{"entries removed from the end", checkpoint, "", "deleted"},
+		{"entry rewritten", checkpoint, "other", "no longer has the signed hash"},
+		{"forged signature", models.AuditCheckpoint{ID: 1, LastEntryID: 5, LastHash: "h5", Signature: "forged"}, "h5", "invalid signature"},
+		{"edited checkpoint", models.AuditCheckpoint{ID: 1, LastEntryID: 5, LastHash: "other", Signature: signature}, "other", "does not match its signature"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			problem := checkCheckpoint(&tc.checkpoint, tc.entryHash, signer)
+			if problem == nil || !strings.Contains(problem.Reason, tc.reason) {
+				t.Errorf("Expected a break mentioning %q, got %+v", tc.reason, problem)
+			}
+		})
+	}
+}
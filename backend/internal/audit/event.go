@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+// maxDetailsLength caps the details of an entry, its request summary and
+// events together
+const maxDetailsLength = 4096
+
+// Event is something a handler did while serving a request, such as creating
+// a change request. Events are added to the entry of their request.
+type Event struct {
+	Action     string
+	Resource   string
+	ResourceID string
+	Details    string
+}
+
+func (e Event) String() string {
+	event := fmt.Sprintf("%s %s %s", e.Action, e.Resource, e.ResourceID)
+	if e.Details != "" {
+		event += " " + e.Details
+	}
+	return event
+}
+
+// Enrich appends events to the details of a request's entry. Entries of
+// routes without a resource ID, such as creates, take that of the first
+// event.
+func Enrich(entry *models.AuditLog, events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	if entry.ResourceID == "" {
+		entry.ResourceID = events[0].ResourceID
+	}
+
+	parts := make([]string, 0, len(events)+1)
+	if entry.Details != "" {
+		parts = append(parts, entry.Details)
+	}
+	for _, event := range events {
+		parts = append(parts, event.String())
+	}
+	entry.Details = truncate(strings.Join(parts, "; "), maxDetailsLength)
+}
@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+// Export formats
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// csvHeader names the columns ExportWriter writes in CSV
+var csvHeader = []string{"id", "created_at", "user_id", "user_email", "actor_type", "action", "resource", "resource_id", "cluster_id", "namespace", "method", "route", "status_code", "outcome", "latency_ms", "ip_address", "details"}
+
+// ExportWriter writes audit log entries as CSV or newline-delimited JSON
+type ExportWriter struct {
+	format string
+	csv    *csv.Writer
+	json   *json.Encoder
+}
+
+// NewExportWriter returns a writer for format. CSV output starts with a
+// header row.
+func NewExportWriter(w io.Writer, format string) (*ExportWriter, error) {
+	switch format {
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvHeader); err != nil {
+			return nil, err
+		}
+		return &ExportWriter{format: format, csv: writer}, nil
+	case FormatNDJSON:
+		return &ExportWriter{format: format, json: json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("format must be %s or %s", FormatCSV, FormatNDJSON)
+}
+
+// Write writes one entry. The entry's User must be loaded for its email.
+func (w *ExportWriter) Write(entry *models.AuditLog) error {
+	if w.json != nil {
+		return w.json.Encode(entry)
+	}
+
+	clusterID := ""
+	if entry.ClusterID != nil {
+		clusterID = strconv.FormatUint(uint64(*entry.ClusterID), 10)
+	}
+
+	record := []string{
+		strconv.FormatUint(uint64(entry.ID), 10),
+		entry.CreatedAt.UTC().Format(time.RFC3339),
+		strconv.FormatUint(uint64(entry.UserID), 10),
+		entry.User.Email,
+		entry.ActorType,
+		entry.Action,
+		entry.Resource,
+		entry.ResourceID,
+		clusterID,
+		entry.Namespace,
+		entry.Method,
+		entry.Route,
+		strconv.Itoa(entry.StatusCode),
+		entry.Outcome,
+		strconv.FormatInt(entry.LatencyMs, 10),
+		entry.IPAddress,
+		entry.Details,
+	}
+	for i := range record {
+		record[i] = escapeFormula(record[i])
+	}
+	return w.csv.Write(record)
+}
+
+// Flush writes buffered CSV rows
+func (w *ExportWriter) Flush() error {
+	if w.csv == nil {
+		return nil
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// escapeFormula prefixes values that spreadsheets would run as formulas
+func escapeFormula(value string) string {
+	if value == "" {
+		return value
+	}
+	switch value[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		return "'" + value
+	}
+	return value
+}
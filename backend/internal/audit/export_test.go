@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+func testEntry() *models.AuditLog {
+	clusterID := uint(2)
+	return &models.AuditLog{
+		ID:         10,
+		UserID:     4,
+		ActorType:  models.UserTypeHuman,
+		Action:     "delete",
+		Resource:   "pods",
+		ResourceID: "api-0",
+		ClusterID:  &clusterID,
+		Namespace:  "payments",
+		Details:    "=HYPERLINK(\"http://evil\")",
+		CreatedAt:  time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC),
+		User:       models.User{Email: "ops@example.com"},
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	var out bytes.Buffer
+	writer, err := NewExportWriter(&out, FormatCSV)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := writer.Write(testEntry()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected a header and a row, got %q", out.String())
+	}
+	if !strings.HasPrefix(lines[0], "id,created_at,user_id,user_email") {
+		t.Errorf("Unexpected header %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "10,2024-02-01T12:00:00Z,4,ops@example.com,human,delete,pods,api-0,2,payments") {
+		t.Errorf("Unexpected row %q", lines[1])
+	}
+	if !strings.Contains(lines[1], `"'=HYPERLINK(""http://evil"")"`) {
+		t.Errorf("Expected the formula to be escaped, got %q", lines[1])
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	var out bytes.Buffer
+	writer, err := NewExportWriter(&out, FormatNDJSON)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := writer.Write(testEntry()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d", len(lines))
+	}
+	var entry models.AuditLog
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil || entry.ResourceID != "api-0" {
+		t.Errorf("Expected a JSON entry per line, got %q (%v)", lines[0], err)
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	if _, err := NewExportWriter(&bytes.Buffer{}, "xlsx"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
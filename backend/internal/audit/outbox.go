@@ -0,0 +1,205 @@
+package audit
+
+import (
+	"log"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// dispatchLock is the Postgres advisory lock key that lets one replica at a
+// time deliver the outbox
+const dispatchLock = 0x61756469740b
+
+const (
+	// outboxBatch is how many queued entries a sink is sent per pass
+	outboxBatch = 100
+	// Retry delays of a sink whose oldest queued entry failed
+	minRetryDelay = 5 * time.Second
+	maxRetryDelay = 10 * time.Minute
+)
+
+// RetryDelay returns how long to wait after the given number of failed
+// attempts: 5s, doubling up to 10m
+func RetryDelay(attempts int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// enqueue queues entries for every enabled sink in the transaction that
+// appends them, so that no entry is lost while a sink is down
+func enqueue(tx *gorm.DB, entries []*models.AuditLog) error {
+	var sinkIDs []uint
+	if err := tx.Model(&models.AuditSink{}).Where("enabled = ?", true).Pluck("id", &sinkIDs).Error; err != nil {
+		return err
+	}
+	if len(sinkIDs) == 0 {
+		return nil
+	}
+
+	rows := make([]models.AuditOutbox, 0, len(sinkIDs)*len(entries))
+	for _, sinkID := range sinkIDs {
+		for _, entry := range entries {
+			rows = append(rows, models.AuditOutbox{SinkID: sinkID, EntryID: entry.ID, NextAttemptAt: entry.CreatedAt})
+		}
+	}
+	return tx.Create(&rows).Error
+}
+
+// openSink is a sink opened from a configuration
+type openSink struct {
+	sink      Sink
+	updatedAt time.Time
+}
+
+// Dispatcher delivers the outbox to the sinks in the order entries were
+// written. A sink whose oldest entry fails is retried with RetryDelay, and the
+// entries after it wait, so delivery is in order and at least once.
+type Dispatcher struct {
+	db      *gorm.DB
+	options SinkOptions
+	sinks   map[uint]*openSink
+}
+
+func NewDispatcher(db *gorm.DB, options SinkOptions) *Dispatcher {
+	return &Dispatcher{db: db, options: options, sinks: make(map[uint]*openSink)}
+}
+
+// Run delivers the outbox every interval. It does not return.
+func (d *Dispatcher) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := d.Deliver(time.Now()); err != nil {
+			log.Printf("Failed to deliver audit log entries: %v", err)
+		}
+	}
+}
+
+// Deliver makes one pass over the enabled sinks, unless another replica is
+// delivering. The lock belongs to the database session rather than a
+// transaction, so that no transaction stays open while entries are sent.
+func (d *Dispatcher) Deliver(now time.Time) error {
+	return d.db.Connection(func(conn *gorm.DB) error {
+		db := conn.Session(&gorm.Session{NewDB: true})
+
+		var locked bool
+		if err := db.Raw("SELECT pg_try_advisory_lock(?)", dispatchLock).Scan(&locked).Error; err != nil || !locked {
+			return err
+		}
+		defer db.Exec("SELECT pg_advisory_unlock(?)", dispatchLock)
+
+		var configs []models.AuditSink
+		if err := db.Where("enabled = ?", true).Find(&configs).Error; err != nil {
+			return err
+		}
+		d.closeRemoved(configs)
+
+		for i := range configs {
+			config := &configs[i]
+			sink, err := d.open(config)
+			if err != nil {
+				db.Model(config).UpdateColumn("last_error", err.Error())
+				continue
+			}
+			if err := d.deliverTo(db, config, sink, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deliverTo sends a sink its queued entries until one fails, then removes the
+// delivered ones from the outbox in a short transaction
+func (d *Dispatcher) deliverTo(db *gorm.DB, config *models.AuditSink, sink Sink, now time.Time) error {
+	var queued []models.AuditOutbox
+	if err := db.Preload("Entry").Preload("Entry.User").
+		Where("sink_id = ?", config.ID).
+		Order("id").Limit(outboxBatch).
+		Find(&queued).Error; err != nil {
+		return err
+	}
+	if len(queued) == 0 || queued[0].NextAttemptAt.After(now) {
+		return nil
+	}
+
+	delivered := make([]uint, 0, len(queued))
+	var failed *models.AuditOutbox
+	var sendErr error
+	for i := range queued {
+		row := &queued[i]
+
+		if row.Entry.ID != 0 {
+			if err := sink.Send(&row.Entry); err != nil {
+				failed, sendErr = row, err
+				break
+			}
+		} else {
+			log.Printf("Audit log entry %d queued for sink %s no longer exists", row.EntryID, config.Name)
+		}
+		delivered = append(delivered, row.ID)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if len(delivered) > 0 {
+			if err := tx.Delete(&models.AuditOutbox{}, delivered).Error; err != nil {
+				return err
+			}
+		}
+
+		if failed != nil {
+			failed.Attempts++
+			if err := tx.Model(failed).UpdateColumns(map[string]interface{}{
+				"attempts":        failed.Attempts,
+				"last_error":      sendErr.Error(),
+				"next_attempt_at": now.Add(RetryDelay(failed.Attempts)),
+			}).Error; err != nil {
+				return err
+			}
+			return tx.Model(config).UpdateColumn("last_error", sendErr.Error()).Error
+		}
+		return tx.Model(config).UpdateColumns(map[string]interface{}{"last_error": "", "last_delivered_at": now}).Error
+	})
+}
+
+// open returns the open sink of a configuration, reopening it when the
+// configuration changed
+func (d *Dispatcher) open(config *models.AuditSink) (Sink, error) {
+	if open, ok := d.sinks[config.ID]; ok {
+		if open.updatedAt.Equal(config.UpdatedAt) {
+			return open.sink, nil
+		}
+		open.sink.Close()
+		delete(d.sinks, config.ID)
+	}
+
+	sink, err := NewSink(config, d.options)
+	if err != nil {
+		return nil, err
+	}
+	d.sinks[config.ID] = &openSink{sink: sink, updatedAt: config.UpdatedAt}
+	return sink, nil
+}
+
+// closeRemoved closes the sinks that were deleted or disabled
+func (d *Dispatcher) closeRemoved(configs []models.AuditSink) {
+	enabled := make(map[uint]bool, len(configs))
+	for _, config := range configs {
+		enabled[config.ID] = true
+	}
+	for id, open := range d.sinks {
+		if !enabled[id] {
+			open.sink.Close()
+			delete(d.sinks, id)
+		}
+	}
+}
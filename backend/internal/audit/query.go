@@ -0,0 +1,155 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultLimit is the page size of audit queries without a limit
+	DefaultLimit = 100
+	// MaxLimit is the largest page size of audit queries
+	MaxLimit = 1000
+)
+
+// Filter selects audit log entries. Empty fields match everything; list
+// fields match any of their values.
+type Filter struct {
+	UserID     uint
+	ActorType  string
+	Actions    []string
+	Resources  []string
+	ResourceID string
+	ClusterID  uint
+	Namespace  string
+	Outcomes   []string
+	Since      time.Time // Inclusive
+	Until      time.Time // Exclusive
+	Cursor     uint      // Only entries with a lower ID, to continue a previous page
+	Limit      int
+}
+
+// ParseFilter reads a Filter from query parameters: user_id, actor_type,
+// action, resource, resource_id, cluster_id, namespace, outcome, since and
+// until as RFC 3339 times, cursor and limit. action, resource and outcome take
+// comma separated values.
+func ParseFilter(query url.Values) (Filter, error) {
+	filter := Filter{
+		ActorType:  query.Get("actor_type"),
+		Actions:    splitList(query.Get("action")),
+		Resources:  splitList(query.Get("resource")),
+		ResourceID: query.Get("resource_id"),
+		Namespace:  query.Get("namespace"),
+		Outcomes:   splitList(query.Get("outcome")),
+		Limit:      DefaultLimit,
+	}
+
+	var err error
+	if filter.UserID, err = parseID(query, "user_id"); err != nil {
+		return Filter{}, err
+	}
+	if filter.ClusterID, err = parseID(query, "cluster_id"); err != nil {
+		return Filter{}, err
+	}
+	if filter.Cursor, err = parseID(query, "cursor"); err != nil {
+		return Filter{}, err
+	}
+	if filter.Since, err = parseTime(query, "since"); err != nil {
+		return Filter{}, err
+	}
+	if filter.Until, err = parseTime(query, "until"); err != nil {
+		return Filter{}, err
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Since.Before(filter.Until) {
+		return Filter{}, errors.New("since must be before until")
+	}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return Filter{}, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+// Apply adds the conditions of the filter, but not its limit or order, to a
+// query on audit_logs
+func (f Filter) Apply(query *gorm.DB) *gorm.DB {
+	if f.UserID != 0 {
+		query = query.Where("audit_logs.user_id = ?", f.UserID)
+	}
+	if f.ActorType != "" {
+		query = query.Where("audit_logs.actor_type = ?", f.ActorType)
+	}
+	if len(f.Actions) > 0 {
+		query = query.Where("audit_logs.action IN ?", f.Actions)
+	}
+	if len(f.Resources) > 0 {
+		query = query.Where("audit_logs.resource IN ?", f.Resources)
+	}
+	if f.ResourceID != "" {
+		query = query.Where("audit_logs.resource_id = ?", f.ResourceID)
+	}
+	if f.ClusterID != 0 {
+		query = query.Where("audit_logs.cluster_id = ?", f.ClusterID)
+	}
+	if f.Namespace != "" {
+		query = query.Where("audit_logs.namespace = ?", f.Namespace)
+	}
+	if len(f.Outcomes) > 0 {
+		query = query.Where("audit_logs.outcome IN ?", f.Outcomes)
+	}
+	if !f.Since.IsZero() {
+		query = query.Where("audit_logs.created_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		query = query.Where("audit_logs.created_at < ?", f.Until)
+	}
+	if f.Cursor != 0 {
+		query = query.Where("audit_logs.id < ?", f.Cursor)
+	}
+	return query
+}
+
+func splitList(raw string) []string {
+	var values []string
+	for _, value := range strings.Split(raw, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func parseID(query url.Values, name string) (uint, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+	return uint(id), nil
+}
+
+func parseTime(query url.Values, name string) (time.Time, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time such as 2024-01-31T00:00:00Z", name)
+	}
+	return t, nil
+}
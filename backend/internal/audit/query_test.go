@@ -0,0 +1,59 @@
+package audit
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	query, _ := url.ParseQuery("user_id=4&action=delete,scale&resource=pods&cluster_id=2&outcome=success&since=2024-01-01T00:00:00Z&until=2024-04-01T00:00:00Z&cursor=900&limit=50")
+
+	filter, err := ParseFilter(query)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := Filter{
+		UserID:    4,
+		Actions:   []string{"delete", "scale"},
+		Resources: []string{"pods"},
+		ClusterID: 2,
+		Outcomes:  []string{"success"},
+		Since:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Until:     time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		Cursor:    900,
+		Limit:     50,
+	}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("Expected %+v, got %+v", want, filter)
+	}
+}
+
+func TestParseFilterDefaults(t *testing.T) {
+	filter, err := ParseFilter(url.Values{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if filter.Limit != DefaultLimit || filter.Actions != nil || !filter.Since.IsZero() {
+		t.Errorf("Expected an empty filter with the default limit, got %+v", filter)
+	}
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	for _, raw := range []string{
+		"user_id=me",
+		"cluster_id=-1",
+		"since=yesterday",
+		"since=2024-04-01T00:00:00Z&until=2024-01-01T00:00:00Z",
+		"limit=0",
+		"limit=5000",
+		"cursor=abc",
+	} {
+		query, _ := url.ParseQuery(raw)
+		if _, err := ParseFilter(query); err == nil {
+			t.Errorf("Expected an error for %s", raw)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// maxBodyBytes is the largest request body that is summarized
+	maxBodyBytes = 256 << 10
+	// maxSummaryLength caps the summary stored with an entry
+	maxSummaryLength = 2048
+	// Redacted replaces secret values in summaries
+	Redacted = "[REDACTED]"
+)
+
+// secretKeys are substrings of JSON keys and query parameters whose values are
+// never stored
+var secretKeys = []string{"password", "secret", "token", "kubeconfig", "kube_config", "private_key", "client_key", "api_key", "apikey", "credential", "authorization", "certificate"}
+
+// IsSecretKey reports whether the value of a JSON key or query parameter must
+// be redacted. IDs referring to secrets, such as token_id, are kept.
+func IsSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	if strings.HasSuffix(key, "_id") || strings.HasSuffix(key, "_ids") {
+		return false
+	}
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// Summarize describes the query and JSON body of a request with secret values
+// redacted. The body is read and put back for the handler. Bodies that are not
+// JSON or too large are only described by their size.
+func Summarize(r *http.Request) string {
+	var parts []string
+	if query := redactQuery(r.URL.Query()); query != "" {
+		parts = append(parts, "query="+query)
+	}
+
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		if err == nil && len(body) > 0 {
+			parts = append(parts, "body="+summarizeBody(body))
+		}
+	}
+
+	return truncate(strings.Join(parts, " "), maxSummaryLength)
+}
+
+func summarizeBody(body []byte) string {
+	if len(body) > maxBodyBytes {
+		return fmt.Sprintf("(more than %d bytes)", maxBodyBytes)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Sprintf("(%d bytes, not JSON)", len(body))
+	}
+
+	redacted, err := json.Marshal(Redact(value))
+	if err != nil {
+		return fmt.Sprintf("(%d bytes)", len(body))
+	}
+	return string(redacted)
+}
+
+// Redact replaces the values of secret keys in a decoded JSON value
+func Redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if IsSecretKey(key) {
+				result[key] = Redacted
+			} else {
+				result[key] = Redact(item)
+			}
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = Redact(item)
+		}
+		return result
+	}
+	return value
+}
+
+// redactQuery encodes query, sorted by key, with secret values redacted
+func redactQuery(query url.Values) string {
+	redacted := url.Values{}
+	for key, values := range query {
+		for _, value := range values {
+			if IsSecretKey(key) {
+				value = Redacted
+			}
+			redacted.Add(key, value)
+		}
+	}
+	return redacted.Encode()
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max] + "..."
+}
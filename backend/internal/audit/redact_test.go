@@ -0,0 +1,55 @@
+package audit
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestIsSecretKey(t *testing.T) {
+	testCases := []struct {
+		key  string
+		want bool
+	}{
+		{"kubeconfig", true},
+		{"client_secret", true},
+		{"Password", true},
+		{"refresh_token", true},
+		{"client_key", true},
+		{"token_id", false},
+		{"name", false},
+		{"namespace", false},
+	}
+
+	for _, tc := range testCases {
+		if got := IsSecretKey(tc.key); got != tc.want {
+			t.Errorf("IsSecretKey(%q): expected %t, got %t", tc.key, tc.want, got)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	body := `{"name":"ci","credentials":{"token":"abc"},"items":[{"password":"hunter2","user":"bob"}]}`
+	req, _ := http.NewRequest("POST", "/api/v1/things?reason=INC-1&access_token=xyz", strings.NewReader(body))
+
+	summary := Summarize(req)
+
+	for _, secret := range []string{"abc", "hunter2", "xyz"} {
+		if strings.Contains(summary, secret) {
+			t.Errorf("Expected %q to be redacted, got %s", secret, summary)
+		}
+	}
+	for _, kept := range []string{`"name":"ci"`, `"user":"bob"`, "reason=INC-1"} {
+		if !strings.Contains(summary, kept) {
+			t.Errorf("Expected %s in the summary, got %s", kept, summary)
+		}
+	}
+}
+
+func TestSummarizeNonJSONBody(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/api/v1/things", strings.NewReader("password=hunter2"))
+
+	if summary := Summarize(req); summary != "body=(16 bytes, not JSON)" {
+		t.Errorf("Expected only the size of the body, got %q", summary)
+	}
+}
@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Route describes what a request to an API route does
+type Route struct {
+	Action       string // create, read, update, delete, or a verb such as approve
+	Resource     string // Innermost resource type the route addresses, such as pods
+	IDParam      string // Route parameter holding the resource ID, if any
+	ClusterParam string // Route parameter holding the cluster ID, if any
+}
+
+// routeVerbs are trailing route segments that name the action. The value is
+// the action recorded.
+var routeVerbs = map[string]string{
+	"approve":  "approve",
+	"reject":   "reject",
+	"deny":     "deny",
+	"revoke":   "revoke",
+	"apply":    "apply",
+	"dry-run":  "dry_run",
+	"test":     "test",
+	"logout":   "logout",
+	"logs":     "read_logs",
+	"export":   "export",
+	"scale":    "scale",
+	"role":     "set_role",
+	"import":   "import",
+	"contexts": "list_contexts",
+	"link":     "link",
+}
+
+// actionResources are route segments that name both the action and the
+// resource, followed by its ID
+var actionResources = map[string][2]string{
+	"approve-user": {"approve", "users"},
+	"reject-user":  {"reject", "users"},
+}
+
+// DescribeRoute describes a request from its method and route template, such
+// as "/api/v1/clusters/:id/access"
+func DescribeRoute(method, route string) Route {
+	var segments []string
+	for _, segment := range strings.Split(route, "/") {
+		switch segment {
+		case "", "api", "v1", "admin", "k8s":
+			continue
+		}
+		segments = append(segments, segment)
+	}
+
+	var described Route
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+		if verb, ok := routeVerbs[last]; ok && len(segments) > 1 {
+			described.Action = verb
+			segments = segments[:len(segments)-1]
+		}
+	}
+	if described.Action == "" {
+		described.Action = methodAction(method)
+	}
+
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			continue
+		}
+		described.Resource = segment
+		described.IDParam = ""
+		if i+1 < len(segments) && strings.HasPrefix(segments[i+1], ":") {
+			described.IDParam = segments[i+1][1:]
+		}
+		if pair, ok := actionResources[segment]; ok {
+			described.Action, described.Resource = pair[0], pair[1]
+		}
+	}
+
+	switch {
+	case strings.Contains(route, "/:clusterId"):
+		described.ClusterParam = "clusterId"
+	case len(segments) > 1 && segments[0] == "clusters" && segments[1] == ":id":
+		described.ClusterParam = "id"
+	}
+
+	return described
+}
+
+func methodAction(method string) string {
+	switch method {
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut, http.MethodPatch:
+		return "update"
+	case http.MethodDelete:
+		return "delete"
+	}
+	return "read"
+}
+
+// Outcomes of audited requests
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"
+	OutcomeFailure = "failure"
+)
+
+// Outcome classifies a response status code
+func Outcome(status int) string {
+	switch {
+	case status < 400:
+		return OutcomeSuccess
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return OutcomeDenied
+	}
+	return OutcomeFailure
+}
@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDescribeRoute(t *testing.T) {
+	testCases := []struct {
+		method string
+		route  string
+		want   Route
+	}{
+		{"POST", "/api/v1/clusters", Route{Action: "create", Resource: "clusters"}},
+		{"DELETE", "/api/v1/clusters/:id", Route{Action: "delete", Resource: "clusters", IDParam: "id", ClusterParam: "id"}},
+		{"POST", "/api/v1/clusters/:id/access", Route{Action: "create", Resource: "access", ClusterParam: "id"}},
+		{"DELETE", "/api/v1/clusters/:id/access/:permissionId", Route{Action: "delete", Resource: "access", IDParam: "permissionId", ClusterParam: "id"}},
+		{"POST", "/api/v1/clusters/:id/test", Route{Action: "test", Resource: "clusters", IDParam: "id", ClusterParam: "id"}},
+		{"POST", "/api/v1/clusters/import", Route{Action: "import", Resource: "clusters"}},
+		{"POST", "/api/v1/clusters/contexts", Route{Action: "list_contexts", Resource: "clusters"}},
+		{"DELETE", "/api/v1/k8s/clusters/:clusterId/namespaces/:namespace/pods/:pod", Route{Action: "delete", Resource: "pods", IDParam: "pod", ClusterParam: "clusterId"}},
+		{"PUT", "/api/v1/k8s/clusters/:clusterId/namespaces/:namespace/deployments/:deployment/scale", Route{Action: "scale", Resource: "deployments", IDParam: "deployment", ClusterParam: "clusterId"}},
+		{"POST", "/api/v1/access-requests/:id/approve", Route{Action: "approve", Resource: "access-requests", IDParam: "id"}},
+		{"POST", "/api/v1/admin/approve-user/:id", Route{Action: "approve", Resource: "users", IDParam: "id"}},
+		{"PUT", "/api/v1/admin/users/:id/role", Route{Action: "set_role", Resource: "users", IDParam: "id"}},
+		{"DELETE", "/api/v1/sessions", Route{Action: "delete", Resource: "sessions"}},
+		{"POST", "/api/v1/auth/:provider/link", Route{Action: "link", Resource: "auth", IDParam: "provider"}},
+		{"GET", "/api/v1/admin/audit/export", Route{Action: "export", Resource: "audit"}},
+	}
+
+	for _, tc := range testCases {
+		if got := DescribeRoute(tc.method, tc.route); got != tc.want {
+			t.Errorf("DescribeRoute(%s %s): expected %+v, got %+v", tc.method, tc.route, tc.want, got)
+		}
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	testCases := map[int]string{
+		http.StatusOK:                  OutcomeSuccess,
+		http.StatusAccepted:            OutcomeSuccess,
+		http.StatusUnauthorized:        OutcomeDenied,
+		http.StatusForbidden:           OutcomeDenied,
+		http.StatusConflict:            OutcomeFailure,
+		http.StatusInternalServerError: OutcomeFailure,
+	}
+
+	for status, want := range testCases {
+		if got := Outcome(status); got != want {
+			t.Errorf("Outcome(%d): expected %s, got %s", status, want, got)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"github.com/mysticrenji/surfer/backend/internal/secrets"
+)
+
+// Defaults of file sinks
+const (
+	defaultMaxSizeMB = 100
+	defaultMaxFiles  = 5
+)
+
+// minSecretLength is the shortest HMAC secret webhook sinks accept
+const minSecretLength = 16
+
+// Sink delivers audit log entries to an external system
+type Sink interface {
+	// Send delivers one entry. The entry's User should be loaded.
+	Send(entry *models.AuditLog) error
+	Close() error
+}
+
+// SinkOptions are the operator's settings for the sinks admins configure
+type SinkOptions struct {
+	// Dir is the only directory file sinks may write in. File sinks are
+	// refused without it.
+	Dir string
+	// Keys encrypt and decrypt the secrets of webhook sinks
+	Keys secrets.KeyProvider
+}
+
+// LoadSinkOptions reads AUDIT_SINK_DIR, an existing absolute directory.
+// Symlinks in it are resolved once here, sink paths may not contain any.
+func LoadSinkOptions(keys secrets.KeyProvider) (SinkOptions, error) {
+	options := SinkOptions{Keys: keys}
+	dir := os.Getenv("AUDIT_SINK_DIR")
+	if dir == "" {
+		return options, nil
+	}
+	if !filepath.IsAbs(dir) {
+		return options, errors.New("AUDIT_SINK_DIR must be an absolute path")
+	}
+
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return options, fmt.Errorf("AUDIT_SINK_DIR: %w", err)
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return options, errors.New("AUDIT_SINK_DIR must be a directory")
+	}
+	options.Dir = resolved
+	return options, nil
+}
+
+// checkPath checks that path names a file directly in Dir. Rotation renames
+// and removes the files next to it, so it must not reach any other directory.
+func (o SinkOptions) checkPath(path string) error {
+	if o.Dir == "" {
+		return errors.New("file sinks are disabled, AUDIT_SINK_DIR is not set")
+	}
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path || filepath.Dir(path) != o.Dir {
+		return fmt.Errorf("path must be a file directly in %s", o.Dir)
+	}
+	return nil
+}
+
+// ValidateSink checks the fields of a sink's type
+func ValidateSink(sink *models.AuditSink, options SinkOptions) error {
+	switch sink.Type {
+	case models.AuditSinkSyslog:
+		if sink.Network != "tcp" && sink.Network != "udp" {
+			return errors.New("network must be tcp or udp")
+		}
+		if _, _, err := net.SplitHostPort(sink.Address); err != nil {
+			return errors.New("address must be host:port")
+		}
+	case models.AuditSinkWebhook:
+		target, err := url.Parse(sink.URL)
+		if err != nil || target.Host == "" {
+			return errors.New("url must be an absolute URL")
+		}
+		if target.Scheme != "https" && !(target.Scheme == "http" && isLoopback(target.Hostname())) {
+			return errors.New("url must use https, http is only allowed for localhost")
+		}
+		if len(sink.Secret) < minSecretLength {
+			return fmt.Errorf("secret must be at least %d characters", minSecretLength)
+		}
+	case models.AuditSinkFile:
+		if err := options.checkPath(sink.Path); err != nil {
+			return err
+		}
+		if sink.MaxSizeMB < 0 || sink.MaxFiles < 0 {
+			return errors.New("max_size_mb and max_files cannot be negative")
+		}
+	default:
+		return errors.New("type must be syslog, webhook or file")
+	}
+	return nil
+}
+
+// NewSink opens the sink a configuration describes, with its secret
+// encrypted as stored
+func NewSink(config *models.AuditSink, options SinkOptions) (Sink, error) {
+	if config.Type == models.AuditSinkWebhook {
+		secret, err := secrets.Decrypt(options.Keys, config.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt secret: %w", err)
+		}
+		decrypted := *config
+		decrypted.Secret = secret
+		config = &decrypted
+	}
+
+	if err := ValidateSink(config, options); err != nil {
+		return nil, err
+	}
+
+	switch config.Type {
+	case models.AuditSinkSyslog:
+		return newSyslogSink(config.Network, config.Address), nil
+	case models.AuditSinkWebhook:
+		return newWebhookSink(config.URL, config.Secret), nil
+	}
+
+	maxSizeMB, maxFiles := config.MaxSizeMB, config.MaxFiles
+	if maxSizeMB == 0 {
+		maxSizeMB = defaultMaxSizeMB
+	}
+	if maxFiles == 0 {
+		maxFiles = defaultMaxFiles
+	}
+	return newFileSink(config.Path, int64(maxSizeMB)<<20, maxFiles)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,371 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"github.com/mysticrenji/surfer/backend/internal/secrets"
+)
+
+func TestValidateSink(t *testing.T) {
+	tests := []struct {
+		name  string
+		sink  models.AuditSink
+		valid bool
+	}{
+		{"syslog tcp", models.AuditSink{Type: models.AuditSinkSyslog, Network: "tcp", Address: "logs.example.com:6514"}, true},
+		{"syslog unknown network", models.AuditSink{Type: models.AuditSinkSyslog, Network: "unix", Address: "logs:514"}, false},
+		{"syslog without port", models.AuditSink{Type: models.AuditSinkSyslog, Network: "udp", Address: "logs.example.com"}, false},
+		{"webhook https", models.AuditSink{Type: models.AuditSinkWebhook, URL: "https://siem.example.com/ingest", Secret: "0123456789abcdef"}, true},
+		{"webhook http localhost", models.AuditSink{Type: models.AuditSinkWebhook, URL: "http://127.0.0.1:8081/", Secret: "0123456789abcdef"}, true},
+		{"webhook http remote", models.AuditSink{Type: models.AuditSinkWebhook, URL: "http://siem.example.com/", Secret: "0123456789abcdef"}, false},
+		{"webhook short secret", models.AuditSink{Type: models.AuditSinkWebhook, URL: "https://siem.example.com/", Secret: "short"}, false},
+		{"file", models.AuditSink{Type: models.AuditSinkFile, Path: "/var/log/surfer/audit.ndjson"}, true},
+		{"file relative", models.AuditSink{Type: models.AuditSinkFile, Path: "audit.ndjson"}, false},
+		{"file outside the sink directory", models.AuditSink{Type: models.AuditSinkFile, Path: "/etc/surfer/audit.ndjson"}, false},
+		{"file in a subdirectory", models.AuditSink{Type: models.AuditSinkFile, Path: "/var/log/surfer/old/audit.ndjson"}, false},
+		{"file with dot dot", models.AuditSink{Type: models.AuditSinkFile, Path: "/var/log/surfer/../surfer/audit.ndjson"}, false},
+		{"file the sink directory itself", models.AuditSink{Type: models.AuditSinkFile, Path: "/var/log/surfer"}, false},
+		{"unknown type", models.AuditSink{Type: "kafka"}, false},
+	}
+
+	options := SinkOptions{Dir: "/var/log/surfer"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSink(&tt.sink, options)
+			if (err == nil) != tt.valid {
+				t.Errorf("ValidateSink() error = %v, want valid %t", err, tt.valid)
+			}
+		})
+	}
+
+	if err := ValidateSink(&models.AuditSink{Type: models.AuditSinkFile, Path: "/var/log/surfer/audit.ndjson"}, SinkOptions{}); err == nil {
+		t.Error("ValidateSink() accepted a file sink without AUDIT_SINK_DIR")
+	}
+}
+
+func TestLoadSinkOptions(t *testing.T) {
+	t.Setenv("AUDIT_SINK_DIR", "")
+	if options, err := LoadSinkOptions(nil); err != nil || options.Dir != "" {
+		t.Errorf("LoadSinkOptions() = %+v, %v, want no directory", options, err)
+	}
+
+	dir := t.TempDir()
+	link := filepath.Join(t.TempDir(), "sinks")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatal(err)
+	}
+	resolved, _ := filepath.EvalSymlinks(dir)
+	t.Setenv("AUDIT_SINK_DIR", link)
+	if options, err := LoadSinkOptions(nil); err != nil || options.Dir != resolved {
+		t.Errorf("LoadSinkOptions() = %+v, %v, want %s", options, err, resolved)
+	}
+
+	for _, value := range []string{"relative", filepath.Join(dir, "missing")} {
+		t.Setenv("AUDIT_SINK_DIR", value)
+		if _, err := LoadSinkOptions(nil); err == nil {
+			t.Errorf("LoadSinkOptions() accepted AUDIT_SINK_DIR=%s", value)
+		}
+	}
+}
+
+func TestFormatSyslog(t *testing.T) {
+	entry := testEntry()
+	message, err := FormatSyslog(entry, "surfer-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := "<110>1 2024-02-01T12:00:00.000000Z surfer-0 surfer - delete - {"
+	if !strings.HasPrefix(message, prefix) {
+		t.Errorf("FormatSyslog() = %q, want prefix %q", message, prefix)
+	}
+
+	entry.Outcome = OutcomeDenied
+	message, _ = FormatSyslog(entry, "surfer-0")
+	if !strings.HasPrefix(message, "<109>1 ") {
+		t.Errorf("denied entry has priority %q, want <109>", message[:5])
+	}
+}
+
+func TestSyslogSinkTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		var length int
+		if _, err := fmt.Fscanf(reader, "%d ", &length); err != nil {
+			return
+		}
+		message := make([]byte, length)
+		io.ReadFull(reader, message)
+		received <- string(message)
+	}()
+
+	sink, err := NewSink(&models.AuditSink{Type: models.AuditSinkSyslog, Network: "tcp", Address: listener.Addr().String()}, SinkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	if err := sink.Send(testEntry()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	select {
+	case message := <-received:
+		if !strings.HasPrefix(message, "<110>1 ") || !strings.HasSuffix(message, "}") {
+			t.Errorf("received %q, want a whole RFC 5424 message", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+func TestSyslogSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := NewSink(&models.AuditSink{Type: models.AuditSinkSyslog, Network: "udp", Address: conn.LocalAddr().String()}, SinkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	if err := sink.Send(testEntry()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 8192)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message := string(buf[:n]); !strings.HasPrefix(message, "<110>1 ") {
+		t.Errorf("received %q, want an unframed RFC 5424 message", message)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	secret := "0123456789abcdef"
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		want := "sha256=" + WebhookSignature([]byte(secret), r.Header.Get("X-Surfer-Timestamp"), body)
+		if r.Header.Get("X-Surfer-Signature") != want {
+			t.Errorf("signature = %q, want %q", r.Header.Get("X-Surfer-Signature"), want)
+		}
+
+		var entry models.AuditLog
+		if err := json.Unmarshal(body, &entry); err != nil || entry.ID != 10 {
+			t.Errorf("body = %s, want entry 10", body)
+		}
+
+		// Fail the first attempt to exercise the retry
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := newWebhookSink(server.URL, secret)
+	sink.retryDelay = time.Millisecond
+
+	if err := sink.Send(testEntry()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+}
+
+func TestNewSinkDecryptsSecret(t *testing.T) {
+	keys, err := secrets.NewLocalKeyProvider(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := "0123456789abcdef"
+	encrypted, err := secrets.Encrypt(keys, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		want := "sha256=" + WebhookSignature([]byte(secret), r.Header.Get("X-Surfer-Timestamp"), body)
+		if r.Header.Get("X-Surfer-Signature") != want {
+			t.Errorf("signature = %q, want one keyed with the decrypted secret", r.Header.Get("X-Surfer-Signature"))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	config := &models.AuditSink{Type: models.AuditSinkWebhook, URL: server.URL, Secret: encrypted}
+	sink, err := NewSink(config, SinkOptions{Keys: keys})
+	if err != nil {
+		t.Fatalf("NewSink() error = %v", err)
+	}
+	if err := sink.Send(testEntry()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if config.Secret != encrypted {
+		t.Error("NewSink() changed the configuration's secret")
+	}
+}
+
+func TestWebhookSinkGivesUp(t *testing.T) {
+	tests := []struct {
+		status   int
+		attempts int32
+	}{
+		{http.StatusBadGateway, webhookAttempts},
+		{http.StatusTooManyRequests, webhookAttempts},
+		// Client errors are not retried
+		{http.StatusUnauthorized, 1},
+	}
+
+	for _, tt := range tests {
+		var attempts int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&attempts, 1)
+			w.WriteHeader(tt.status)
+		}))
+
+		sink := newWebhookSink(server.URL, "0123456789abcdef")
+		sink.retryDelay = time.Millisecond
+		if err := sink.Send(testEntry()); err == nil {
+			t.Errorf("status %d: Send() succeeded, want an error", tt.status)
+		}
+		if attempts != tt.attempts {
+			t.Errorf("status %d: attempts = %d, want %d", tt.status, attempts, tt.attempts)
+		}
+		server.Close()
+	}
+}
+
+func TestFileSinkRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.ndjson")
+	line, _ := json.Marshal(testEntry())
+
+	// Room for two entries per file
+	sink, err := newFileSink(path, int64(2*(len(line)+1)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	for i := 0; i < 7; i++ {
+		if err := sink.Send(testEntry()); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	lines := func(name string) int {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return -1
+		}
+		return strings.Count(string(data), "\n")
+	}
+
+	if got := lines(path); got != 1 {
+		t.Errorf("current file has %d entries, want 1", got)
+	}
+	if got := lines(path + ".1"); got != 2 {
+		t.Errorf("%s.1 has %d entries, want 2", path, got)
+	}
+	if got := lines(path + ".2"); got != 2 {
+		t.Errorf("%s.2 has %d entries, want 2", path, got)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists, want only 2 rotated files", path)
+	}
+
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("file mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestFileSinkRefusesSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "keys.pem")
+	if err := os.WriteFile(target, []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "audit.ndjson")
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newFileSink(path, 1<<20, 2); err == nil {
+		t.Error("newFileSink() opened a symlink")
+	}
+	os.Remove(path)
+
+	// Rotation would move the file the symlink points to
+	line, _ := json.Marshal(testEntry())
+	sink, err := newFileSink(path, int64(len(line)+1), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := os.Symlink(target, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	sink.Send(testEntry())
+	if err := sink.Send(testEntry()); err == nil {
+		t.Error("Send() rotated over a symlink")
+	}
+	if data, err := os.ReadFile(target); err != nil || string(data) != "key" {
+		t.Errorf("rotation changed the symlink's target: %q, %v", data, err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{4, 40 * time.Second},
+		{8, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := RetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("RetryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+// Syslog facility and severities of forwarded entries (RFC 5424)
+const (
+	syslogFacilityAudit  = 13
+	syslogSeverityInfo   = 6
+	syslogSeverityNotice = 5
+)
+
+// syslogTimeout bounds connecting to and writing to the syslog server
+const syslogTimeout = 10 * time.Second
+
+// syslogSink sends entries as RFC 5424 messages. Over TCP messages are framed
+// with octet counting (RFC 6587) on a connection that is reopened after
+// errors.
+type syslogSink struct {
+	network  string
+	address  string
+	hostname string
+	conn     net.Conn
+}
+
+func newSyslogSink(network, address string) *syslogSink {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &syslogSink{network: network, address: address, hostname: hostname}
+}
+
+func (s *syslogSink) Send(entry *models.AuditLog) error {
+	message, err := FormatSyslog(entry, s.hostname)
+	if err != nil {
+		return err
+	}
+	if s.network == "tcp" {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.address, syslogTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := s.conn.Write([]byte(message)); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+func (s *syslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// FormatSyslog formats an entry as an RFC 5424 message with the entry as JSON
+// in the message part. Denied and failed requests have severity notice.
+func FormatSyslog(entry *models.AuditLog, hostname string) (string, error) {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+
+	severity := syslogSeverityInfo
+	if entry.Outcome == OutcomeDenied || entry.Outcome == OutcomeFailure {
+		severity = syslogSeverityNotice
+	}
+
+	msgID := entry.Action
+	if msgID == "" || len(msgID) > 32 {
+		msgID = "-"
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s surfer - %s - %s",
+		syslogFacilityAudit*8+severity,
+		entry.CreatedAt.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		hostname,
+		msgID,
+		body,
+	), nil
+}
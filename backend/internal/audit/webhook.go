@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+const (
+	// webhookAttempts is how often a webhook sink tries to deliver an entry
+	// before leaving it to the outbox
+	webhookAttempts = 3
+	webhookTimeout  = 10 * time.Second
+)
+
+// webhookSink POSTs each entry as JSON. The X-Surfer-Signature header is
+// "sha256=" and the hex HMAC-SHA256 of the X-Surfer-Timestamp header, a dot
+// and the body, keyed with the sink's secret.
+type webhookSink struct {
+	url    string
+	secret []byte
+	client *http.Client
+	// retryDelay is the pause before the second attempt, doubled after each
+	retryDelay time.Duration
+}
+
+func newWebhookSink(url, secret string) *webhookSink {
+	return &webhookSink{
+		url:        url,
+		secret:     []byte(secret),
+		client:     &http.Client{Timeout: webhookTimeout},
+		retryDelay: 500 * time.Millisecond,
+	}
+}
+
+func (s *webhookSink) Send(entry *models.AuditLog) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	delay := s.retryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(body)
+		if err == nil || !retry || attempt == webhookAttempts {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying: network errors, 429 and 5xx responses
+func (s *webhookSink) post(body []byte) (bool, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "surfer-audit")
+	req.Header.Set("X-Surfer-Timestamp", timestamp)
+	req.Header.Set("X-Surfer-Signature", "sha256="+WebhookSignature(s.secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook returned %s", resp.Status)
+}
+
+func (s *webhookSink) Close() error {
+	return nil
+}
+
+// WebhookSignature returns the hex HMAC-SHA256 receivers recompute to check
+// a delivery
+func WebhookSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
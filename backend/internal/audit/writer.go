@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"log"
+	"sync/atomic"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// maxBatch is the most entries Writer inserts in one statement
+const maxBatch = 100
+
+// Recorder accepts audit log entries
+type Recorder interface {
+	// Record queues entry and may drop it under load
+	Record(entry *models.AuditLog)
+	// Write stores entry before returning, for entries that must not be lost
+	Write(entry *models.AuditLog) error
+}
+
+// Writer is a Recorder that stores entries from a buffered queue in the
+// background, so that a slow database does not hold up requests. Entries are
+// dropped, and counted, when the queue is full.
+type Writer struct {
+	db      *gorm.DB
+	queue   chan *models.AuditLog
+	dropped atomic.Int64
+}
+
+func NewWriter(db *gorm.DB, size int) *Writer {
+	return &Writer{db: db, queue: make(chan *models.AuditLog, size)}
+}
+
+// Record queues entry without blocking
+func (w *Writer) Record(entry *models.AuditLog) {
+	select {
+	case w.queue <- entry:
+	default:
+		dropped := w.dropped.Add(1)
+		log.Printf("Audit log queue full, dropped %s %s by user %d (%d dropped in total)", entry.Action, entry.Resource, entry.UserID, dropped)
+	}
+}
+
+// Write appends entry to the chain right away, bypassing the queue
+func (w *Writer) Write(entry *models.AuditLog) error {
+	return Append(w.db, entry)
+}
+
+// Dropped returns how many entries were dropped because the queue was full
+func (w *Writer) Dropped() int64 {
+	return w.dropped.Load()
+}
+
+// Run stores queued entries, batching those that queued up during the previous
+// insert. It does not return.
+func (w *Writer) Run() {
+	for entry := range w.queue {
+		batch := []*models.AuditLog{entry}
+	drain:
+		for len(batch) < maxBatch {
+			select {
+			case next := <-w.queue:
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+
+		if err := Append(w.db, batch...); err != nil {
+			log.Printf("Failed to write %d audit log entries: %v", len(batch), err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/audit"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// PendingAccessRequestTTL is how long an access request waits for review
+// before it expires
+const PendingAccessRequestTTL = 24 * time.Hour
+
+// AccessRequestExpired reports whether a request should be marked expired:
+// approved and past its expiry, or pending for longer than
+// PendingAccessRequestTTL
+func AccessRequestExpired(request *models.AccessRequest, now time.Time) bool {
+	switch request.Status {
+	case models.AccessRequestApproved:
+		return request.ExpiresAt != nil && !now.Before(*request.ExpiresAt)
+	case models.AccessRequestPending:
+		return !now.Before(request.CreatedAt.Add(PendingAccessRequestTTL))
+	}
+	return false
+}
+
+// ExpireAccessRequests marks the requests AccessRequestExpired selects as
+// expired and writes an audit log entry for each. Audit entries of the
+// reaper are about the requester and have actor type system.
+func ExpireAccessRequests(db *gorm.DB, now time.Time) (int, error) {
+	var candidates []models.AccessRequest
+	if err := db.Where("(status = ? AND expires_at <= ?) OR (status = ? AND created_at <= ?)",
+		models.AccessRequestApproved, now,
+		models.AccessRequestPending, now.Add(-PendingAccessRequestTTL)).
+		Find(&candidates).Error; err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for i := range candidates {
+		request := &candidates[i]
+		if !AccessRequestExpired(request, now) {
+			continue
+		}
+
+		changed := false
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// Skip requests that were reviewed or cancelled in the meantime
+			result := tx.Model(&models.AccessRequest{}).
+				Where("id = ? AND status = ?", request.ID, request.Status).
+				Updates(map[string]interface{}{"status": models.AccessRequestExpired, "ended_at": now})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+
+			changed = true
+			return audit.Append(tx, &models.AuditLog{
+				UserID:     request.UserID,
+				ActorType:  models.ActorTypeSystem,
+				Action:     "expire",
+				Resource:   "access_request",
+				ResourceID: fmt.Sprint(request.ID),
+				Details:    fmt.Sprintf("was=%s cluster=%d namespace=%s level=%s", request.Status, request.ClusterID, request.Namespace, request.Level),
+			})
+		})
+		if err != nil {
+			return expired, err
+		}
+		if changed {
+			expired++
+		}
+	}
+
+	return expired, nil
+}
+
+// RunRequestReaper expires access requests and change requests every
+// interval. It does not return.
+func RunRequestReaper(db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		expired, err := ExpireAccessRequests(db, now)
+		if err != nil {
+			log.Printf("Failed to expire access requests: %v", err)
+		}
+		if expired > 0 {
+			log.Printf("Expired %d access requests", expired)
+		}
+
+		expired, err = ExpireChangeRequests(db, now)
+		if err != nil {
+			log.Printf("Failed to expire change requests: %v", err)
+		}
+		if expired > 0 {
+			log.Printf("Expired %d change requests", expired)
+		}
+	}
+}
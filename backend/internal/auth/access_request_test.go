@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+func TestAccessRequestExpired(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+
+	testCases := []struct {
+		name    string
+		request models.AccessRequest
+		want    bool
+	}{
+		{"approved and running", models.AccessRequest{Status: models.AccessRequestApproved, ExpiresAt: &future}, false},
+		{"approved and over", models.AccessRequest{Status: models.AccessRequestApproved, ExpiresAt: &past}, true},
+		{"recently requested", models.AccessRequest{Status: models.AccessRequestPending, CreatedAt: now.Add(-time.Hour)}, false},
+		{"never reviewed", models.AccessRequest{Status: models.AccessRequestPending, CreatedAt: now.Add(-PendingAccessRequestTTL)}, true},
+		{"denied", models.AccessRequest{Status: models.AccessRequestDenied, CreatedAt: now.Add(-48 * time.Hour)}, false},
+		{"already expired", models.AccessRequest{Status: models.AccessRequestExpired, ExpiresAt: &past}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := AccessRequestExpired(&tc.request, now); got != tc.want {
+				t.Errorf("Expected %t, got %t", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// NormalizeApprovalRule validates a rule and brings its value into the form
+// it is matched in
+func NormalizeApprovalRule(rule *models.ApprovalRule) error {
+	rule.Value = strings.TrimSpace(rule.Value)
+	rule.Provider = strings.ToLower(strings.TrimSpace(rule.Provider))
+
+	switch rule.MatchType {
+	case models.ApprovalMatchEmailDomain:
+		rule.Value = strings.ToLower(strings.TrimPrefix(rule.Value, "@"))
+		if rule.Value == "" || strings.Contains(rule.Value, "@") {
+			return errors.New("email_domain rules need a domain such as example.com")
+		}
+	case models.ApprovalMatchGroup:
+		if rule.Value == "" {
+			return errors.New("group rules need a group name")
+		}
+	default:
+		return errors.New("match_type must be 'email_domain' or 'group'")
+	}
+
+	// Whether the role exists is checked against the roles table by the caller
+	rule.Role = strings.TrimSpace(rule.Role)
+	if rule.Role == "" || rule.Role == "pending" {
+		return errors.New("role must name a role such as 'viewer' or 'operator'")
+	}
+
+	return nil
+}
+
+// ApprovalRuleMatches reports whether a rule matches a user. Email domains
+// match exactly, so example.com does not match mail.example.com, and only
+// when an identity's provider verified the address. Groups come from the
+// user's identities and are compared case-sensitively.
+func ApprovalRuleMatches(rule *models.ApprovalRule, user *models.User) bool {
+	switch rule.MatchType {
+	case models.ApprovalMatchEmailDomain:
+		at := strings.LastIndex(user.Email, "@")
+		return at >= 0 && strings.EqualFold(user.Email[at+1:], rule.Value) && emailVerified(user)
+	case models.ApprovalMatchGroup:
+		return identitiesHaveGroup(user.Identities, rule.Provider, rule.Value)
+	}
+	return false
+}
+
+// emailVerified reports whether a provider verified the user's email
+func emailVerified(user *models.User) bool {
+	for _, identity := range user.Identities {
+		if identity.EmailVerified && strings.EqualFold(identity.Email, user.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectApprovalRule returns the enabled rule to approve the user with, or nil
+// when none matches. A rule granting admin wins, otherwise the oldest rule.
+func SelectApprovalRule(rules []models.ApprovalRule, user *models.User) *models.ApprovalRule {
+	var selected *models.ApprovalRule
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Disabled || !ApprovalRuleMatches(rule, user) {
+			continue
+		}
+		isAdmin, selectedAdmin := rule.Role == models.RoleAdmin, selected != nil && selected.Role == models.RoleAdmin
+		if selected == nil || (isAdmin && !selectedAdmin) || (isAdmin == selectedAdmin && rule.ID < selected.ID) {
+			selected = rule
+		}
+	}
+	return selected
+}
+
+// ApproveByRule approves a pending user with the role of a rule. It returns
+// false when the user was no longer pending.
+func ApproveByRule(db *gorm.DB, user *models.User, rule *models.ApprovalRule) (bool, error) {
+	now := time.Now()
+	result := db.Model(&models.User{}).
+		Where("id = ? AND status = ? AND type = ?", user.ID, "pending", models.UserTypeHuman).
+		Updates(map[string]interface{}{
+			"status":           "approved",
+			"role":             rule.Role,
+			"approved_at":      now,
+			"approved_by_rule": rule.ID,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	user.Status = "approved"
+	user.Role = rule.Role
+	user.ApprovedAt = &now
+	user.ApprovedByRule = &rule.ID
+	return true, nil
+}
+
+// applyApprovalRules approves a pending user at login when an enabled rule
+// matches. The user's identities must be loaded.
+func applyApprovalRules(tx *gorm.DB, user *models.User) error {
+	if user.Status != "pending" || user.IsServiceAccount() {
+		return nil
+	}
+
+	var rules []models.ApprovalRule
+	if err := tx.Where("disabled = ?", false).Find(&rules).Error; err != nil {
+		return err
+	}
+
+	rule := SelectApprovalRule(rules, user)
+	if rule == nil {
+		return nil
+	}
+
+	_, err := ApproveByRule(tx, user, rule)
+	return err
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+func TestNormalizeApprovalRule(t *testing.T) {
+	rule := models.ApprovalRule{MatchType: models.ApprovalMatchEmailDomain, Value: " @OurCo.com ", Role: "operator"}
+	if err := NormalizeApprovalRule(&rule); err != nil {
+		t.Fatalf("Failed to normalize rule: %v", err)
+	}
+	if rule.Value != "ourco.com" {
+		t.Errorf("Expected domain ourco.com, got %s", rule.Value)
+	}
+
+	invalid := []models.ApprovalRule{
+		{MatchType: models.ApprovalMatchEmailDomain, Value: "jane@ourco.com", Role: "operator"},
+		{MatchType: models.ApprovalMatchGroup, Value: "", Role: "operator"},
+		{MatchType: "name", Value: "jane", Role: "operator"},
+		{MatchType: models.ApprovalMatchGroup, Value: "sre", Role: "pending"},
+	}
+	for _, rule := range invalid {
+		if err := NormalizeApprovalRule(&rule); err == nil {
+			t.Errorf("Expected an error for %+v", rule)
+		}
+	}
+}
+
+func TestApprovalRuleMatches(t *testing.T) {
+	user := &models.User{
+		Email: "jane@OurCo.com",
+		Identities: []models.UserIdentity{
+			{Provider: "keycloak", Email: "jane@OurCo.com", EmailVerified: true, Groups: []string{"sre", "developers"}},
+		},
+	}
+	unverified := &models.User{
+		Email:      "jane@ourco.com",
+		Identities: []models.UserIdentity{{Provider: "keycloak", Email: "jane@ourco.com"}},
+	}
+
+	testCases := []struct {
+		name string
+		rule models.ApprovalRule
+		want bool
+	}{
+		{"email domain", models.ApprovalRule{MatchType: models.ApprovalMatchEmailDomain, Value: "ourco.com"}, true},
+		{"other domain", models.ApprovalRule{MatchType: models.ApprovalMatchEmailDomain, Value: "other.com"}, false},
+		{"parent domain", models.ApprovalRule{MatchType: models.ApprovalMatchEmailDomain, Value: "co.com"}, false},
+		{"group", models.ApprovalRule{MatchType: models.ApprovalMatchGroup, Value: "sre"}, true},
+		{"group of provider", models.ApprovalRule{MatchType: models.ApprovalMatchGroup, Value: "sre", Provider: "keycloak"}, true},
+		{"group of other provider", models.ApprovalRule{MatchType: models.ApprovalMatchGroup, Value: "sre", Provider: "okta"}, false},
+		{"group case", models.ApprovalRule{MatchType: models.ApprovalMatchGroup, Value: "SRE"}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ApprovalRuleMatches(&tc.rule, user); got != tc.want {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	rule := &models.ApprovalRule{MatchType: models.ApprovalMatchEmailDomain, Value: "ourco.com"}
+	if ApprovalRuleMatches(rule, unverified) {
+		t.Error("Expected an unverified email not to match an email domain rule")
+	}
+}
+
+func TestSelectApprovalRule(t *testing.T) {
+	user := &models.User{
+		Email:      "jane@ourco.com",
+		Identities: []models.UserIdentity{{Provider: "keycloak", Email: "jane@ourco.com", EmailVerified: true, Groups: []string{"sre"}}},
+	}
+
+	rules := []models.ApprovalRule{
+		{ID: 1, MatchType: models.ApprovalMatchEmailDomain, Value: "ourco.com", Role: "operator"},
+		{ID: 2, MatchType: models.ApprovalMatchGroup, Value: "sre", Role: "admin"},
+		{ID: 3, MatchType: models.ApprovalMatchGroup, Value: "sre", Role: "admin"},
+	}
+
+	if rule := SelectApprovalRule(rules, user); rule == nil || rule.ID != 2 {
+		t.Errorf("Expected the oldest admin rule to win, got %+v", rule)
+	}
+
+	rules[1].Disabled = true
+	rules[2].Disabled = true
+	if rule := SelectApprovalRule(rules, user); rule == nil || rule.ID != 1 {
+		t.Errorf("Expected disabled rules to be skipped, got %+v", rule)
+	}
+
+	rules = append([]models.ApprovalRule{{ID: 4, MatchType: models.ApprovalMatchGroup, Value: "sre", Role: "viewer"}}, rules...)
+	if rule := SelectApprovalRule(rules, user); rule == nil || rule.ID != 1 {
+		t.Errorf("Expected the oldest non-admin rule to win, got %+v", rule)
+	}
+
+	if rule := SelectApprovalRule(rules, &models.User{Email: "eve@example.com"}); rule != nil {
+		t.Errorf("Expected no rule to match, got %+v", rule)
+	}
+}
@@ -1,32 +1,44 @@
 package auth
 
 import (
-	"context"
-	"encoding/json"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"sync"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/mysticrenji/surfer/backend/internal/audit"
+	"github.com/mysticrenji/surfer/backend/internal/models"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
+	"gorm.io/gorm"
+)
+
+const (
+	// AccessTokenTTL is the lifetime of tokens issued by GenerateToken
+	AccessTokenTTL = 15 * time.Minute
+	// SessionTTL is the absolute lifetime of a login session; refresh tokens
+	// can renew access tokens until the session expires
+	SessionTTL = 7 * 24 * time.Hour
 )
 
 type AuthService struct {
-	config *oauth2.Config
-	states map[string]time.Time
-	mutex  sync.RWMutex
+	db          *gorm.DB
+	keys        *KeySet
+	sessions    SessionStore
+	states      StateStore
+	providers   map[string]Provider
+	frontendURL string
 }
 
-type GoogleUser struct {
-	ID      string `json:"id"`
-	Email   string `json:"email"`
-	Name    string `json:"name"`
-	Picture string `json:"picture"`
-}
+// stateCookie binds a pending login to the browser that started it
+const stateCookie = "oauth_state"
 
 type Claims struct {
 	UserID uint   `json:"user_id"`
@@ -35,22 +47,19 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
-func NewAuthService() *AuthService {
-	config := &oauth2.Config{
-		ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-		ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
-		RedirectURL:  os.Getenv("GOOGLE_REDIRECT_URL"),
-		Scopes: []string{
-			"https://www.googleapis.com/auth/userinfo.email",
-			"https://www.googleapis.com/auth/userinfo.profile",
-		},
-		Endpoint: google.Endpoint,
+func NewAuthService(db *gorm.DB, keys *KeySet, sessions SessionStore, states StateStore, providers map[string]Provider) *AuthService {
+	frontendURL := os.Getenv("FRONTEND_URL")
+	if frontendURL == "" {
+		frontendURL = "http://localhost:3000"
 	}
 
 	authService := &AuthService{
-		config: config,
-		states: make(map[string]time.Time),
-		mutex:  sync.RWMutex{},
+		db:          db,
+		keys:        keys,
+		sessions:    sessions,
+		states:      states,
+		providers:   providers,
+		frontendURL: strings.TrimRight(frontendURL, "/"),
 	}
 
 	// Start cleanup goroutine for expired states
@@ -59,145 +68,464 @@ func NewAuthService() *AuthService {
 	return authService
 }
 
-func (a *AuthService) HandleGoogleLogin(c *gin.Context) {
-	state := generateRandomState()
-	url := a.config.AuthCodeURL(state)
+// HandleListProviders returns the enabled login providers for the login page
+func (a *AuthService) HandleListProviders(c *gin.Context) {
+	providers := make([]gin.H, 0, len(a.providers))
+	for _, provider := range a.providers {
+		providers = append(providers, gin.H{
+			"name":         provider.Name(),
+			"display_name": provider.DisplayName(),
+		})
+	}
+
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i]["name"].(string) < providers[j]["name"].(string)
+	})
+
+	c.JSON(http.StatusOK, providers)
+}
+
+func (a *AuthService) HandleLogin(c *gin.Context) {
+	loginURL, ok := a.startLogin(c, 0)
+	if !ok {
+		return
+	}
+
+	// Browsers navigating here directly are sent on to the provider, which
+	// guarantees the cookie is set even when the frontend is on another origin
+	if c.Query("redirect") == "true" {
+		c.Redirect(http.StatusFound, loginURL)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url": loginURL,
+	})
+}
 
-	// Store state in memory with expiration (5 minutes)
-	a.mutex.Lock()
-	a.states[state] = time.Now().Add(5 * time.Minute)
-	a.mutex.Unlock()
+// HandleLink starts linking an identity at another provider to the caller's
+// account. The frontend must call it with credentials so that the state
+// cookie is set in the browser that completes the login at the provider.
+func (a *AuthService) HandleLink(c *gin.Context) {
+	// Access tokens could otherwise attach a login to the account
+	if c.GetString("auth_method") != "session" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Identities can only be linked from a login session"})
+		return
+	}
 
-	// Also store in cookie for backward compatibility
-	c.SetCookie("oauth_state", state, 300, "/", "localhost", false, true)
+	linkURL, ok := a.startLogin(c, c.GetUint("user_id"))
+	if !ok {
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"url": url,
+		"url": linkURL,
+	})
+}
+
+// startLogin saves the state of a login at the provider in the :provider
+// parameter, or of linking an identity to linkUserID, and binds it to the
+// browser with the state cookie. It returns the provider's URL, or writes the
+// error response.
+func (a *AuthService) startLogin(c *gin.Context, linkUserID uint) (string, bool) {
+	provider, ok := a.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return "", false
+	}
+
+	state, err := generateRandomState()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return "", false
+	}
+	nonce, err := newTokenID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return "", false
+	}
+	codeVerifier := oauth2.GenerateVerifier()
+
+	loginURL, err := provider.AuthCodeURL(state, nonce, codeVerifier)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Login provider is unavailable"})
+		return "", false
+	}
+
+	err = a.states.Save(&LoginState{
+		State:        state,
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(StateTTL),
 	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return "", false
+	}
+
+	// Bind the state to this browser. SameSite=Lax still sends the cookie on
+	// the top-level redirect back from the provider.
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(stateCookie, a.keys.SignValue(state), int(StateTTL.Seconds()), "/", "", isSecureRequest(c), true)
+	return loginURL, true
 }
 
-func (a *AuthService) HandleGoogleCallback(c *gin.Context) {
-	// Verify state using in-memory store
+func (a *AuthService) HandleCallback(c *gin.Context) {
+	provider, ok := a.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return
+	}
+
 	state := c.Query("state")
 	if state == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing state parameter"})
 		return
 	}
 
-	// Check if state exists and is not expired
-	a.mutex.RLock()
-	expiration, exists := a.states[state]
-	a.mutex.RUnlock()
+	// The state must come back to the browser that started the login
+	cookie, err := c.Cookie(stateCookie)
+	c.SetCookie(stateCookie, "", -1, "/", "", isSecureRequest(c), true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing state cookie"})
+		return
+	}
+	if bound, ok := a.keys.VerifyValue(cookie); !ok || subtle.ConstantTimeCompare([]byte(bound), []byte(state)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "State does not match this browser"})
+		return
+	}
 
-	if !exists || time.Now().After(expiration) {
+	// Consuming deletes the state, so a callback can only be replayed once
+	login, err := a.states.Consume(state)
+	if err != nil || login.Provider != provider.Name() {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired state"})
 		return
 	}
 
-	// Remove the used state
-	a.mutex.Lock()
-	delete(a.states, state)
-	a.mutex.Unlock()
-
-	// Exchange code for token
-	code := c.Query("code")
-	token, err := a.config.Exchange(context.Background(), code)
+	identity, err := provider.Exchange(c.Request.Context(), c.Query("code"), login.Nonce, login.CodeVerifier)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to exchange token"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to authenticate with login provider"})
 		return
 	}
 
-	// Get user info
-	client := a.config.Client(context.Background(), token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info"})
+	if login.LinkUserID != 0 {
+		switch err := a.linkIdentity(login.LinkUserID, identity, c.ClientIP()); {
+		case errors.Is(err, ErrIdentityInUse):
+			c.Redirect(http.StatusFound, a.frontendURL+"/?error=identity_in_use")
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link identity"})
+		default:
+			c.Redirect(http.StatusFound, a.frontendURL+"/?linked="+url.QueryEscape(provider.Name()))
+		}
 		return
 	}
-	defer resp.Body.Close()
 
-	var googleUser GoogleUser
-	if err := json.NewDecoder(resp.Body).Decode(&googleUser); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode user info"})
+	user, err := a.upsertUser(identity)
+	if errors.Is(err, ErrServiceAccountLogin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Service accounts cannot log in"})
+		return
+	}
+	if errors.Is(err, ErrIdentityNotLinked) {
+		c.Redirect(http.StatusFound, a.frontendURL+"/login?error=identity_not_linked")
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store user"})
 		return
 	}
 
-	// TODO: Store or update user in database and create session
-	// For now, return user info
-	c.JSON(http.StatusOK, gin.H{
-		"user": googleUser,
-		"message": "Authentication successful. Please wait for admin approval.",
+	switch user.Status {
+	case "approved":
+		accessToken, refreshToken, err := a.createSession(user, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+			return
+		}
+		// The fragment is never sent to a server, so the tokens stay out of
+		// access logs and Referer headers
+		params := url.Values{}
+		params.Set("token", accessToken)
+		params.Set("refresh_token", refreshToken)
+		c.Redirect(http.StatusFound, a.frontendURL+"/auth/callback#"+params.Encode())
+	case "rejected":
+		c.Redirect(http.StatusFound, a.frontendURL+"/login?error=account_rejected")
+	default:
+		c.Redirect(http.StatusFound, a.frontendURL+"/pending-approval")
+	}
+}
+
+// ErrServiceAccountLogin is returned when a provider identity resolves to a
+// service account, which can only authenticate with access tokens
+var ErrServiceAccountLogin = errors.New("service accounts cannot log in")
+
+// ErrIdentityNotLinked is returned when an unknown provider identity has the
+// email of an existing user. Identities are never linked by email, since any
+// configured provider could claim any address; the user links them with
+// HandleLink instead.
+var ErrIdentityNotLinked = errors.New("identity is not linked to the user with its email")
+
+// ErrIdentityInUse is returned when linking an identity that belongs to
+// another user
+var ErrIdentityInUse = errors.New("identity is linked to another user")
+
+// upsertUser resolves the provider identity to a user. Known identities map
+// straight to their user, and a pending user is created for new ones.
+// Profile fields, groups and synced team memberships are refreshed on every
+// login, and pending users are checked against the approval rules.
+func (a *AuthService) upsertUser(identity *Identity) (*models.User, error) {
+	var user models.User
+
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		var link models.UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&link).Error
+		if err == nil {
+			err = tx.First(&user, link.UserID).Error
+		} else if errors.Is(err, gorm.ErrRecordNotFound) {
+			var existing int64
+			if err := tx.Unscoped().Model(&models.User{}).Where("email = ?", identity.Email).Count(&existing).Error; err != nil {
+				return err
+			}
+			if existing > 0 {
+				return ErrIdentityNotLinked
+			}
+		}
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			user = models.User{
+				Email:   identity.Email,
+				Name:    identity.Name,
+				Picture: identity.Picture,
+				Role:    "pending",
+				Status:  "pending",
+			}
+			if identity.Provider == "google" {
+				user.GoogleID = identity.Subject
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		} else if user.IsServiceAccount() {
+			return ErrServiceAccountLogin
+		} else {
+			updates := map[string]interface{}{
+				"name":    identity.Name,
+				"picture": identity.Picture,
+			}
+			// Only a verified address replaces the account's email
+			if identity.EmailVerified {
+				updates["email"] = identity.Email
+			}
+			if identity.Provider == "google" {
+				updates["google_id"] = identity.Subject
+			}
+			if err := tx.Model(&user).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := saveIdentity(tx, &user, &link, identity); err != nil {
+			return err
+		}
+		return applyApprovalRules(tx, &user)
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
 }
 
-func (a *AuthService) HandleLogout(c *gin.Context) {
-	// Clear authentication cookie
-	c.SetCookie("auth_token", "", -1, "/", "", false, true)
-	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+// linkIdentity links a provider identity to an existing user, who started
+// the login with HandleLink, and records it in the audit log
+func (a *AuthService) linkIdentity(userID uint, identity *Identity, ipAddress string) error {
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		if user.IsServiceAccount() {
+			return ErrServiceAccountLogin
+		}
+
+		var link models.UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&link).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil && link.UserID != user.ID {
+			return ErrIdentityInUse
+		}
+
+		if err := saveIdentity(tx, &user, &link, identity); err != nil {
+			return err
+		}
+		return audit.Append(tx, &models.AuditLog{
+			UserID:     user.ID,
+			Action:     "link",
+			Resource:   "identity",
+			ResourceID: identity.Provider,
+			Details:    fmt.Sprintf("subject=%s email=%s", identity.Subject, identity.Email),
+			IPAddress:  ipAddress,
+			Outcome:    audit.OutcomeSuccess,
+		})
+	})
 }
 
-func GenerateToken(userID uint, email, role string) (string, error) {
-	claims := &Claims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+// saveIdentity creates or refreshes the user's identity row, link when it
+// exists, then reloads the user's identities and syncs their teams
+func saveIdentity(tx *gorm.DB, user *models.User, link *models.UserIdentity, identity *Identity) error {
+	var err error
+	if link.ID != 0 {
+		// Select so that an empty group list is written too
+		err = tx.Model(link).Select("email", "email_verified", "groups").
+			Updates(&models.UserIdentity{Email: identity.Email, EmailVerified: identity.EmailVerified, Groups: identity.Groups}).Error
+	} else {
+		err = tx.Create(&models.UserIdentity{
+			UserID:        user.ID,
+			Provider:      identity.Provider,
+			Subject:       identity.Subject,
+			Email:         identity.Email,
+			EmailVerified: identity.EmailVerified,
+			Groups:        identity.Groups,
+		}).Error
+	}
+	if err != nil {
+		return err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "your-secret-key-change-this"
+	if err := tx.Where("user_id = ?", user.ID).Find(&user.Identities).Error; err != nil {
+		return err
 	}
+	return syncTeams(tx, user)
+}
 
-	return token.SignedString([]byte(secret))
+// createSession issues an access token and a refresh token for the user and
+// records the session row the access token's jti points to
+func (a *AuthService) createSession(user *models.User, ipAddress, userAgent string) (string, string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := a.keys.GenerateToken(user.ID, user.Email, user.Role, tokenID)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, refreshTokenHash, err := newRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	session := models.Session{
+		UserID:    user.ID,
+		Token:     tokenID,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		ExpiresAt: time.Now().Add(SessionTTL),
+	}
+	if err := a.sessions.Create(&session, refreshTokenHash); err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
 }
 
-func ValidateToken(tokenString string) (*Claims, error) {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "your-secret-key-change-this"
+// HandleRefresh exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token can be used once.
+func (a *AuthService) HandleRefresh(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokenID, err := newTokenID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	refreshToken, refreshTokenHash, err := newRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	session, err := a.sessions.Rotate(hashToken(req.RefreshToken), refreshTokenHash, tokenID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRefreshTokenReused):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, session revoked"})
+		case errors.Is(err, ErrRefreshTokenInvalid), errors.Is(err, ErrSessionInvalid):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
 		}
-		return []byte(secret), nil
-	})
+		return
+	}
 
+	// Reload the user so that role changes and rejections take effect on refresh
+	var user models.User
+	if err := a.db.First(&user, session.UserID).Error; err != nil || user.Status != "approved" {
+		a.sessions.Revoke(session.UserID, session.ID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User is not approved"})
+		return
+	}
+
+	accessToken, err := a.keys.GenerateToken(user.ID, user.Email, user.Role, tokenID)
 	if err != nil {
-		return nil, err
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	c.JSON(http.StatusOK, gin.H{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(AccessTokenTTL.Seconds()),
+	})
+}
+
+func (a *AuthService) HandleLogout(c *gin.Context) {
+	// Revoke the session behind the presented token, if any. Logout stays
+	// public so that clients holding an already invalid token can still call it.
+	if token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); token != "" {
+		if claims, err := a.keys.ValidateToken(token); err == nil {
+			if session, err := a.sessions.Validate(claims.ID, claims.UserID); err == nil {
+				if err := a.sessions.Revoke(claims.UserID, session.ID); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+					return
+				}
+			}
+		}
 	}
 
-	return nil, fmt.Errorf("invalid token")
+	// Clear authentication cookie
+	c.SetCookie("auth_token", "", -1, "/", "", false, true)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
-// cleanupExpiredStates periodically removes expired states from memory
+// cleanupExpiredStates periodically removes logins that were never completed
 func (a *AuthService) cleanupExpiredStates() {
 	ticker := time.NewTicker(5 * time.Minute) // Clean up every 5 minutes
 	defer ticker.Stop()
 
 	for range ticker.C {
-		now := time.Now()
-		a.mutex.Lock()
-		for state, expiration := range a.states {
-			if now.After(expiration) {
-				delete(a.states, state)
-			}
+		if err := a.states.DeleteExpired(); err != nil {
+			log.Printf("Failed to delete expired OAuth states: %v", err)
 		}
-		a.mutex.Unlock()
 	}
 }
 
-func generateRandomState() string {
-	// In production, use a cryptographically secure random generator
-	return fmt.Sprintf("%d", time.Now().UnixNano())
+// isSecureRequest reports whether the client reached us over HTTPS, directly
+// or through a TLS-terminating proxy
+func isSecureRequest(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
 }
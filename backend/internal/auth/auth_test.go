@@ -1,21 +1,37 @@
 package auth
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"os"
 	"testing"
 	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
+func newTestKeySet(t *testing.T) *KeySet {
+	t.Helper()
+	keys, err := GenerateKeySet("surfer")
+	if err != nil {
+		t.Fatalf("Failed to generate key set: %v", err)
+	}
+	return keys
+}
+
 func TestGenerateToken(t *testing.T) {
-	// Set JWT secret for testing
-	os.Setenv("JWT_SECRET", "test-secret-key")
-	defer os.Unsetenv("JWT_SECRET")
+	keys := newTestKeySet(t)
 
 	userID := uint(1)
 	email := "test@example.com"
 	role := "user"
 
-	token, err := GenerateToken(userID, email, role)
+	token, err := keys.GenerateToken(userID, email, role, "test-token-id")
 	if err != nil {
 		t.Fatalf("Failed to generate token: %v", err)
 	}
@@ -26,22 +42,20 @@ func TestGenerateToken(t *testing.T) {
 }
 
 func TestValidateToken(t *testing.T) {
-	// Set JWT secret for testing
-	os.Setenv("JWT_SECRET", "test-secret-key")
-	defer os.Unsetenv("JWT_SECRET")
+	keys := newTestKeySet(t)
 
 	userID := uint(1)
 	email := "test@example.com"
 	role := "user"
 
 	// Generate a token
-	token, err := GenerateToken(userID, email, role)
+	token, err := keys.GenerateToken(userID, email, role, "test-token-id")
 	if err != nil {
 		t.Fatalf("Failed to generate token: %v", err)
 	}
 
 	// Validate the token
-	claims, err := ValidateToken(token)
+	claims, err := keys.ValidateToken(token)
 	if err != nil {
 		t.Fatalf("Failed to validate token: %v", err)
 	}
@@ -60,24 +74,36 @@ func TestValidateToken(t *testing.T) {
 }
 
 func TestValidateTokenInvalid(t *testing.T) {
-	os.Setenv("JWT_SECRET", "test-secret-key")
-	defer os.Unsetenv("JWT_SECRET")
+	keys := newTestKeySet(t)
 
 	invalidToken := "invalid.token.here"
 
-	_, err := ValidateToken(invalidToken)
+	_, err := keys.ValidateToken(invalidToken)
 	if err == nil {
 		t.Error("Expected error when validating invalid token")
 	}
 }
 
 func TestValidateTokenExpired(t *testing.T) {
-	os.Setenv("JWT_SECRET", "test-secret-key")
-	defer os.Unsetenv("JWT_SECRET")
+	keys := newTestKeySet(t)
+
+	token := jwt.NewWithClaims(keys.signing.method, &Claims{
+		UserID: 1,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "surfer",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+	})
+	token.Header["kid"] = keys.signing.kid
+	signed, err := token.SignedString(keys.signingKey)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
 
-	// This test would require manually creating an expired token
-	// For now, we'll just test that validation works correctly
-	// In a real scenario, you'd use a library to create expired tokens for testing
+	_, err = keys.ValidateToken(signed)
+	if !errors.Is(err, jwt.ErrTokenExpired) {
+		t.Errorf("Expected expired token error, got %v", err)
+	}
 }
 
 func TestTokenClaims(t *testing.T) {
@@ -101,8 +127,7 @@ func TestTokenClaims(t *testing.T) {
 }
 
 func TestGenerateTokenWithDifferentRoles(t *testing.T) {
-	os.Setenv("JWT_SECRET", "test-secret-key")
-	defer os.Unsetenv("JWT_SECRET")
+	keys := newTestKeySet(t)
 
 	testCases := []struct {
 		role string
@@ -113,12 +138,12 @@ func TestGenerateTokenWithDifferentRoles(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		token, err := GenerateToken(1, "test@example.com", tc.role)
+		token, err := keys.GenerateToken(1, "test@example.com", tc.role, "test-token-id")
 		if err != nil {
 			t.Fatalf("Failed to generate token for role %s: %v", tc.role, err)
 		}
 
-		claims, err := ValidateToken(token)
+		claims, err := keys.ValidateToken(token)
 		if err != nil {
 			t.Fatalf("Failed to validate token for role %s: %v", tc.role, err)
 		}
@@ -130,15 +155,14 @@ func TestGenerateTokenWithDifferentRoles(t *testing.T) {
 }
 
 func TestTokenExpiration(t *testing.T) {
-	os.Setenv("JWT_SECRET", "test-secret-key")
-	defer os.Unsetenv("JWT_SECRET")
+	keys := newTestKeySet(t)
 
-	token, err := GenerateToken(1, "test@example.com", "user")
+	token, err := keys.GenerateToken(1, "test@example.com", "user", "test-token-id")
 	if err != nil {
 		t.Fatalf("Failed to generate token: %v", err)
 	}
 
-	claims, err := ValidateToken(token)
+	claims, err := keys.ValidateToken(token)
 	if err != nil {
 		t.Fatalf("Failed to validate token: %v", err)
 	}
@@ -152,26 +176,375 @@ func TestTokenExpiration(t *testing.T) {
 		t.Error("Expected token to not be expired")
 	}
 
-	// Check that expiration is approximately 24 hours from now
-	expectedExpiry := time.Now().Add(24 * time.Hour)
+	// Check that expiration is approximately AccessTokenTTL from now
+	expectedExpiry := time.Now().Add(AccessTokenTTL)
 	timeDiff := claims.ExpiresAt.Time.Sub(expectedExpiry)
 	if timeDiff < -time.Minute || timeDiff > time.Minute {
-		t.Error("Expected token expiry to be approximately 24 hours from now")
+		t.Errorf("Expected token expiry to be approximately %s from now", AccessTokenTTL)
 	}
 }
 
 func TestGenerateRandomState(t *testing.T) {
-	state1 := generateRandomState()
-	state2 := generateRandomState()
+	state1, err := generateRandomState()
+	if err != nil {
+		t.Fatalf("Failed to generate state: %v", err)
+	}
+	state2, err := generateRandomState()
+	if err != nil {
+		t.Fatalf("Failed to generate state: %v", err)
+	}
 
 	if state1 == "" {
 		t.Error("Expected state to be non-empty")
 	}
 
-	// States should be different (with very high probability)
-	// Sleep a tiny bit to ensure different timestamps
-	time.Sleep(time.Millisecond)
+	// 32 random bytes, base64url encoded without padding
+	if len(state1) != 43 {
+		t.Errorf("Expected 43 character state, got %d characters", len(state1))
+	}
+
 	if state1 == state2 {
 		t.Error("Expected different states to be generated")
 	}
 }
+
+func TestSignValue(t *testing.T) {
+	keys := newTestKeySet(t)
+
+	signed := keys.SignValue("some-state")
+
+	value, ok := keys.VerifyValue(signed)
+	if !ok || value != "some-state" {
+		t.Errorf("Expected signed value to verify, got %q, %v", value, ok)
+	}
+
+	if _, ok := keys.VerifyValue("other-state" + signed[len("some-state"):]); ok {
+		t.Error("Expected tampered value to be rejected")
+	}
+
+	if _, ok := keys.VerifyValue("some-state"); ok {
+		t.Error("Expected unsigned value to be rejected")
+	}
+
+	if _, ok := newTestKeySet(t).VerifyValue(signed); ok {
+		t.Error("Expected value signed with another key to be rejected")
+	}
+}
+
+func TestSignClaims(t *testing.T) {
+	keys := newTestKeySet(t)
+
+	signed, err := keys.SignClaims(map[string]interface{}{"last_entry_id": 42, "last_hash": "abc"})
+	if err != nil {
+		t.Fatalf("Failed to sign claims: %v", err)
+	}
+
+	claims, err := keys.VerifyClaims(signed)
+	if err != nil {
+		t.Fatalf("Expected signed claims to verify: %v", err)
+	}
+	if claims["last_entry_id"] != float64(42) || claims["last_hash"] != "abc" {
+		t.Errorf("Unexpected claims %v", claims)
+	}
+
+	if _, err := newTestKeySet(t).VerifyClaims(signed); err == nil {
+		t.Error("Expected claims signed with another key to be rejected")
+	}
+
+	// After a rotation the retired key still verifies what it signed
+	rotated, err := GenerateKeySet("surfer", keys.signingKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.VerifyClaims(signed); err != nil {
+		t.Errorf("Expected claims signed with a retired key to verify: %v", err)
+	}
+
+	// Signed claims are not login tokens, even with an expiry
+	if _, err := keys.ValidateToken(signed); err == nil {
+		t.Error("Expected signed claims without expiry to be rejected as a token")
+	}
+	withExpiry, err := keys.SignClaims(map[string]interface{}{"sub": "1", "exp": time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.ValidateToken(withExpiry); err == nil {
+		t.Error("Expected signed claims with expiry to be rejected as a token")
+	}
+
+	// and login tokens are not signed claims
+	token, err := keys.GenerateToken(1, "user@example.com", "viewer", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.VerifyClaims(token); err == nil {
+		t.Error("Expected a login token to be rejected as signed claims")
+	}
+}
+
+func TestNewTokenID(t *testing.T) {
+	id1, err := newTokenID()
+	if err != nil {
+		t.Fatalf("Failed to generate token ID: %v", err)
+	}
+
+	id2, err := newTokenID()
+	if err != nil {
+		t.Fatalf("Failed to generate token ID: %v", err)
+	}
+
+	if len(id1) != 32 {
+		t.Errorf("Expected 32 character token ID, got %d characters", len(id1))
+	}
+
+	if id1 == id2 {
+		t.Error("Expected different token IDs to be generated")
+	}
+}
+
+func TestGenerateTokenSetsTokenID(t *testing.T) {
+	keys := newTestKeySet(t)
+
+	token, err := keys.GenerateToken(1, "test@example.com", "user", "session-jti")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	claims, err := keys.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+
+	if claims.ID != "session-jti" {
+		t.Errorf("Expected jti to be session-jti, got %s", claims.ID)
+	}
+}
+
+func TestNewRefreshToken(t *testing.T) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		t.Fatalf("Failed to generate refresh token: %v", err)
+	}
+
+	if token == "" {
+		t.Error("Expected refresh token to be non-empty")
+	}
+
+	if hash != hashToken(token) {
+		t.Error("Expected returned hash to match the hash of the token")
+	}
+
+	if hash == token {
+		t.Error("Expected hash to differ from the raw token")
+	}
+
+	other, _, err := newRefreshToken()
+	if err != nil {
+		t.Fatalf("Failed to generate refresh token: %v", err)
+	}
+
+	if token == other {
+		t.Error("Expected different refresh tokens to be generated")
+	}
+}
+
+func TestTokenHasKeyID(t *testing.T) {
+	keys := newTestKeySet(t)
+
+	signed, err := keys.GenerateToken(1, "test@example.com", "user", "test-token-id")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	token, _, err := jwt.NewParser().ParseUnverified(signed, &Claims{})
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+
+	if token.Header["kid"] != keys.signing.kid {
+		t.Errorf("Expected kid header %s, got %v", keys.signing.kid, token.Header["kid"])
+	}
+
+	if token.Method.Alg() != "ES256" {
+		t.Errorf("Expected ES256, got %s", token.Method.Alg())
+	}
+}
+
+func TestValidateTokenFromOtherKeySet(t *testing.T) {
+	keys := newTestKeySet(t)
+	other := newTestKeySet(t)
+
+	token, err := other.GenerateToken(1, "test@example.com", "user", "test-token-id")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	if _, err := keys.ValidateToken(token); err == nil {
+		t.Error("Expected token signed by an unknown key to be rejected")
+	}
+}
+
+func TestValidateTokenRejectsHMAC(t *testing.T) {
+	keys := newTestKeySet(t)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+		UserID: 1,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "surfer",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	})
+	token.Header["kid"] = keys.signing.kid
+	signed, err := token.SignedString([]byte("your-secret-key-change-this"))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	if _, err := keys.ValidateToken(signed); err == nil {
+		t.Error("Expected HS256 token to be rejected")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+
+	oldKeys, err := NewKeySet("surfer", oldKey)
+	if err != nil {
+		t.Fatalf("Failed to create key set: %v", err)
+	}
+	rotated, err := NewKeySet("surfer", newKey, oldKey.Public())
+	if err != nil {
+		t.Fatalf("Failed to create key set: %v", err)
+	}
+
+	token, err := oldKeys.GenerateToken(1, "test@example.com", "user", "test-token-id")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	if _, err := rotated.ValidateToken(token); err != nil {
+		t.Errorf("Expected token signed by the retired key to be accepted: %v", err)
+	}
+
+	jwks := rotated.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("Expected 2 keys in JWKS, got %d", len(jwks.Keys))
+	}
+
+	if jwks.Keys[0].Kid != rotated.signing.kid || jwks.Keys[0].Kty != "EC" || jwks.Keys[0].Crv != "P-256" {
+		t.Errorf("Expected current EC signing key first, got %+v", jwks.Keys[0])
+	}
+
+	if jwks.Keys[1].Kty != "RSA" || jwks.Keys[1].Alg != "RS256" || jwks.Keys[1].E != "AQAB" {
+		t.Errorf("Expected retired RSA key, got %+v", jwks.Keys[1])
+	}
+}
+
+func TestLoadKeySetRequiresKey(t *testing.T) {
+	os.Unsetenv("JWT_SIGNING_KEY_FILE")
+	os.Unsetenv("JWT_SIGNING_KEY")
+	os.Unsetenv("JWT_GENERATE_SIGNING_KEY")
+
+	if _, err := LoadKeySet(); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("Expected ErrNoSigningKey, got %v", err)
+	}
+}
+
+func TestLoadKeySetFromFile(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	path := t.TempDir() + "/signing.pem"
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	t.Setenv("JWT_SIGNING_KEY_FILE", path)
+	t.Setenv("JWT_ISSUER", "surfer-test")
+
+	keys, err := LoadKeySet()
+	if err != nil {
+		t.Fatalf("Failed to load key set: %v", err)
+	}
+
+	expected, _ := NewKeySet("surfer-test", key)
+	if keys.signing.kid != expected.signing.kid {
+		t.Errorf("Expected kid %s, got %s", expected.signing.kid, keys.signing.kid)
+	}
+
+	token, err := keys.GenerateToken(1, "test@example.com", "user", "test-token-id")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	claims, err := keys.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+
+	if claims.Issuer != "surfer-test" {
+		t.Errorf("Expected issuer surfer-test, got %s", claims.Issuer)
+	}
+}
+
+func TestNewAccessToken(t *testing.T) {
+	token, hash, err := NewAccessToken()
+	if err != nil {
+		t.Fatalf("Failed to generate access token: %v", err)
+	}
+
+	if !IsAccessToken(token) {
+		t.Errorf("Expected token to start with %s, got %s", AccessTokenPrefix, token)
+	}
+
+	if hash != hashToken(token) || hash == token {
+		t.Error("Expected the SHA-256 of the token to be returned for storage")
+	}
+
+	if IsAccessToken("eyJhbGciOiJFUzI1NiJ9.e30.sig") {
+		t.Error("Expected a JWT not to be taken for an access token")
+	}
+}
+
+func TestNormalizeScopes(t *testing.T) {
+	scopes, err := NormalizeScopes([]string{"write", " Read", "write"})
+	if err != nil {
+		t.Fatalf("Failed to normalize scopes: %v", err)
+	}
+	if scopes != "read,write" {
+		t.Errorf("Expected read,write, got %s", scopes)
+	}
+
+	if _, err := NormalizeScopes([]string{"read", "root"}); err == nil {
+		t.Error("Expected an error for an unknown scope")
+	}
+
+	if _, err := NormalizeScopes(nil); err == nil {
+		t.Error("Expected an error without scopes")
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	if !HasScope("write", ScopeRead) {
+		t.Error("Expected write to imply read")
+	}
+	if HasScope("read", ScopeWrite) {
+		t.Error("Expected read not to imply write")
+	}
+	if HasScope("read,write", ScopeAdmin) {
+		t.Error("Expected admin to require the admin scope")
+	}
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/audit"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// ChangeRequestTTL is how long a change request waits for approval before it
+// expires
+const ChangeRequestTTL = time.Hour
+
+// ChangeNeedsApproval reports whether change must wait for a second user
+// before it runs on cluster. Only destructive operations on protected clusters
+// do; scaling a deployment is destructive when it scales to zero.
+func ChangeNeedsApproval(cluster *models.Cluster, change *models.ChangeRequest) bool {
+	if !cluster.Protected {
+		return false
+	}
+	if change.Action == models.ChangeScaleDeployment {
+		return change.Replicas != nil && *change.Replicas == 0
+	}
+	return true
+}
+
+// ChangePermission returns the role permission needed to request or approve
+// an action
+func ChangePermission(action string) string {
+	switch action {
+	case models.ChangeDeletePod:
+		return models.PermPodsDelete
+	case models.ChangeScaleDeployment:
+		return models.PermDeploymentsScale
+	case models.ChangeDeleteResource:
+		return models.PermResourcesDelete
+	case models.ChangeApply:
+		return models.PermResourcesApply
+	}
+	return ""
+}
+
+// ExpireChangeRequests marks pending change requests past their expiry as
+// expired and writes a system audit log entry for each
+func ExpireChangeRequests(db *gorm.DB, now time.Time) (int, error) {
+	var candidates []models.ChangeRequest
+	if err := db.Where("status = ? AND expires_at <= ?", models.ChangeRequestPending, now).
+		Find(&candidates).Error; err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for i := range candidates {
+		change := &candidates[i]
+
+		changed := false
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// Skip requests that were reviewed or cancelled in the meantime
+			result := tx.Model(&models.ChangeRequest{}).
+				Where("id = ? AND status = ?", change.ID, models.ChangeRequestPending).
+				Updates(map[string]interface{}{"status": models.ChangeRequestExpired, "ended_at": now})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+
+			changed = true
+			return audit.Append(tx, &models.AuditLog{
+				UserID:     change.UserID,
+				ActorType:  models.ActorTypeSystem,
+				Action:     "expire",
+				Resource:   "change_request",
+				ResourceID: fmt.Sprint(change.ID),
+				Details:    fmt.Sprintf("cluster=%d namespace=%s action=%s target=%s", change.ClusterID, change.Namespace, change.Action, change.Target),
+			})
+		})
+		if err != nil {
+			return expired, err
+		}
+		if changed {
+			expired++
+		}
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+func TestChangeNeedsApproval(t *testing.T) {
+	zero, three := int32(0), int32(3)
+	protected := &models.Cluster{Protected: true}
+	unprotected := &models.Cluster{}
+
+	testCases := []struct {
+		name    string
+		cluster *models.Cluster
+		change  models.ChangeRequest
+		want    bool
+	}{
+		{"delete pod on protected cluster", protected, models.ChangeRequest{Action: models.ChangeDeletePod}, true},
+		{"delete pod on unprotected cluster", unprotected, models.ChangeRequest{Action: models.ChangeDeletePod}, false},
+		{"scale to zero", protected, models.ChangeRequest{Action: models.ChangeScaleDeployment, Replicas: &zero}, true},
+		{"scale up", protected, models.ChangeRequest{Action: models.ChangeScaleDeployment, Replicas: &three}, false},
+		{"scale to zero on unprotected cluster", unprotected, models.ChangeRequest{Action: models.ChangeScaleDeployment, Replicas: &zero}, false},
+		{"delete resource", protected, models.ChangeRequest{Action: models.ChangeDeleteResource}, true},
+		{"apply", protected, models.ChangeRequest{Action: models.ChangeApply}, true},
+		{"apply on unprotected cluster", unprotected, models.ChangeRequest{Action: models.ChangeApply}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ChangeNeedsApproval(tc.cluster, &tc.change); got != tc.want {
+				t.Errorf("Expected %t, got %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestChangePermission(t *testing.T) {
+	if got := ChangePermission(models.ChangeDeletePod); got != models.PermPodsDelete {
+		t.Errorf("Expected %s, got %s", models.PermPodsDelete, got)
+	}
+	if got := ChangePermission(models.ChangeScaleDeployment); got != models.PermDeploymentsScale {
+		t.Errorf("Expected %s, got %s", models.PermDeploymentsScale, got)
+	}
+	if got := ChangePermission(models.ChangeDeleteResource); got != models.PermResourcesDelete {
+		t.Errorf("Expected %s, got %s", models.PermResourcesDelete, got)
+	}
+	if got := ChangePermission(models.ChangeApply); got != models.PermResourcesApply {
+		t.Errorf("Expected %s, got %s", models.PermResourcesApply, got)
+	}
+	if got := ChangePermission("drop_database"); got != "" {
+		t.Errorf("Expected no permission for an unknown action, got %s", got)
+	}
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+)
+
+// KubernetesIdentity returns the user and group names a cluster with
+// impersonation sees for a Surfer user: the email as user name and the
+// team names as groups, each with prefix prepended
+func KubernetesIdentity(prefix, email string, teams []string) (string, []string) {
+	groups := make([]string, 0, len(teams))
+	for _, team := range teams {
+		groups = append(groups, prefix+team)
+	}
+	return prefix + email, groups
+}
+
+// ValidateImpersonationPrefix rejects prefixes that could make an impersonated
+// name collide with the cluster's built-in users and groups
+func ValidateImpersonationPrefix(prefix string) error {
+	if strings.ContainsAny(prefix, " \t\r\n") {
+		return errors.New("impersonation_prefix must not contain whitespace")
+	}
+	if strings.HasPrefix(prefix, "system:") {
+		return errors.New("impersonation_prefix must not start with system:")
+	}
+	return nil
+}
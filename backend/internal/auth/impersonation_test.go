@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKubernetesIdentity(t *testing.T) {
+	user, groups := KubernetesIdentity("surfer:", "jane@ourco.com", []string{"platform", "sre"})
+	if user != "surfer:jane@ourco.com" {
+		t.Errorf("Expected prefixed user name, got %s", user)
+	}
+	if want := []string{"surfer:platform", "surfer:sre"}; !reflect.DeepEqual(groups, want) {
+		t.Errorf("Expected groups %v, got %v", want, groups)
+	}
+
+	if _, groups := KubernetesIdentity("", "jane@ourco.com", nil); len(groups) != 0 {
+		t.Errorf("Expected no groups, got %v", groups)
+	}
+}
+
+func TestValidateImpersonationPrefix(t *testing.T) {
+	for prefix, valid := range map[string]bool{
+		"":            true,
+		"surfer:":     true,
+		"oidc:":       true,
+		"system:":     false,
+		"system:foo:": false,
+		"my prefix":   false,
+	} {
+		if err := ValidateImpersonationPrefix(prefix); (err == nil) != valid {
+			t.Errorf("Prefix %q: expected valid=%t, got %v", prefix, valid, err)
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrNoSigningKey is returned when no signing key is configured and key
+// generation has not been enabled
+var ErrNoSigningKey = errors.New("no JWT signing key configured: set JWT_SIGNING_KEY_FILE or JWT_SIGNING_KEY, or JWT_GENERATE_SIGNING_KEY=true for single-instance development setups")
+
+// SignedClaimsType is the typ header of values from SignClaims. They share the
+// signing key and issuer with login tokens, so the header keeps one from being
+// accepted as the other.
+const SignedClaimsType = "surfer-checkpoint+jwt"
+
+// verificationKey is a public key accepted for token verification
+type verificationKey struct {
+	kid    string
+	method jwt.SigningMethod
+	public crypto.PublicKey
+}
+
+// KeySet signs tokens with one private key and verifies them against the
+// signing key plus any number of retired keys, so that keys can be rotated
+// without invalidating tokens that are still in flight
+type KeySet struct {
+	issuer     string
+	signingKey crypto.Signer
+	signing    *verificationKey
+	keys       map[string]*verificationKey
+	// macKey signs short-lived values such as the OAuth state cookie. It is
+	// derived from the signing key so all replicas share it without extra
+	// config. Retired keys are public only, so values signed before a rotation
+	// no longer verify; logins in progress then have to start over.
+	macKey []byte
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewKeySet builds a key set that signs with signingKey and additionally
+// accepts tokens signed by the private keys matching verificationKeys
+func NewKeySet(issuer string, signingKey crypto.Signer, verificationKeys ...crypto.PublicKey) (*KeySet, error) {
+	signing, err := newVerificationKey(signingKey.Public())
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signingKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	mac := hmac.New(sha256.New, der)
+	mac.Write([]byte("surfer value signing"))
+
+	ks := &KeySet{
+		issuer:     issuer,
+		signingKey: signingKey,
+		signing:    signing,
+		keys:       map[string]*verificationKey{signing.kid: signing},
+		macKey:     mac.Sum(nil),
+	}
+
+	for _, public := range verificationKeys {
+		key, err := newVerificationKey(public)
+		if err != nil {
+			return nil, fmt.Errorf("invalid verification key: %w", err)
+		}
+		ks.keys[key.kid] = key
+	}
+
+	return ks, nil
+}
+
+// GenerateKeySet returns a key set with a freshly generated ES256 key. Tokens
+// signed with it cannot be verified by other replicas or after a restart.
+func GenerateKeySet(issuer string, verificationKeys ...crypto.PublicKey) (*KeySet, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	return NewKeySet(issuer, key, verificationKeys...)
+}
+
+// LoadKeySet builds the key set from the environment:
+//
+//	JWT_SIGNING_KEY_FILE / JWT_SIGNING_KEY             PEM private key (RSA or EC) used to sign tokens
+//	JWT_VERIFICATION_KEY_FILES / JWT_VERIFICATION_KEYS comma-separated files / PEM bundle of retired public keys
+//	JWT_GENERATE_SIGNING_KEY                           "true" to generate an ephemeral key when none is configured
+//	JWT_ISSUER                                         iss claim of issued tokens, defaults to "surfer"
+func LoadKeySet() (*KeySet, error) {
+	issuer := os.Getenv("JWT_ISSUER")
+	if issuer == "" {
+		issuer = "surfer"
+	}
+
+	var verificationKeys []crypto.PublicKey
+	if files := os.Getenv("JWT_VERIFICATION_KEY_FILES"); files != "" {
+		for _, file := range strings.Split(files, ",") {
+			data, err := os.ReadFile(strings.TrimSpace(file))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read verification key: %w", err)
+			}
+			keys, err := parsePublicKeys(data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse verification key %s: %w", file, err)
+			}
+			verificationKeys = append(verificationKeys, keys...)
+		}
+	}
+	if bundle := os.Getenv("JWT_VERIFICATION_KEYS"); bundle != "" {
+		keys, err := parsePublicKeys([]byte(bundle))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWT_VERIFICATION_KEYS: %w", err)
+		}
+		verificationKeys = append(verificationKeys, keys...)
+	}
+
+	var signingPEM []byte
+	if file := os.Getenv("JWT_SIGNING_KEY_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signing key: %w", err)
+		}
+		signingPEM = data
+	} else if key := os.Getenv("JWT_SIGNING_KEY"); key != "" {
+		signingPEM = []byte(key)
+	}
+
+	if signingPEM == nil {
+		if os.Getenv("JWT_GENERATE_SIGNING_KEY") != "true" {
+			return nil, ErrNoSigningKey
+		}
+		log.Println("WARNING: using a generated JWT signing key; tokens will not survive a restart or be accepted by other replicas")
+		return GenerateKeySet(issuer, verificationKeys...)
+	}
+
+	signingKey, err := parsePrivateKey(signingPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+
+	return NewKeySet(issuer, signingKey, verificationKeys...)
+}
+
+// GenerateToken signs a token for the user. tokenID becomes the jti claim and
+// must match a session recorded in the SessionStore for the token to be accepted.
+func (k *KeySet) GenerateToken(userID uint, email, role, tokenID string) (string, error) {
+	now := time.Now()
+	claims := &Claims{
+		UserID: userID,
+		Email:  email,
+		Role:   role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Issuer:    k.issuer,
+			Subject:   fmt.Sprintf("%d", userID),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	token := jwt.NewWithClaims(k.signing.method, claims)
+	token.Header["kid"] = k.signing.kid
+
+	return token.SignedString(k.signingKey)
+}
+
+// ValidateToken verifies the signature against the key named by the kid
+// header and checks the standard claims
+func (k *KeySet) ValidateToken(tokenString string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ == SignedClaimsType {
+			return nil, errors.New("signed claims are not a login token")
+		}
+		return k.keyFor(token)
+	}, jwt.WithIssuer(k.issuer), jwt.WithExpirationRequired())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		return claims, nil
+	}
+
+	return nil, fmt.Errorf("invalid token")
+}
+
+// keyFor returns the public key named by the kid header of token
+func (k *KeySet) keyFor(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	// The algorithm is pinned by the key, never taken from the token
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// SignClaims signs claims that are not a login, such as an audit checkpoint,
+// with the current signing key. The result is a JWS with the SignedClaimsType
+// header and no expiry that anyone with the JWKS can verify. VerifyClaims picks the key by kid, so after
+// a rotation the retired key must stay a verification key for as long as
+// values it signed are verified.
+func (k *KeySet) SignClaims(claims map[string]interface{}) (string, error) {
+	mapClaims := jwt.MapClaims{"iss": k.issuer, "iat": time.Now().Unix()}
+	for name, value := range claims {
+		mapClaims[name] = value
+	}
+
+	token := jwt.NewWithClaims(k.signing.method, mapClaims)
+	token.Header["kid"] = k.signing.kid
+	token.Header["typ"] = SignedClaimsType
+
+	return token.SignedString(k.signingKey)
+}
+
+// VerifyClaims verifies a JWS from SignClaims against every key in the set and
+// returns its claims
+func (k *KeySet) VerifyClaims(signed string) (map[string]interface{}, error) {
+	token, err := jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != SignedClaimsType {
+			return nil, fmt.Errorf("unexpected token type %q", typ)
+		}
+		return k.keyFor(token)
+	}, jwt.WithIssuer(k.issuer))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	return claims, nil
+}
+
+// SignValue returns value with an HMAC appended, for cookies that must not be forged
+func (k *KeySet) SignValue(value string) string {
+	mac := hmac.New(sha256.New, k.macKey)
+	mac.Write([]byte(value))
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyValue checks a value produced by SignValue and returns the original value
+func (k *KeySet) VerifyValue(signed string) (string, bool) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", false
+	}
+
+	value := signed[:i]
+	expected := k.SignValue(value)
+	if !hmac.Equal([]byte(expected), []byte(signed)) {
+		return "", false
+	}
+
+	return value, true
+}
+
+// JWKS returns the public half of every key in the set
+func (k *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0, len(k.keys))}
+
+	// Current signing key first, then the retired keys
+	set.Keys = append(set.Keys, k.signing.jwk())
+	for kid, key := range k.keys {
+		if kid != k.signing.kid {
+			set.Keys = append(set.Keys, key.jwk())
+		}
+	}
+
+	return set
+}
+
+// HandleJWKS serves the key set so other services can verify Surfer tokens
+func (k *KeySet) HandleJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, k.JWKS())
+}
+
+// PublicKey decodes an RSA or EC JWK into a public key
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := func(value string) (*big.Int, error) {
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(data), nil
+	}
+
+	switch j.Kty {
+	case "RSA":
+		n, err := decode(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := decode(j.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC point: %w", err)
+		}
+		y, err := decode(j.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC point: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+}
+
+func newVerificationKey(public crypto.PublicKey) (*verificationKey, error) {
+	var method jwt.SigningMethod
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		default:
+			return nil, errors.New("unsupported elliptic curve, use P-256 or P-384")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", public)
+	}
+
+	key := &verificationKey{method: method, public: public}
+	key.kid = thumbprint(key.jwk())
+	return key, nil
+}
+
+func (k *verificationKey) jwk() JWK {
+	jwk := JWK{Kid: k.kid, Use: "sig", Alg: k.method.Alg()}
+
+	switch key := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = key.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size)))
+	}
+
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint used as kid
+func thumbprint(jwk JWK) string {
+	// Members in lexicographic order, as required by the RFC
+	var members interface{}
+	if jwk.Kty == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	}
+
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
+
+// parsePublicKeys reads every PEM block of a bundle. Private keys are accepted
+// too so that a retired signing key file can be reused as is.
+func parsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, cert.PublicKey)
+		default:
+			signer, err := parsePrivateKey(pem.EncodeToMemory(block))
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, signer.Public())
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no PEM block found")
+	}
+
+	return keys, nil
+}
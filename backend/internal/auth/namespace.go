@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ClusterAccess is what a user may do on one cluster: a cluster-wide level,
+// and namespace-scoped permissions that may grant more in some namespaces
+type ClusterAccess struct {
+	// Level applies to every namespace, empty when there is no cluster-wide permission
+	Level string
+	// StandingLevel is Level without approved access requests. Changes that
+	// outlive a request, such as granting access, check it instead of Level.
+	StandingLevel string
+	// Namespaces are the permissions limited by a namespace glob or label selector
+	Namespaces []models.ClusterPermission
+	// StandingNamespaces is Namespaces without approved access requests
+	StandingNamespaces []models.ClusterPermission
+}
+
+// Standing returns the access without approved access requests, for checks
+// such as reviews that a request must not be enough for
+func (a *ClusterAccess) Standing() *ClusterAccess {
+	return &ClusterAccess{
+		Level:              a.StandingLevel,
+		StandingLevel:      a.StandingLevel,
+		Namespaces:         a.StandingNamespaces,
+		StandingNamespaces: a.StandingNamespaces,
+	}
+}
+
+// Allows reports whether level is granted cluster-wide or by any namespace
+// permission. Callers must still check the namespace with NamespaceLevel.
+func (a *ClusterAccess) Allows(level string) bool {
+	if models.ClusterLevelAllows(a.Level, level) {
+		return true
+	}
+	for _, permission := range a.Namespaces {
+		if models.ClusterLevelAllows(permission.Level, level) {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsLabels reports whether the labels of a namespace could change the
+// outcome of NamespaceLevel for level, so callers only fetch them when needed
+func (a *ClusterAccess) NeedsLabels(namespace, level string) bool {
+	if models.ClusterLevelAllows(a.NamespaceLevel(namespace, nil), level) {
+		return false
+	}
+	for _, permission := range a.Namespaces {
+		if permission.NamespaceSelector != "" && models.ClusterLevelAllows(permission.Level, level) {
+			return true
+		}
+	}
+	return false
+}
+
+// NamespaceLevel returns the highest level granted on a namespace with the
+// given labels. Selector permissions never match when labels is nil.
+func (a *ClusterAccess) NamespaceLevel(namespace string, namespaceLabels map[string]string) string {
+	level := a.Level
+	for _, permission := range a.Namespaces {
+		if namespaceMatches(&permission, namespace, namespaceLabels) {
+			level = models.HigherClusterLevel(level, permission.Level)
+		}
+	}
+	return level
+}
+
+func namespaceMatches(permission *models.ClusterPermission, namespace string, namespaceLabels map[string]string) bool {
+	if permission.Namespace != "" {
+		matched, err := path.Match(permission.Namespace, namespace)
+		if err != nil || !matched {
+			return false
+		}
+	}
+
+	if permission.NamespaceSelector != "" {
+		if namespaceLabels == nil {
+			return false
+		}
+		selector, err := labels.Parse(permission.NamespaceSelector)
+		if err != nil || !selector.Matches(labels.Set(namespaceLabels)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ValidateNamespaceScope checks a namespace glob and label selector before
+// they are stored
+func ValidateNamespaceScope(namespace, selector string) error {
+	if namespace != "" {
+		if _, err := path.Match(namespace, ""); err != nil {
+			return fmt.Errorf("invalid namespace pattern %q: %w", namespace, err)
+		}
+	}
+
+	if selector != "" {
+		if _, err := labels.Parse(selector); err != nil {
+			return fmt.Errorf("invalid namespace selector %q: %w", selector, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+func TestNamespaceLevel(t *testing.T) {
+	access := &ClusterAccess{
+		Namespaces: []models.ClusterPermission{
+			{Namespace: "team-a-*", Level: models.ClusterLevelOperator},
+			{NamespaceSelector: "team=b", Level: models.ClusterLevelViewer},
+			{Namespace: "shared", NamespaceSelector: "env in (dev,staging)", Level: models.ClusterLevelOperator},
+		},
+	}
+
+	testCases := []struct {
+		namespace string
+		labels    map[string]string
+		want      string
+	}{
+		{"team-a-web", nil, models.ClusterLevelOperator},
+		{"team-ab", nil, ""},
+		{"team-b-api", map[string]string{"team": "b"}, models.ClusterLevelViewer},
+		{"team-b-api", nil, ""},
+		{"shared", map[string]string{"env": "dev"}, models.ClusterLevelOperator},
+		{"shared", map[string]string{"env": "prod"}, ""},
+		{"kube-system", map[string]string{}, ""},
+	}
+
+	for _, tc := range testCases {
+		if got := access.NamespaceLevel(tc.namespace, tc.labels); got != tc.want {
+			t.Errorf("Expected %q on %s with labels %v, got %q", tc.want, tc.namespace, tc.labels, got)
+		}
+	}
+
+	access.Level = models.ClusterLevelViewer
+	if got := access.NamespaceLevel("kube-system", nil); got != models.ClusterLevelViewer {
+		t.Errorf("Expected the cluster-wide level to apply to every namespace, got %q", got)
+	}
+}
+
+func TestNeedsLabels(t *testing.T) {
+	access := &ClusterAccess{
+		Namespaces: []models.ClusterPermission{
+			{Namespace: "team-a-*", Level: models.ClusterLevelOperator},
+			{NamespaceSelector: "team=b", Level: models.ClusterLevelViewer},
+		},
+	}
+
+	if access.NeedsLabels("team-a-web", models.ClusterLevelOperator) {
+		t.Error("Expected no label lookup when a glob already grants the level")
+	}
+
+	if !access.NeedsLabels("team-b-api", models.ClusterLevelViewer) {
+		t.Error("Expected a label lookup when a selector could grant the level")
+	}
+
+	if access.NeedsLabels("team-b-api", models.ClusterLevelOperator) {
+		t.Error("Expected no label lookup when no selector grants the level")
+	}
+}
+
+func TestStandingAccess(t *testing.T) {
+	access := ResolveClusterAccess(
+		[]models.ClusterPermission{
+			{ClusterID: 1, UserID: 7, Level: models.ClusterLevelViewer},
+			{ClusterID: 1, UserID: 7, Namespace: "team-a-*", Level: models.ClusterLevelOperator},
+		},
+		[]models.AccessRequest{
+			{ClusterID: 1, UserID: 7, Namespace: "prod", Level: models.ClusterLevelOperator},
+		},
+		7, nil, nil)[1]
+
+	if got := access.NamespaceLevel("prod", nil); got != models.ClusterLevelOperator {
+		t.Errorf("Expected the access request to grant operator on prod, got %q", got)
+	}
+
+	standing := access.Standing()
+	if got := standing.NamespaceLevel("prod", nil); got != models.ClusterLevelViewer {
+		t.Errorf("Expected standing access to ignore the access request, got %q", got)
+	}
+	if got := standing.NamespaceLevel("team-a-web", nil); got != models.ClusterLevelOperator {
+		t.Errorf("Expected standing access to keep namespace permissions, got %q", got)
+	}
+}
+
+func TestValidateNamespaceScope(t *testing.T) {
+	if err := ValidateNamespaceScope("team-[a-c]-*", "team=a,env!=prod"); err != nil {
+		t.Errorf("Expected valid scope, got %v", err)
+	}
+
+	if err := ValidateNamespaceScope("team-[", ""); err == nil {
+		t.Error("Expected an error for a malformed glob")
+	}
+
+	if err := ValidateNamespaceScope("", "team in (a"); err == nil {
+		t.Error("Expected an error for a malformed selector")
+	}
+}
@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// stubOIDCServer is a minimal OpenID Connect provider: discovery, JWKS and a
+// token endpoint that returns an ID token built from claims
+type stubOIDCServer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+	claims jwt.MapClaims
+	// codeChallenge is the PKCE challenge the token endpoint checks the
+	// code_verifier against
+	codeChallenge string
+}
+
+func newStubOIDCServer(t *testing.T) *stubOIDCServer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+
+	stub := &stubOIDCServer{key: key}
+	verification, err := newVerificationKey(key.Public())
+	if err != nil {
+		t.Fatalf("Failed to build JWK: %v", err)
+	}
+	stub.kid = verification.kid
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 stub.URL,
+			"authorization_endpoint": stub.URL + "/authorize",
+			"token_endpoint":         stub.URL + "/token",
+			"jwks_uri":               stub.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JWKSet{Keys: []JWK{verification.jwk()}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if oauth2.S256ChallengeFromVerifier(r.FormValue("code_verifier")) != stub.codeChallenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, stub.claims)
+		token.Header["kid"] = stub.kid
+		idToken, err := token.SignedString(stub.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "stub-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	stub.codeChallenge = oauth2.S256ChallengeFromVerifier("test-verifier")
+	stub.Server = httptest.NewServer(mux)
+	t.Cleanup(stub.Close)
+
+	return stub
+}
+
+// validClaims returns ID token claims the provider under test should accept
+func (s *stubOIDCServer) validClaims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            s.URL,
+		"sub":            "user-123",
+		"aud":            "surfer",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"name":           "Jane Doe",
+		"groups":         []string{"sre", "developers"},
+	}
+}
+
+func newTestOIDCProvider(t *testing.T, stub *stubOIDCServer, cfg OIDCConfig) *OIDCProvider {
+	t.Helper()
+
+	cfg.Name = "keycloak"
+	cfg.IssuerURL = stub.URL
+	cfg.ClientID = "surfer"
+	cfg.ClientSecret = "secret"
+	cfg.RedirectURL = "http://localhost:8080/api/v1/auth/keycloak/callback"
+
+	provider, err := NewOIDCProvider(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	return provider
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	stub := newStubOIDCServer(t)
+	provider := newTestOIDCProvider(t, stub, OIDCConfig{})
+
+	loginURL, err := provider.AuthCodeURL("test-state", "test-nonce", "test-verifier")
+	if err != nil {
+		t.Fatalf("Failed to build auth code URL: %v", err)
+	}
+
+	parsed, err := url.Parse(loginURL)
+	if err != nil {
+		t.Fatalf("Failed to parse auth code URL: %v", err)
+	}
+
+	if !strings.HasPrefix(loginURL, stub.URL+"/authorize") {
+		t.Errorf("Expected authorization endpoint from discovery, got %s", loginURL)
+	}
+
+	query := parsed.Query()
+	if query.Get("state") != "test-state" || query.Get("nonce") != "test-nonce" {
+		t.Errorf("Expected state and nonce in URL, got %s", parsed.RawQuery)
+	}
+
+	if query.Get("code_challenge") != oauth2.S256ChallengeFromVerifier("test-verifier") || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("Expected S256 PKCE challenge in URL, got %s", parsed.RawQuery)
+	}
+
+	if query.Get("scope") != "openid email profile" {
+		t.Errorf("Expected default scopes, got %s", query.Get("scope"))
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	stub := newStubOIDCServer(t)
+	provider := newTestOIDCProvider(t, stub, OIDCConfig{})
+	stub.claims = stub.validClaims("test-nonce")
+
+	identity, err := provider.Exchange(context.Background(), "test-code", "test-nonce", "test-verifier")
+	if err != nil {
+		t.Fatalf("Failed to exchange code: %v", err)
+	}
+
+	if identity.Provider != "keycloak" || identity.Subject != "user-123" {
+		t.Errorf("Expected keycloak/user-123, got %s/%s", identity.Provider, identity.Subject)
+	}
+
+	if identity.Email != "jane@example.com" || identity.Name != "Jane Doe" {
+		t.Errorf("Unexpected profile: %+v", identity)
+	}
+
+	if len(identity.Groups) != 2 || identity.Groups[0] != "sre" {
+		t.Errorf("Expected groups [sre developers], got %v", identity.Groups)
+	}
+}
+
+func TestOIDCExchangeCustomClaims(t *testing.T) {
+	stub := newStubOIDCServer(t)
+	provider := newTestOIDCProvider(t, stub, OIDCConfig{
+		EmailClaim:  "upn",
+		NameClaim:   "preferred_username",
+		GroupsClaim: "roles",
+	})
+
+	stub.claims = stub.validClaims("test-nonce")
+	stub.claims["upn"] = "jane@corp.example.com"
+	stub.claims["preferred_username"] = "jdoe"
+	stub.claims["roles"] = []string{"admins"}
+
+	identity, err := provider.Exchange(context.Background(), "test-code", "test-nonce", "test-verifier")
+	if err != nil {
+		t.Fatalf("Failed to exchange code: %v", err)
+	}
+
+	if identity.Email != "jane@corp.example.com" || identity.Name != "jdoe" {
+		t.Errorf("Expected profile from custom claims, got %+v", identity)
+	}
+
+	if len(identity.Groups) != 1 || identity.Groups[0] != "admins" {
+		t.Errorf("Expected groups [admins], got %v", identity.Groups)
+	}
+}
+
+func TestOIDCExchangeEmailVerified(t *testing.T) {
+	stub := newStubOIDCServer(t)
+	provider := newTestOIDCProvider(t, stub, OIDCConfig{})
+
+	stub.claims = stub.validClaims("test-nonce")
+	identity, err := provider.Exchange(context.Background(), "test-code", "test-nonce", "test-verifier")
+	if err != nil || !identity.EmailVerified {
+		t.Fatalf("Expected a verified email, got %+v, %v", identity, err)
+	}
+
+	stub.claims = stub.validClaims("test-nonce")
+	delete(stub.claims, "email_verified")
+	stub.claims["groups"] = "sre, developers,"
+	identity, err = provider.Exchange(context.Background(), "test-code", "test-nonce", "test-verifier")
+	if err != nil {
+		t.Fatalf("Failed to exchange code: %v", err)
+	}
+	if identity.EmailVerified {
+		t.Error("Expected an email without email_verified to be unverified")
+	}
+	if len(identity.Groups) != 2 || identity.Groups[0] != "sre" || identity.Groups[1] != "developers" {
+		t.Errorf("Expected groups [sre developers], got %q", identity.Groups)
+	}
+}
+
+func TestOIDCExchangeRejectsInvalidTokens(t *testing.T) {
+	testCases := []struct {
+		name   string
+		mutate func(claims jwt.MapClaims)
+	}{
+		{"nonce mismatch", func(claims jwt.MapClaims) { claims["nonce"] = "other-nonce" }},
+		{"missing nonce", func(claims jwt.MapClaims) { delete(claims, "nonce") }},
+		{"wrong audience", func(claims jwt.MapClaims) { claims["aud"] = "another-client" }},
+		{"wrong issuer", func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" }},
+		{"expired", func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"unverified email", func(claims jwt.MapClaims) { claims["email_verified"] = false }},
+		{"missing email", func(claims jwt.MapClaims) { delete(claims, "email") }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stub := newStubOIDCServer(t)
+			provider := newTestOIDCProvider(t, stub, OIDCConfig{})
+
+			stub.claims = stub.validClaims("test-nonce")
+			tc.mutate(stub.claims)
+
+			if _, err := provider.Exchange(context.Background(), "test-code", "test-nonce", "test-verifier"); err == nil {
+				t.Error("Expected exchange to fail")
+			}
+		})
+	}
+}
+
+func TestOIDCExchangeRequiresCodeVerifier(t *testing.T) {
+	stub := newStubOIDCServer(t)
+	provider := newTestOIDCProvider(t, stub, OIDCConfig{})
+	stub.claims = stub.validClaims("test-nonce")
+
+	if _, err := provider.Exchange(context.Background(), "test-code", "test-nonce", "wrong-verifier"); err == nil {
+		t.Error("Expected exchange to fail with a mismatched PKCE code verifier")
+	}
+}
+
+func TestOIDCExchangeRejectsForeignSignature(t *testing.T) {
+	stub := newStubOIDCServer(t)
+	provider := newTestOIDCProvider(t, stub, OIDCConfig{})
+	stub.claims = stub.validClaims("test-nonce")
+
+	// Sign with a key that is not in the JWKS but claim the published kid
+	foreign, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	stub.key = foreign
+
+	if _, err := provider.Exchange(context.Background(), "test-code", "test-nonce", "test-verifier"); err == nil {
+		t.Error("Expected exchange to fail for a token signed by an unknown key")
+	}
+}
+
+func TestLoadProviders(t *testing.T) {
+	t.Setenv("GOOGLE_CLIENT_ID", "google-client")
+	t.Setenv("OIDC_PROVIDERS", "keycloak, azure-ad")
+	t.Setenv("OIDC_KEYCLOAK_ISSUER", "https://keycloak.example.com/realms/surfer")
+	t.Setenv("OIDC_KEYCLOAK_CLIENT_ID", "surfer")
+	t.Setenv("OIDC_KEYCLOAK_REDIRECT_URL", "https://surfer.example.com/api/v1/auth/keycloak/callback")
+	t.Setenv("OIDC_AZURE_AD_ISSUER", "https://login.microsoftonline.com/tenant/v2.0")
+	t.Setenv("OIDC_AZURE_AD_CLIENT_ID", "surfer")
+	t.Setenv("OIDC_AZURE_AD_REDIRECT_URL", "https://surfer.example.com/api/v1/auth/azure-ad/callback")
+	t.Setenv("OIDC_AZURE_AD_DISPLAY_NAME", "Azure AD")
+
+	providers, err := LoadProviders()
+	if err != nil {
+		t.Fatalf("Failed to load providers: %v", err)
+	}
+
+	for _, name := range []string{"google", "keycloak", "azure-ad"} {
+		if _, ok := providers[name]; !ok {
+			t.Errorf("Expected provider %s to be enabled", name)
+		}
+	}
+
+	if providers["azure-ad"].DisplayName() != "Azure AD" {
+		t.Errorf("Expected display name Azure AD, got %s", providers["azure-ad"].DisplayName())
+	}
+}
+
+func TestLoadProvidersIncomplete(t *testing.T) {
+	t.Setenv("GOOGLE_CLIENT_ID", "")
+	t.Setenv("OIDC_PROVIDERS", "okta")
+
+	if _, err := LoadProviders(); err == nil {
+		t.Error("Expected an error for a provider without issuer and client ID")
+	}
+}
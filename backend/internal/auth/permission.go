@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrPermissionNotFound is returned when a user has no permission on a cluster
+var ErrPermissionNotFound = errors.New("cluster permission not found")
+
+// PermissionStore keeps the cluster permissions granted to users, groups and
+// teams
+type PermissionStore interface {
+	// ClusterAccess returns what userID may do on clusterID, directly or
+	// through one of their groups or teams
+	ClusterAccess(userID, clusterID uint) (*ClusterAccess, error)
+	// AccessibleClusters returns the IDs of the clusters userID has any
+	// permission on, cluster-wide or for some namespaces
+	AccessibleClusters(userID uint) ([]uint, error)
+	// List returns the direct permissions of a user
+	List(userID uint) ([]models.ClusterPermission, error)
+	// ListForCluster returns every user, group and team permission on a cluster
+	ListForCluster(clusterID uint) ([]models.ClusterPermission, error)
+	// ListForTeam returns the permissions of a team
+	ListForTeam(teamID uint) ([]models.ClusterPermission, error)
+	// Grant creates or replaces the permission of a user, group or team on a
+	// cluster
+	Grant(permission *models.ClusterPermission) error
+	// Revoke removes the direct cluster-wide permission of a user on a cluster
+	Revoke(userID, clusterID uint) error
+	// RevokeByID removes a user, group or team permission on a cluster
+	RevokeByID(clusterID, permissionID uint) error
+}
+
+// GormPermissionStore is a PermissionStore backed by the cluster_permissions table
+type GormPermissionStore struct {
+	db *gorm.DB
+}
+
+func NewGormPermissionStore(db *gorm.DB) *GormPermissionStore {
+	return &GormPermissionStore{db: db}
+}
+
+func (s *GormPermissionStore) ClusterAccess(userID, clusterID uint) (*ClusterAccess, error) {
+	access, err := s.resolve(userID, s.db.Where("cluster_id = ?", clusterID))
+	if err != nil {
+		return nil, err
+	}
+
+	if access[clusterID] == nil {
+		return &ClusterAccess{}, nil
+	}
+	return access[clusterID], nil
+}
+
+func (s *GormPermissionStore) AccessibleClusters(userID uint) ([]uint, error) {
+	access, err := s.resolve(userID, s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(access))
+	for id := range access {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// resolve loads the user's groups and teams, the candidate permissions from
+// query and the user's active access requests, and resolves them with
+// ResolveClusterAccess
+func (s *GormPermissionStore) resolve(userID uint, query *gorm.DB) (map[uint]*ClusterAccess, error) {
+	var identities []models.UserIdentity
+	if err := s.db.Where("user_id = ?", userID).Find(&identities).Error; err != nil {
+		return nil, err
+	}
+
+	var teams []uint
+	if err := s.db.Model(&models.TeamMember{}).Where("user_id = ?", userID).Pluck("team_id", &teams).Error; err != nil {
+		return nil, err
+	}
+
+	var permissions []models.ClusterPermission
+	if err := query.Where("(user_id = ? OR group_name <> '' OR team_id IN ?)", userID, teams).Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+
+	// Approved access requests count until they expire, whether or not the
+	// reaper has marked them expired yet
+	var requests []models.AccessRequest
+	if err := s.db.Where("user_id = ? AND status = ? AND expires_at > ?", userID, models.AccessRequestApproved, time.Now()).
+		Find(&requests).Error; err != nil {
+		return nil, err
+	}
+
+	return ResolveClusterAccess(permissions, requests, userID, identities, teams), nil
+}
+
+func (s *GormPermissionStore) List(userID uint) ([]models.ClusterPermission, error) {
+	var permissions []models.ClusterPermission
+	err := s.db.Preload("Cluster").Where("user_id = ?", userID).Find(&permissions).Error
+	return permissions, err
+}
+
+func (s *GormPermissionStore) ListForCluster(clusterID uint) ([]models.ClusterPermission, error) {
+	var permissions []models.ClusterPermission
+	err := s.db.Where("cluster_id = ?", clusterID).Order("team_id, group_name, user_id").Find(&permissions).Error
+	return permissions, err
+}
+
+func (s *GormPermissionStore) ListForTeam(teamID uint) ([]models.ClusterPermission, error) {
+	var permissions []models.ClusterPermission
+	err := s.db.Preload("Cluster").Where("team_id = ?", teamID).Order("cluster_id").Find(&permissions).Error
+	return permissions, err
+}
+
+func (s *GormPermissionStore) Grant(permission *models.ClusterPermission) error {
+	return s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{
+			{Name: "cluster_id"}, {Name: "user_id"}, {Name: "team_id"}, {Name: "group_name"},
+			{Name: "provider"}, {Name: "namespace"}, {Name: "namespace_selector"},
+		},
+		DoUpdates: clause.AssignmentColumns([]string{"level", "granted_by", "updated_at"}),
+	}).Create(permission).Error
+}
+
+func (s *GormPermissionStore) Revoke(userID, clusterID uint) error {
+	return s.revoke(s.db.Where("user_id = ? AND cluster_id = ? AND team_id = 0 AND group_name = '' AND namespace = '' AND namespace_selector = ''", userID, clusterID))
+}
+
+func (s *GormPermissionStore) RevokeByID(clusterID, permissionID uint) error {
+	return s.revoke(s.db.Where("id = ? AND cluster_id = ?", permissionID, clusterID))
+}
+
+func (s *GormPermissionStore) revoke(query *gorm.DB) error {
+	result := query.Delete(&models.ClusterPermission{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrPermissionNotFound
+	}
+
+	return nil
+}
+
+// ResolveClusterAccess groups the permissions that apply to a user with the
+// given identities and team IDs, and the user's active access requests, by
+// cluster. Group permissions apply when any identity, or the identity of the
+// permission's provider, has the group.
+func ResolveClusterAccess(permissions []models.ClusterPermission, requests []models.AccessRequest, userID uint, identities []models.UserIdentity, teams []uint) map[uint]*ClusterAccess {
+	access := make(map[uint]*ClusterAccess)
+	for _, permission := range permissions {
+		if permissionApplies(&permission, userID, identities, teams) {
+			addPermission(access, permission, true)
+		}
+	}
+	for i := range requests {
+		addPermission(access, requests[i].Permission(), false)
+	}
+	return access
+}
+
+// addPermission adds a permission to the access of its cluster. Only standing
+// permissions, not those of access requests, raise StandingLevel and are
+// added to StandingNamespaces.
+func addPermission(access map[uint]*ClusterAccess, permission models.ClusterPermission, standing bool) {
+	cluster := access[permission.ClusterID]
+	if cluster == nil {
+		cluster = &ClusterAccess{}
+		access[permission.ClusterID] = cluster
+	}
+
+	if permission.IsNamespaced() {
+		cluster.Namespaces = append(cluster.Namespaces, permission)
+		if standing {
+			cluster.StandingNamespaces = append(cluster.StandingNamespaces, permission)
+		}
+	} else {
+		cluster.Level = models.HigherClusterLevel(cluster.Level, permission.Level)
+		if standing {
+			cluster.StandingLevel = models.HigherClusterLevel(cluster.StandingLevel, permission.Level)
+		}
+	}
+}
+
+func permissionApplies(permission *models.ClusterPermission, userID uint, identities []models.UserIdentity, teams []uint) bool {
+	switch {
+	case permission.TeamID != 0:
+		for _, team := range teams {
+			if team == permission.TeamID {
+				return true
+			}
+		}
+		return false
+	case permission.Group != "":
+		return identitiesHaveGroup(identities, permission.Provider, permission.Group)
+	default:
+		return permission.UserID == userID
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+func TestResolveClusterAccess(t *testing.T) {
+	identities := []models.UserIdentity{
+		{Provider: "keycloak", Groups: []string{"sre"}},
+		{Provider: "google"},
+	}
+
+	permissions := []models.ClusterPermission{
+		{ClusterID: 1, UserID: 7, Level: models.ClusterLevelViewer},
+		{ClusterID: 1, Group: "sre", Level: models.ClusterLevelOperator},
+		{ClusterID: 2, Group: "sre", Provider: "okta", Level: models.ClusterLevelAdmin},
+		{ClusterID: 3, Group: "sre", Provider: "keycloak", Level: models.ClusterLevelViewer},
+		{ClusterID: 4, UserID: 8, Level: models.ClusterLevelAdmin},
+		{ClusterID: 5, Group: "developers", Level: models.ClusterLevelAdmin},
+		{ClusterID: 6, UserID: 7, Namespace: "team-a-*", Level: models.ClusterLevelOperator},
+		{ClusterID: 7, TeamID: 2, Level: models.ClusterLevelAdmin},
+		{ClusterID: 8, TeamID: 3, Level: models.ClusterLevelAdmin},
+		{ClusterID: 3, TeamID: 2, Level: models.ClusterLevelOperator},
+	}
+
+	requests := []models.AccessRequest{
+		{ClusterID: 1, UserID: 7, Level: models.ClusterLevelAdmin},
+		{ClusterID: 9, UserID: 7, Level: models.ClusterLevelOperator},
+	}
+
+	access := ResolveClusterAccess(permissions, requests, 7, identities, []uint{1, 2})
+
+	expected := map[uint][2]string{
+		1: {models.ClusterLevelAdmin, models.ClusterLevelOperator},
+		3: {models.ClusterLevelOperator, models.ClusterLevelOperator},
+		6: {"", ""},
+		7: {models.ClusterLevelAdmin, models.ClusterLevelAdmin},
+		9: {models.ClusterLevelOperator, ""},
+	}
+	if len(access) != len(expected) {
+		t.Fatalf("Expected access to clusters %v, got %v", expected, access)
+	}
+	for clusterID, levels := range expected {
+		if access[clusterID] == nil || access[clusterID].Level != levels[0] || access[clusterID].StandingLevel != levels[1] {
+			t.Errorf("Expected levels %q on cluster %d, got %+v", levels, clusterID, access[clusterID])
+		}
+	}
+
+	if len(access[6].Namespaces) != 1 {
+		t.Errorf("Expected one namespace permission on cluster 6, got %v", access[6].Namespaces)
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Identity is the verified user profile returned by a login provider
+type Identity struct {
+	Provider string
+	Subject  string
+	Email    string
+	// EmailVerified is whether the provider vouches that Email belongs to
+	// the user. Unverified addresses do not match email domain rules.
+	EmailVerified bool
+	Name          string
+	Picture       string
+	Groups        []string
+}
+
+// Provider is an external identity provider users can log in with
+type Provider interface {
+	// Name is the identifier used in /auth/:provider routes
+	Name() string
+	// DisplayName is shown on the login page
+	DisplayName() string
+	// AuthCodeURL returns the URL that starts the login at the provider. The
+	// PKCE challenge is derived from codeVerifier.
+	AuthCodeURL(state, nonce, codeVerifier string) (string, error)
+	// Exchange redeems the authorization code and returns the verified identity
+	Exchange(ctx context.Context, code, nonce, codeVerifier string) (*Identity, error)
+}
+
+// LoadProviders builds the enabled login providers from the environment.
+// Google is enabled when GOOGLE_CLIENT_ID is set. Generic OpenID Connect
+// providers are listed in OIDC_PROVIDERS and configured with
+// OIDC_<NAME>_* variables, see NewOIDCProviderFromEnv.
+func LoadProviders() (map[string]Provider, error) {
+	providers := make(map[string]Provider)
+
+	if os.Getenv("GOOGLE_CLIENT_ID") != "" {
+		google := NewGoogleProvider()
+		providers[google.Name()] = google
+	}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, exists := providers[name]; exists {
+			return nil, fmt.Errorf("duplicate login provider %q", name)
+		}
+
+		provider, err := NewOIDCProviderFromEnv(name)
+		if err != nil {
+			return nil, err
+		}
+		providers[name] = provider
+	}
+
+	return providers, nil
+}
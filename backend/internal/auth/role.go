@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// ErrRoleNotFound is returned when a role name does not exist
+var ErrRoleNotFound = errors.New("role not found")
+
+// PermissionInfo describes a permission roles can be composed of
+type PermissionInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Admin permissions are only usable with access tokens that have the
+	// admin scope
+	Admin bool `json:"admin"`
+}
+
+// Permissions lists every permission a role can have
+var Permissions = []PermissionInfo{
+	{Name: models.PermClustersRead, Description: "List and view clusters"},
+	{Name: models.PermClustersCreate, Description: "Add clusters"},
+	{Name: models.PermClustersUpdate, Description: "Update clusters"},
+	{Name: models.PermClustersDelete, Description: "Delete clusters"},
+	{Name: models.PermClustersGrant, Description: "Grant and revoke access to clusters"},
+	{Name: models.PermClustersAll, Description: "Access every cluster without being granted access", Admin: true},
+	{Name: models.PermWorkloadsRead, Description: "List namespaces, pods, deployments and services"},
+	{Name: models.PermLogsRead, Description: "Read pod logs"},
+	{Name: models.PermPodsDelete, Description: "Delete pods"},
+	{Name: models.PermDeploymentsScale, Description: "Scale deployments"},
+	{Name: models.PermResourcesDelete, Description: "Delete namespaced resources of any kind"},
+	{Name: models.PermResourcesApply, Description: "Create and update namespaced resources from manifests"},
+	{Name: models.PermUsersRead, Description: "List users"},
+	{Name: models.PermUsersApprove, Description: "Approve and reject pending users", Admin: true},
+	{Name: models.PermUsersManage, Description: "Change user roles and end their sessions", Admin: true},
+	{Name: models.PermRolesManage, Description: "Create, update and delete roles", Admin: true},
+	{Name: models.PermServiceAccountsManage, Description: "Manage service accounts and their tokens", Admin: true},
+	{Name: models.PermApprovalRulesManage, Description: "Manage auto-approval rules", Admin: true},
+	{Name: models.PermTeamsManage, Description: "Manage teams and their members", Admin: true},
+	{Name: models.PermAccessRequestsCreate, Description: "Request temporary access to clusters"},
+	{Name: models.PermAccessRequestsApprove, Description: "Approve, deny and revoke access requests on clusters they administer", Admin: true},
+	{Name: models.PermAuditRead, Description: "Search and export the audit log", Admin: true},
+	{Name: models.PermAuditManage, Description: "Configure where audit log entries are forwarded", Admin: true},
+}
+
+func permissionInfo(name string) (PermissionInfo, bool) {
+	for _, info := range Permissions {
+		if info.Name == name {
+			return info, true
+		}
+	}
+	return PermissionInfo{}, false
+}
+
+// NormalizePermissions validates permission names and returns them sorted
+// without duplicates. "*" stands for every permission.
+func NormalizePermissions(permissions []string) ([]string, error) {
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		permission = strings.TrimSpace(permission)
+		if _, ok := permissionInfo(permission); !ok && permission != models.PermAll {
+			return nil, fmt.Errorf("unknown permission %q", permission)
+		}
+		if !seen[permission] {
+			seen[permission] = true
+			result = append(result, permission)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// PermissionSet is the set of permissions of a role
+type PermissionSet map[string]bool
+
+func NewPermissionSet(permissions []string) PermissionSet {
+	set := make(PermissionSet, len(permissions))
+	for _, permission := range permissions {
+		set[permission] = true
+	}
+	return set
+}
+
+// Has reports whether the set contains permission, directly or through "*"
+func (s PermissionSet) Has(permission string) bool {
+	return s[models.PermAll] || s[permission]
+}
+
+// HasAdmin reports whether the set contains any admin permission
+func (s PermissionSet) HasAdmin() bool {
+	for _, info := range Permissions {
+		if info.Admin && s.Has(info.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleStore resolves role names to their permissions
+type RoleStore interface {
+	// Permissions returns the permissions of the named role, or
+	// ErrRoleNotFound
+	Permissions(name string) (PermissionSet, error)
+}
+
+// GormRoleStore is a RoleStore backed by the roles table
+type GormRoleStore struct {
+	db *gorm.DB
+}
+
+func NewGormRoleStore(db *gorm.DB) *GormRoleStore {
+	return &GormRoleStore{db: db}
+}
+
+func (s *GormRoleStore) Permissions(name string) (PermissionSet, error) {
+	var role models.Role
+	if err := s.db.Where("name = ?", name).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, err
+	}
+	return NewPermissionSet(role.Permissions), nil
+}
+
+// HasPermission reports whether the caller's role, stored in the context by
+// AuthRequired, grants permission. Admin permissions also need the admin
+// scope when the caller uses an access token.
+func HasPermission(c *gin.Context, permission string) bool {
+	value, _ := c.Get("permissions")
+	set, _ := value.(PermissionSet)
+	if !set.Has(permission) {
+		return false
+	}
+
+	if info, _ := permissionInfo(permission); info.Admin {
+		if scopes, ok := c.Get("token_scopes"); ok {
+			return HasScope(scopes.(string), ScopeAdmin)
+		}
+	}
+	return true
+}
+
+// CanGrant reports whether the caller holds every permission of set, so that
+// giving set to a role, user or rule does not escalate anyone past the caller.
+// "*" needs every permission.
+func CanGrant(c *gin.Context, set PermissionSet) bool {
+	for _, info := range Permissions {
+		if set.Has(info.Name) && !HasPermission(c, info.Name) {
+			return false
+		}
+	}
+	if set[models.PermAll] {
+		value, _ := c.Get("permissions")
+		caller, _ := value.(PermissionSet)
+		return caller[models.PermAll]
+	}
+	return true
+}
+
+// EffectivePermissions lists the permissions HasPermission grants the caller,
+// with "*" expanded
+func EffectivePermissions(c *gin.Context) []string {
+	result := make([]string, 0)
+	for _, info := range Permissions {
+		if HasPermission(c, info.Name) {
+			result = append(result, info.Name)
+		}
+	}
+	return result
+}
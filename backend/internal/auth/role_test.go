@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+func TestNormalizePermissions(t *testing.T) {
+	permissions, err := NormalizePermissions([]string{" pods:delete", "logs:read", "pods:delete"})
+	if err != nil {
+		t.Fatalf("Expected permissions to be valid, got %v", err)
+	}
+	if want := []string{"logs:read", "pods:delete"}; !reflect.DeepEqual(permissions, want) {
+		t.Errorf("Expected %v, got %v", want, permissions)
+	}
+
+	if _, err := NormalizePermissions([]string{"pods:create"}); err == nil {
+		t.Error("Expected an unknown permission to be rejected")
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	testCases := []struct {
+		name       string
+		role       []string
+		scopes     string
+		permission string
+		want       bool
+	}{
+		{"granted", []string{models.PermLogsRead}, "", models.PermLogsRead, true},
+		{"not granted", []string{models.PermLogsRead}, "", models.PermPodsDelete, false},
+		{"wildcard", []string{models.PermAll}, "", models.PermPodsDelete, true},
+		{"admin permission with session", []string{models.PermUsersApprove}, "", models.PermUsersApprove, true},
+		{"admin permission without admin scope", []string{models.PermUsersApprove}, "read,write", models.PermUsersApprove, false},
+		{"admin permission with admin scope", []string{models.PermUsersApprove}, "admin,read", models.PermUsersApprove, true},
+		{"other permission with token", []string{models.PermAll}, "read", models.PermLogsRead, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(nil)
+			c.Set("permissions", NewPermissionSet(tc.role))
+			if tc.scopes != "" {
+				c.Set("token_scopes", tc.scopes)
+			}
+
+			if got := HasPermission(c, tc.permission); got != tc.want {
+				t.Errorf("Expected %t, got %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCanGrant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		caller []string
+		scopes string
+		grant  []string
+		want   bool
+	}{
+		{"subset", []string{models.PermRolesManage, models.PermPodsDelete}, "", []string{models.PermPodsDelete}, true},
+		{"permission the caller lacks", []string{models.PermRolesManage}, "", []string{models.PermPodsDelete}, false},
+		{"wildcard without wildcard", []string{models.PermRolesManage, models.PermPodsDelete}, "", []string{models.PermAll}, false},
+		{"wildcard with wildcard", []string{models.PermAll}, "", []string{models.PermAll}, true},
+		{"admin permission with a token without admin scope", []string{models.PermAll}, "read", []string{models.PermUsersManage}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(nil)
+			c.Set("permissions", NewPermissionSet(tc.caller))
+			if tc.scopes != "" {
+				c.Set("token_scopes", tc.scopes)
+			}
+			if got := CanGrant(c, NewPermissionSet(tc.grant)); got != tc.want {
+				t.Errorf("CanGrant(%v) = %v, want %v", tc.grant, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrSessionNotFound is returned when a session does not exist or belongs to another user
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionInvalid is returned when a session has been revoked or has expired
+	ErrSessionInvalid = errors.New("session revoked or expired")
+	// ErrRefreshTokenInvalid is returned for unknown or expired refresh tokens
+	ErrRefreshTokenInvalid = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused is returned when an already used refresh token is
+	// presented again; the whole session is revoked when this happens
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// SessionStore tracks the server-side half of every issued token so that
+// tokens can be revoked before they expire
+type SessionStore interface {
+	// Create records a new session together with its first refresh token
+	Create(session *models.Session, refreshTokenHash string) error
+	// Validate returns the active session for a token ID (jti) owned by userID
+	Validate(tokenID string, userID uint) (*models.Session, error)
+	// ListActive returns the user's sessions that are neither revoked nor expired
+	ListActive(userID uint) ([]models.Session, error)
+	// Revoke revokes a single session owned by userID
+	Revoke(userID, sessionID uint) error
+	// RevokeAll revokes every active session of a user and returns how many were revoked
+	RevokeAll(userID uint) (int64, error)
+	// Rotate consumes a refresh token, stores newRefreshTokenHash as its
+	// successor and points the session at newTokenID
+	Rotate(refreshTokenHash, newRefreshTokenHash, newTokenID string) (*models.Session, error)
+}
+
+// GormSessionStore is a SessionStore backed by the sessions table
+type GormSessionStore struct {
+	db *gorm.DB
+}
+
+func NewGormSessionStore(db *gorm.DB) *GormSessionStore {
+	return &GormSessionStore{db: db}
+}
+
+func (s *GormSessionStore) Create(session *models.Session, refreshTokenHash string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.RefreshToken{
+			SessionID: session.ID,
+			UserID:    session.UserID,
+			TokenHash: refreshTokenHash,
+			ExpiresAt: session.ExpiresAt,
+		}).Error
+	})
+}
+
+func (s *GormSessionStore) Validate(tokenID string, userID uint) (*models.Session, error) {
+	var session models.Session
+	err := s.db.Where("token = ? AND user_id = ?", tokenID, userID).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return nil, ErrSessionInvalid
+	}
+
+	return &session, nil
+}
+
+func (s *GormSessionStore) ListActive(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := s.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("created_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (s *GormSessionStore) Revoke(userID, sessionID uint) error {
+	result := s.db.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (s *GormSessionStore) RevokeAll(userID uint) (int64, error) {
+	result := s.db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+func (s *GormSessionStore) Rotate(refreshTokenHash, newRefreshTokenHash, newTokenID string) (*models.Session, error) {
+	var session models.Session
+	reused := false
+	now := time.Now()
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var refreshToken models.RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", refreshTokenHash).
+			First(&refreshToken).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRefreshTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+
+		// A used token showing up again means it was stolen or replayed, so
+		// the whole family is revoked. The revocation has to be committed,
+		// hence the flag instead of returning an error here.
+		if refreshToken.UsedAt != nil {
+			reused = true
+			return tx.Model(&models.Session{}).
+				Where("id = ? AND revoked_at IS NULL", refreshToken.SessionID).
+				Update("revoked_at", now).Error
+		}
+
+		if now.After(refreshToken.ExpiresAt) {
+			return ErrRefreshTokenInvalid
+		}
+
+		if err := tx.First(&session, refreshToken.SessionID).Error; err != nil {
+			return err
+		}
+		if session.RevokedAt != nil || now.After(session.ExpiresAt) {
+			return ErrSessionInvalid
+		}
+
+		if err := tx.Model(&refreshToken).Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Create(&models.RefreshToken{
+			SessionID: session.ID,
+			UserID:    session.UserID,
+			TokenHash: newRefreshTokenHash,
+			ExpiresAt: session.ExpiresAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		session.Token = newTokenID
+		return tx.Model(&session).Update("token", newTokenID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if reused {
+		return nil, ErrRefreshTokenReused
+	}
+
+	return &session, nil
+}
+
+// newRefreshToken returns an opaque refresh token and the hash to store for it
+func newRefreshToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hex SHA-256 of a token so raw tokens are never stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newTokenID returns a random identifier used as the jti of issued tokens
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
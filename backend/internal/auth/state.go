@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StateTTL is how long a user has to complete a login at the provider
+const StateTTL = 5 * time.Minute
+
+// ErrStateNotFound is returned for unknown, expired or already used states
+var ErrStateNotFound = errors.New("invalid or expired state")
+
+// LoginState is what is remembered about a login between redirecting the
+// user to the provider and the provider redirecting back
+type LoginState struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	// LinkUserID is the user who links the identity, 0 for a login
+	LinkUserID uint
+	ExpiresAt  time.Time
+}
+
+// StateStore keeps pending logins. It has to be shared by all replicas since
+// the callback may reach a different pod than the one that started the login.
+type StateStore interface {
+	// Save records a pending login
+	Save(state *LoginState) error
+	// Consume returns and deletes a pending login so that it can be used only once
+	Consume(state string) (*LoginState, error)
+	// DeleteExpired removes logins that were never completed
+	DeleteExpired() error
+}
+
+// GormStateStore is a StateStore backed by the oauth_states table
+type GormStateStore struct {
+	db *gorm.DB
+}
+
+func NewGormStateStore(db *gorm.DB) *GormStateStore {
+	return &GormStateStore{db: db}
+}
+
+func (s *GormStateStore) Save(state *LoginState) error {
+	row := &models.OAuthState{
+		StateHash:    hashToken(state.State),
+		Provider:     state.Provider,
+		Nonce:        state.Nonce,
+		CodeVerifier: state.CodeVerifier,
+		ExpiresAt:    state.ExpiresAt,
+	}
+	if state.LinkUserID != 0 {
+		row.LinkUserID = &state.LinkUserID
+	}
+	return s.db.Create(row).Error
+}
+
+func (s *GormStateStore) Consume(state string) (*LoginState, error) {
+	// DELETE ... RETURNING makes load-and-delete atomic, so two concurrent
+	// callbacks with the same state cannot both succeed
+	var rows []models.OAuthState
+	result := s.db.Clauses(clause.Returning{}).
+		Where("state_hash = ?", hashToken(state)).
+		Delete(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if len(rows) == 0 || time.Now().After(rows[0].ExpiresAt) {
+		return nil, ErrStateNotFound
+	}
+
+	login := &LoginState{
+		State:        state,
+		Provider:     rows[0].Provider,
+		Nonce:        rows[0].Nonce,
+		CodeVerifier: rows[0].CodeVerifier,
+		ExpiresAt:    rows[0].ExpiresAt,
+	}
+	if rows[0].LinkUserID != nil {
+		login.LinkUserID = *rows[0].LinkUserID
+	}
+	return login, nil
+}
+
+func (s *GormStateStore) DeleteExpired() error {
+	return s.db.Where("expires_at < ?", time.Now()).Delete(&models.OAuthState{}).Error
+}
+
+// generateRandomState returns 256 bits from crypto/rand, base64url encoded
+func generateRandomState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
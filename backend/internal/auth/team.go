@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// identitiesHaveGroup reports whether any identity has group. A non-empty
+// provider restricts the check to that provider's identity.
+func identitiesHaveGroup(identities []models.UserIdentity, provider, group string) bool {
+	for _, identity := range identities {
+		if provider != "" && identity.Provider != provider {
+			continue
+		}
+		for _, g := range identity.Groups {
+			if g == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TeamSyncMatches reports whether a user with the given identities belongs to
+// a team through its sync group
+func TeamSyncMatches(team *models.Team, identities []models.UserIdentity) bool {
+	return team.SyncGroup != "" && identitiesHaveGroup(identities, team.SyncProvider, team.SyncGroup)
+}
+
+// syncTeams adds a user to the synced teams whose group they have and removes
+// the memberships added by sync for groups they no longer have. Manual
+// memberships are left alone. The user's identities must be loaded.
+func syncTeams(tx *gorm.DB, user *models.User) error {
+	var teams []models.Team
+	if err := tx.Where("sync_group <> ''").Find(&teams).Error; err != nil {
+		return err
+	}
+	if len(teams) == 0 {
+		return nil
+	}
+
+	var memberships []models.TeamMember
+	if err := tx.Where("user_id = ?", user.ID).Find(&memberships).Error; err != nil {
+		return err
+	}
+	current := make(map[uint]models.TeamMember, len(memberships))
+	for _, membership := range memberships {
+		current[membership.TeamID] = membership
+	}
+
+	for i := range teams {
+		team := &teams[i]
+		membership, isMember := current[team.ID]
+		matches := TeamSyncMatches(team, user.Identities)
+
+		switch {
+		case matches && !isMember:
+			if err := tx.Create(&models.TeamMember{TeamID: team.ID, UserID: user.ID, Source: models.TeamMemberSync}).Error; err != nil {
+				return err
+			}
+		case !matches && isMember && membership.Source == models.TeamMemberSync:
+			if err := tx.Delete(&membership).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
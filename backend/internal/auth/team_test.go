@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+func TestTeamSyncMatches(t *testing.T) {
+	identities := []models.UserIdentity{
+		{Provider: "keycloak", Groups: []string{"platform", "sre"}},
+		{Provider: "google"},
+	}
+
+	testCases := []struct {
+		name string
+		team models.Team
+		want bool
+	}{
+		{"group of any provider", models.Team{SyncGroup: "sre"}, true},
+		{"group of the sync provider", models.Team{SyncGroup: "platform", SyncProvider: "keycloak"}, true},
+		{"group of another provider", models.Team{SyncGroup: "sre", SyncProvider: "okta"}, false},
+		{"missing group", models.Team{SyncGroup: "developers"}, false},
+		{"team without sync", models.Team{}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := TeamSyncMatches(&tc.team, identities); got != tc.want {
+				t.Errorf("Expected %t, got %t", tc.want, got)
+			}
+		})
+	}
+}
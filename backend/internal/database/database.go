@@ -2,11 +2,14 @@ package database
 
 import (
 	"fmt"
+	"log"
 	"os"
 
+	"github.com/mysticrenji/surfer/backend/internal/audit"
 	"github.com/mysticrenji/surfer/backend/internal/models"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // InitDB initializes the database connection
@@ -34,12 +37,125 @@ func InitDB() (*gorm.DB, error) {
 
 // RunMigrations runs database migrations
 func RunMigrations(db *gorm.DB) error {
-	return db.AutoMigrate(
+	if err := db.AutoMigrate(
 		&models.User{},
+		&models.UserIdentity{},
+		&models.Role{},
+		&models.ApprovalRule{},
+		&models.Team{},
+		&models.TeamMember{},
 		&models.Cluster{},
+		&models.AccessRequest{},
+		&models.ChangeRequest{},
 		&models.Session{},
+		&models.RefreshToken{},
+		&models.PersonalAccessToken{},
+		&models.OAuthState{},
 		&models.AuditLog{},
-	)
+		&models.AuditCheckpoint{},
+		&models.AuditSink{},
+		&models.AuditOutbox{},
+	); err != nil {
+		return err
+	}
+
+	if err := migrateGoogleIdentities(db); err != nil {
+		return err
+	}
+
+	if err := migrateClusterPermissions(db); err != nil {
+		return fmt.Errorf("failed to migrate cluster permissions: %w", err)
+	}
+
+	if err := migrateRoles(db); err != nil {
+		return err
+	}
+
+	return migrateAuditChain(db)
+}
+
+// migrateAuditChain links audit log entries written before the hash chain
+// existed into it
+func migrateAuditChain(db *gorm.DB) error {
+	sealed, err := audit.Seal(db)
+	if err != nil {
+		return fmt.Errorf("failed to seal audit log: %w", err)
+	}
+	if sealed > 0 {
+		log.Printf("Sealed %d existing audit log entries into the hash chain", sealed)
+	}
+	return nil
+}
+
+// migrateRoles creates or refreshes the built-in roles and moves users and
+// approval rules off the former "user" role to operator, which has the same
+// permissions. Every statement is idempotent.
+func migrateRoles(db *gorm.DB) error {
+	for _, role := range models.BuiltInRoles() {
+		if err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"description", "permissions", "built_in", "updated_at"}),
+		}).Create(&role).Error; err != nil {
+			return fmt.Errorf("failed to create built-in roles: %w", err)
+		}
+	}
+
+	statements := []string{
+		`UPDATE users SET role = 'operator' WHERE role = 'user'`,
+		`UPDATE approval_rules SET role = 'operator' WHERE role = 'user'`,
+	}
+
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to migrate roles: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// migrateClusterPermissions migrates cluster_permissions. When the table is
+// created, in the same transaction, the creators of existing clusters get
+// admin access, so that clusters added while every user could see every
+// cluster keep an owner. This happens once: permissions revoked later stay
+// revoked.
+func migrateClusterPermissions(db *gorm.DB) error {
+	if db.Migrator().HasTable(&models.ClusterPermission{}) {
+		return db.AutoMigrate(&models.ClusterPermission{})
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&models.ClusterPermission{}); err != nil {
+			return err
+		}
+		return tx.Exec(`INSERT INTO cluster_permissions (cluster_id, user_id, level, granted_by, created_at, updated_at)
+			SELECT id, created_by, 'admin', created_by, NOW(), NOW() FROM clusters
+			WHERE deleted_at IS NULL AND created_by <> 0`).Error
+	})
+}
+
+// migrateGoogleIdentities relaxes the constraints users.google_id had while
+// Google was the only login provider and links existing Google users to a
+// user_identities row. AutoMigrate does not drop constraints, so this is done
+// explicitly; every statement is idempotent.
+func migrateGoogleIdentities(db *gorm.DB) error {
+	statements := []string{
+		`ALTER TABLE users ALTER COLUMN google_id DROP NOT NULL`,
+		`ALTER TABLE users DROP CONSTRAINT IF EXISTS users_google_id_key`,
+		`ALTER TABLE users DROP CONSTRAINT IF EXISTS uni_users_google_id`,
+		`INSERT INTO user_identities (user_id, provider, subject, email, created_at, updated_at)
+			SELECT id, 'google', google_id, email, NOW(), NOW() FROM users
+			WHERE google_id IS NOT NULL AND google_id <> '' AND deleted_at IS NULL
+			ON CONFLICT (provider, subject) DO NOTHING`,
+	}
+
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to migrate google identities: %w", err)
+		}
+	}
+
+	return nil
 }
 
 func getEnv(key, defaultValue string) string {
@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/auth"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// maxAccessRequestDuration caps how long an approved access request lasts
+const maxAccessRequestDuration = 12 * time.Hour
+
+// AccessRequestHandler manages requests for temporary cluster access and
+// their review
+type AccessRequestHandler struct {
+	db          *gorm.DB
+	permissions auth.PermissionStore
+}
+
+func NewAccessRequestHandler(db *gorm.DB, permissions auth.PermissionStore) *AccessRequestHandler {
+	return &AccessRequestHandler{db: db, permissions: permissions}
+}
+
+// ListMyRequests returns the caller's access requests, newest first
+func (h *AccessRequestHandler) ListMyRequests(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var requests []models.AccessRequest
+	if err := h.db.Preload("Cluster").Where("user_id = ?", userID).Order("id DESC").Limit(100).Find(&requests).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch access requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+// CreateRequest asks for a level on a cluster, or on namespaces matching a
+// glob, for a duration such as "2h"
+func (h *AccessRequestHandler) CreateRequest(c *gin.Context) {
+	var req struct {
+		ClusterID uint   `json:"cluster_id" binding:"required"`
+		Namespace string `json:"namespace"`
+		Level     string `json:"level" binding:"required"`
+		Duration  string `json:"duration" binding:"required"`
+		Reason    string `json:"reason" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !models.ValidClusterLevel(req.Level) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid level. Must be 'viewer', 'operator' or 'admin'"})
+		return
+	}
+
+	req.Namespace = strings.TrimSpace(req.Namespace)
+	if err := auth.ValidateNamespaceScope(req.Namespace, ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration < time.Minute || duration > maxAccessRequestDuration {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("duration must be between 1m and %s", maxAccessRequestDuration)})
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+		return
+	}
+
+	var cluster models.Cluster
+	if err := h.db.First(&cluster, req.ClusterID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cluster not found"})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	request := models.AccessRequest{
+		UserID:          userID.(uint),
+		ClusterID:       cluster.ID,
+		Namespace:       req.Namespace,
+		Level:           req.Level,
+		DurationMinutes: int(duration.Round(time.Minute) / time.Minute),
+		Reason:          req.Reason,
+		Status:          models.AccessRequestPending,
+	}
+
+	if err := h.db.Create(&request).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create access request"})
+		return
+	}
+
+	recordAudit(c, "create", "access_request", request.ID, fmt.Sprintf("cluster=%d namespace=%s level=%s duration=%dm reason=%s", request.ClusterID, request.Namespace, request.Level, request.DurationMinutes, request.Reason))
+	c.JSON(http.StatusCreated, request)
+}
+
+// CancelRequest withdraws one of the caller's pending requests or gives up
+// approved access before it expires
+func (h *AccessRequestHandler) CancelRequest(c *gin.Context) {
+	request, ok := h.loadRequest(c)
+	if !ok {
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	if request.UserID != userID.(uint) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Access request not found"})
+		return
+	}
+
+	if !h.end(c, request, models.AccessRequestCancelled, nil, "") {
+		return
+	}
+
+	recordAudit(c, "cancel", "access_request", request.ID, "")
+	c.JSON(http.StatusOK, request)
+}
+
+// ListReviewable returns the pending requests the caller may approve
+func (h *AccessRequestHandler) ListReviewable(c *gin.Context) {
+	var pending []models.AccessRequest
+	if err := h.db.Preload("User").Preload("Cluster").
+		Where("status = ?", models.AccessRequestPending).
+		Order("id").Find(&pending).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch access requests"})
+		return
+	}
+
+	reviewable := make([]models.AccessRequest, 0, len(pending))
+	for _, request := range pending {
+		ok, err := h.canReview(c, &request)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check cluster access"})
+			return
+		}
+		if ok {
+			reviewable = append(reviewable, request)
+		}
+	}
+
+	c.JSON(http.StatusOK, reviewable)
+}
+
+type reviewRequest struct {
+	Comment string `json:"comment"`
+}
+
+// ApproveRequest grants the requested access until now plus the requested
+// duration
+func (h *AccessRequestHandler) ApproveRequest(c *gin.Context) {
+	request, comment, ok := h.loadForReview(c)
+	if !ok {
+		return
+	}
+
+	if request.Status != models.AccessRequestPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Access request is " + request.Status})
+		return
+	}
+
+	reviewerID, _ := c.Get("user_id")
+	now := time.Now()
+	// The reaper may not have run yet, so a stale request is checked here too
+	if auth.AccessRequestExpired(request, now) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Access request has expired"})
+		return
+	}
+	expiresAt := now.Add(time.Duration(request.DurationMinutes) * time.Minute)
+
+	result := h.db.Model(&models.AccessRequest{}).
+		Where("id = ? AND status = ? AND created_at > ?", request.ID, models.AccessRequestPending, now.Add(-auth.PendingAccessRequestTTL)).
+		Updates(map[string]interface{}{
+			"status":         models.AccessRequestApproved,
+			"reviewed_by":    reviewerID,
+			"reviewed_at":    now,
+			"review_comment": comment,
+			"expires_at":     expiresAt,
+		})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve access request"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Access request is no longer pending"})
+		return
+	}
+
+	request.Status = models.AccessRequestApproved
+	request.ReviewedBy = uintPtr(reviewerID.(uint))
+	request.ReviewedAt = &now
+	request.ReviewComment = comment
+	request.ExpiresAt = &expiresAt
+
+	recordAudit(c, "approve", "access_request", request.ID, fmt.Sprintf("user=%d cluster=%d namespace=%s level=%s expires_at=%s", request.UserID, request.ClusterID, request.Namespace, request.Level, expiresAt.Format(time.RFC3339)))
+	c.JSON(http.StatusOK, request)
+}
+
+func (h *AccessRequestHandler) DenyRequest(c *gin.Context) {
+	request, comment, ok := h.loadForReview(c)
+	if !ok {
+		return
+	}
+
+	if request.Status != models.AccessRequestPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Access request is " + request.Status})
+		return
+	}
+
+	reviewerID, _ := c.Get("user_id")
+	if !h.end(c, request, models.AccessRequestDenied, uintPtr(reviewerID.(uint)), comment) {
+		return
+	}
+
+	recordAudit(c, "deny", "access_request", request.ID, "comment="+comment)
+	c.JSON(http.StatusOK, request)
+}
+
+// RevokeRequest ends approved access before it expires
+func (h *AccessRequestHandler) RevokeRequest(c *gin.Context) {
+	request, comment, ok := h.loadForReview(c)
+	if !ok {
+		return
+	}
+
+	if request.Status != models.AccessRequestApproved {
+		c.JSON(http.StatusConflict, gin.H{"error": "Access request is " + request.Status})
+		return
+	}
+
+	reviewerID, _ := c.Get("user_id")
+	if !h.end(c, request, models.AccessRequestRevoked, uintPtr(reviewerID.(uint)), comment) {
+		return
+	}
+
+	recordAudit(c, "revoke", "access_request", request.ID, "comment="+comment)
+	c.JSON(http.StatusOK, request)
+}
+
+// end moves a pending or approved request to a final status and writes the
+// error response when it is already final
+func (h *AccessRequestHandler) end(c *gin.Context, request *models.AccessRequest, status string, reviewedBy *uint, comment string) bool {
+	now := time.Now()
+	updates := map[string]interface{}{"status": status, "ended_at": now}
+	if reviewedBy != nil {
+		updates["reviewed_by"] = *reviewedBy
+		updates["reviewed_at"] = now
+	}
+	if comment != "" {
+		updates["review_comment"] = comment
+	}
+
+	result := h.db.Model(&models.AccessRequest{}).
+		Where("id = ? AND status IN ?", request.ID, []string{models.AccessRequestPending, models.AccessRequestApproved}).
+		Updates(updates)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update access request"})
+		return false
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Access request is already " + request.Status})
+		return false
+	}
+
+	request.Status = status
+	request.EndedAt = &now
+	if reviewedBy != nil {
+		request.ReviewedBy = reviewedBy
+		request.ReviewedAt = &now
+	}
+	if comment != "" {
+		request.ReviewComment = comment
+	}
+	return true
+}
+
+// loadForReview loads the request in the :id parameter and the review
+// comment, and checks that the caller may review it. Nobody reviews their own
+// requests.
+func (h *AccessRequestHandler) loadForReview(c *gin.Context) (*models.AccessRequest, string, bool) {
+	request, ok := h.loadRequest(c)
+	if !ok {
+		return nil, "", false
+	}
+
+	var review reviewRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&review); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, "", false
+		}
+	}
+
+	allowed, err := h.canReview(c, request)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check cluster access"})
+		return nil, "", false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins of the cluster other than the requester can review this request"})
+		return nil, "", false
+	}
+
+	return request, strings.TrimSpace(review.Comment), true
+}
+
+// canReview reports whether the caller administers the request's cluster,
+// through standing permissions rather than access requests of their own, and
+// did not make the request
+func (h *AccessRequestHandler) canReview(c *gin.Context, request *models.AccessRequest) (bool, error) {
+	userID, _ := c.Get("user_id")
+	if request.UserID == userID.(uint) {
+		return false, nil
+	}
+
+	if auth.HasPermission(c, models.PermClustersAll) {
+		return true, nil
+	}
+
+	access, err := h.permissions.ClusterAccess(userID.(uint), request.ClusterID)
+	if err != nil {
+		return false, err
+	}
+	return models.ClusterLevelAllows(access.StandingLevel, models.ClusterLevelAdmin), nil
+}
+
+// loadRequest loads the request in the :id parameter and writes the error
+// response when there is none
+func (h *AccessRequestHandler) loadRequest(c *gin.Context) (*models.AccessRequest, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid access request ID"})
+		return nil, false
+	}
+
+	var request models.AccessRequest
+	if err := h.db.First(&request, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Access request not found"})
+		return nil, false
+	}
+
+	return &request, true
+}
+
+func uintPtr(v uint) *uint {
+	return &v
+}
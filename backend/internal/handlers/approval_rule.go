@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/auth"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// ApprovalRuleHandler manages the rules that approve pending users by email
+// domain or provider group
+type ApprovalRuleHandler struct {
+	db    *gorm.DB
+	roles auth.RoleStore
+}
+
+func NewApprovalRuleHandler(db *gorm.DB, roles auth.RoleStore) *ApprovalRuleHandler {
+	return &ApprovalRuleHandler{db: db, roles: roles}
+}
+
+type ApprovalRuleRequest struct {
+	Name      string `json:"name"`
+	MatchType string `json:"match_type" binding:"required"`
+	Value     string `json:"value" binding:"required"`
+	Provider  string `json:"provider"`
+	Role      string `json:"role" binding:"required"`
+	Disabled  bool   `json:"disabled"`
+}
+
+func (r *ApprovalRuleRequest) rule() (*models.ApprovalRule, error) {
+	rule := &models.ApprovalRule{
+		Name:      r.Name,
+		MatchType: r.MatchType,
+		Value:     r.Value,
+		Provider:  r.Provider,
+		Role:      r.Role,
+		Disabled:  r.Disabled,
+	}
+	if err := auth.NormalizeApprovalRule(rule); err != nil {
+		return nil, err
+	}
+	if rule.Name == "" {
+		rule.Name = rule.MatchType + " " + rule.Value
+	}
+	return rule, nil
+}
+
+func (h *ApprovalRuleHandler) ListRules(c *gin.Context) {
+	var rules []models.ApprovalRule
+	if err := h.db.Order("id").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch approval rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+func (h *ApprovalRuleHandler) CreateRule(c *gin.Context) {
+	var req ApprovalRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule, err := req.rule()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkRole(c, h.roles, rule.Role) {
+		return
+	}
+
+	adminID, _ := c.Get("user_id")
+	rule.CreatedBy = adminID.(uint)
+
+	if err := h.db.Create(rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create approval rule"})
+		return
+	}
+
+	recordAudit(c, "create", "approval_rule", rule.ID, fmt.Sprintf("%s=%s role=%s", rule.MatchType, rule.Value, rule.Role))
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (h *ApprovalRuleHandler) UpdateRule(c *gin.Context) {
+	existing, ok := h.loadRule(c)
+	if !ok {
+		return
+	}
+
+	var req ApprovalRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule, err := req.rule()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkRole(c, h.roles, rule.Role) {
+		return
+	}
+
+	rule.ID = existing.ID
+	rule.CreatedBy = existing.CreatedBy
+	rule.CreatedAt = existing.CreatedAt
+
+	if err := h.db.Save(rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update approval rule"})
+		return
+	}
+
+	recordAudit(c, "update", "approval_rule", rule.ID, fmt.Sprintf("%s=%s role=%s disabled=%t", rule.MatchType, rule.Value, rule.Role, rule.Disabled))
+	c.JSON(http.StatusOK, rule)
+}
+
+func (h *ApprovalRuleHandler) DeleteRule(c *gin.Context) {
+	rule, ok := h.loadRule(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Delete(rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete approval rule"})
+		return
+	}
+
+	recordAudit(c, "delete", "approval_rule", rule.ID, "")
+	c.JSON(http.StatusOK, gin.H{"message": "Approval rule deleted successfully"})
+}
+
+// DryRun lists the pending users a rule would approve without saving the
+// rule or approving anyone
+func (h *ApprovalRuleHandler) DryRun(c *gin.Context) {
+	var req ApprovalRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule, err := req.rule()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkRole(c, h.roles, rule.Role) {
+		return
+	}
+
+	users, err := h.pendingMatches(rule)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pending users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"role":  rule.Role,
+		"count": len(users),
+		"users": users,
+	})
+}
+
+// ApplyRule approves every pending user that a saved rule matches
+func (h *ApprovalRuleHandler) ApplyRule(c *gin.Context) {
+	rule, ok := h.loadRule(c)
+	if !ok {
+		return
+	}
+
+	users, err := h.pendingMatches(rule)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pending users"})
+		return
+	}
+
+	approved := make([]models.User, 0, len(users))
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		for i := range users {
+			ok, err := auth.ApproveByRule(tx, &users[i], rule)
+			if err != nil {
+				return err
+			}
+			if ok {
+				approved = append(approved, users[i])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve users"})
+		return
+	}
+
+	recordAudit(c, "apply", "approval_rule", rule.ID, fmt.Sprintf("approved=%d", len(approved)))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Approval rule applied successfully",
+		"count":   len(approved),
+		"users":   approved,
+	})
+}
+
+// pendingMatches returns the pending human users a rule matches
+func (h *ApprovalRuleHandler) pendingMatches(rule *models.ApprovalRule) ([]models.User, error) {
+	var pending []models.User
+	if err := h.db.Preload("Identities").
+		Where("status = ? AND type = ?", "pending", models.UserTypeHuman).
+		Find(&pending).Error; err != nil {
+		return nil, err
+	}
+
+	matches := make([]models.User, 0)
+	for i := range pending {
+		if auth.ApprovalRuleMatches(rule, &pending[i]) {
+			matches = append(matches, pending[i])
+		}
+	}
+	return matches, nil
+}
+
+// loadRule loads the rule in the :id parameter and writes the error response
+// when there is none
+func (h *ApprovalRuleHandler) loadRule(c *gin.Context) (*models.ApprovalRule, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return nil, false
+	}
+
+	var rule models.ApprovalRule
+	if err := h.db.First(&rule, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Approval rule not found"})
+		return nil, false
+	}
+
+	return &rule, true
+}
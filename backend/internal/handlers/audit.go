@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/audit"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// exportBatchSize is how many entries Export loads at a time
+const exportBatchSize = 500
+
+// AuditHandler lets admins search, export and verify the audit log
+type AuditHandler struct {
+	db     *gorm.DB
+	signer audit.Signer
+}
+
+func NewAuditHandler(db *gorm.DB, signer audit.Signer) *AuditHandler {
+	return &AuditHandler{db: db, signer: signer}
+}
+
+// ListEntries returns a page of the entries matching the query filters,
+// newest first. next_cursor fetches the next page and is empty on the last.
+func (h *AuditHandler) ListEntries(c *gin.Context) {
+	filter, err := audit.ParseFilter(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var entries []models.AuditLog
+	if err := filter.Apply(h.db.Preload("User")).
+		Order("audit_logs.id DESC").
+		Limit(filter.Limit + 1).
+		Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit log"})
+		return
+	}
+
+	nextCursor := ""
+	if len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+		nextCursor = fmt.Sprint(entries[len(entries)-1].ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "next_cursor": nextCursor})
+}
+
+// Export streams every entry matching the query filters, newest first, as
+// CSV or NDJSON. limit is ignored.
+func (h *AuditHandler) Export(c *gin.Context) {
+	filter, err := audit.ParseFilter(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", audit.FormatCSV)
+	contentType := "text/csv"
+	if format == audit.FormatNDJSON {
+		contentType = "application/x-ndjson"
+	}
+
+	writer, err := audit.NewExportWriter(c.Writer, format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.%s"`, time.Now().UTC().Format("20060102T150405Z"), format))
+	c.Status(http.StatusOK)
+
+	// The status is sent with the first rows, so later failures can only end
+	// the stream early
+	for {
+		var batch []models.AuditLog
+		if err := filter.Apply(h.db.Preload("User")).
+			Order("audit_logs.id DESC").
+			Limit(exportBatchSize).
+			Find(&batch).Error; err != nil {
+			log.Printf("Failed to export audit log: %v", err)
+			return
+		}
+
+		for i := range batch {
+			if err := writer.Write(&batch[i]); err != nil {
+				log.Printf("Failed to export audit log: %v", err)
+				return
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			log.Printf("Failed to export audit log: %v", err)
+			return
+		}
+		c.Writer.Flush()
+
+		if len(batch) < exportBatchSize {
+			return
+		}
+		filter.Cursor = batch[len(batch)-1].ID
+	}
+}
+
+// Verify walks the audit chain and its checkpoints and reports the first
+// break. It reads the whole audit log.
+func (h *AuditHandler) Verify(c *gin.Context) {
+	report, err := audit.Verify(h.db, h.signer)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ListCheckpoints returns the most recent signed checkpoints, newest first
+func (h *AuditHandler) ListCheckpoints(c *gin.Context) {
+	var checkpoints []models.AuditCheckpoint
+	if err := h.db.Order("id DESC").Limit(100).Find(&checkpoints).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit checkpoints"})
+		return
+	}
+
+	c.JSON(http.StatusOK, checkpoints)
+}
+
+// recordAudit adds what the handler did to the entry the Audit middleware
+// writes for the request, so that each request has a single entry. That entry
+// is then written right away instead of queued.
+func recordAudit(c *gin.Context, action, resource string, resourceID interface{}, details string) {
+	var events []audit.Event
+	if value, ok := c.Get("audit_events"); ok {
+		events = value.([]audit.Event)
+	}
+	c.Set("audit_events", append(events, audit.Event{
+		Action:     action,
+		Resource:   resource,
+		ResourceID: fmt.Sprint(resourceID),
+		Details:    details,
+	}))
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/audit"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"github.com/mysticrenji/surfer/backend/internal/secrets"
+	"gorm.io/gorm"
+)
+
+// AuditSinkHandler lets admins configure where audit log entries are
+// forwarded
+type AuditSinkHandler struct {
+	db      *gorm.DB
+	options audit.SinkOptions
+}
+
+func NewAuditSinkHandler(db *gorm.DB, options audit.SinkOptions) *AuditSinkHandler {
+	return &AuditSinkHandler{db: db, options: options}
+}
+
+type AuditSinkRequest struct {
+	Name      string `json:"name" binding:"required"`
+	Type      string `json:"type" binding:"required"`
+	Enabled   *bool  `json:"enabled"`
+	Network   string `json:"network"`
+	Address   string `json:"address"`
+	URL       string `json:"url"`
+	Secret    string `json:"secret"`
+	Path      string `json:"path"`
+	MaxSizeMB int    `json:"max_size_mb"`
+	MaxFiles  int    `json:"max_files"`
+}
+
+// apply copies the request onto sink and validates it. A new secret is
+// encrypted, an empty one keeps the sink's secret.
+func (r *AuditSinkRequest) apply(sink *models.AuditSink, options audit.SinkOptions) error {
+	sink.Name = strings.TrimSpace(r.Name)
+	if !resourceName.MatchString(sink.Name) {
+		return errors.New("name must be lowercase letters, digits and dashes")
+	}
+
+	sink.Type = r.Type
+	sink.Enabled = r.Enabled == nil || *r.Enabled
+	sink.Network = strings.ToLower(strings.TrimSpace(r.Network))
+	sink.Address = strings.TrimSpace(r.Address)
+	sink.URL = strings.TrimSpace(r.URL)
+	if r.Secret != "" {
+		sink.Secret = r.Secret
+	}
+	sink.Path = strings.TrimSpace(r.Path)
+	sink.MaxSizeMB = r.MaxSizeMB
+	sink.MaxFiles = r.MaxFiles
+	if err := audit.ValidateSink(sink, options); err != nil {
+		return err
+	}
+
+	if r.Secret != "" {
+		encrypted, err := secrets.Encrypt(options.Keys, r.Secret)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt secret: %w", err)
+		}
+		sink.Secret = encrypted
+	}
+	return nil
+}
+
+// AuditSinkStatus is a sink with the number of entries waiting for it
+type AuditSinkStatus struct {
+	models.AuditSink
+	Pending int64 `json:"pending"`
+}
+
+// ListSinks returns the sinks with their delivery status
+func (h *AuditSinkHandler) ListSinks(c *gin.Context) {
+	var sinks []models.AuditSink
+	if err := h.db.Order("id").Find(&sinks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit sinks"})
+		return
+	}
+
+	var counts []struct {
+		SinkID uint
+		Count  int64
+	}
+	if err := h.db.Model(&models.AuditOutbox{}).Select("sink_id, count(*) AS count").Group("sink_id").Scan(&counts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit sinks"})
+		return
+	}
+	pending := make(map[uint]int64, len(counts))
+	for _, count := range counts {
+		pending[count.SinkID] = count.Count
+	}
+
+	result := make([]AuditSinkStatus, 0, len(sinks))
+	for _, sink := range sinks {
+		result = append(result, AuditSinkStatus{AuditSink: sink, Pending: pending[sink.ID]})
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// CreateSink adds a sink. It receives the entries written from now on.
+func (h *AuditSinkHandler) CreateSink(c *gin.Context) {
+	var req AuditSinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sink := &models.AuditSink{}
+	if err := req.apply(sink, h.options); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.checkName(c, sink) {
+		return
+	}
+
+	adminID, _ := c.Get("user_id")
+	sink.CreatedBy = adminID.(uint)
+
+	if err := h.db.Create(sink).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create audit sink"})
+		return
+	}
+
+	recordAudit(c, "create", "audit_sink", sink.ID, sinkDetails(sink))
+	c.JSON(http.StatusCreated, sink)
+}
+
+// UpdateSink changes a sink. Entries already queued for it are delivered with
+// the new configuration. While a sink is disabled nothing is queued for it.
+func (h *AuditSinkHandler) UpdateSink(c *gin.Context) {
+	sink, ok := h.loadSink(c)
+	if !ok {
+		return
+	}
+
+	var req AuditSinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := req.apply(sink, h.options); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.checkName(c, sink) {
+		return
+	}
+
+	if err := h.db.Save(sink).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update audit sink"})
+		return
+	}
+
+	details := sinkDetails(sink)
+	if req.Secret != "" {
+		details += " secret=changed"
+	}
+	recordAudit(c, "update", "audit_sink", sink.ID, details)
+	c.JSON(http.StatusOK, sink)
+}
+
+// DeleteSink removes a sink and drops the entries still queued for it
+func (h *AuditSinkHandler) DeleteSink(c *gin.Context) {
+	sink, ok := h.loadSink(c)
+	if !ok {
+		return
+	}
+
+	var dropped int64
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("sink_id = ?", sink.ID).Delete(&models.AuditOutbox{})
+		if result.Error != nil {
+			return result.Error
+		}
+		dropped = result.RowsAffected
+		return tx.Delete(sink).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete audit sink"})
+		return
+	}
+
+	recordAudit(c, "delete", "audit_sink", sink.ID, fmt.Sprintf("name=%s dropped=%d", sink.Name, dropped))
+	c.JSON(http.StatusOK, gin.H{"message": "Audit sink deleted successfully"})
+}
+
+// TestSink sends a synthetic entry, which is not stored in the audit log, to
+// a sink and reports whether it was accepted
+func (h *AuditSinkHandler) TestSink(c *gin.Context) {
+	config, ok := h.loadSink(c)
+	if !ok {
+		return
+	}
+
+	sink, err := audit.NewSink(config, h.options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer sink.Close()
+
+	var admin models.User
+	h.db.First(&admin, c.GetUint("user_id"))
+
+	entry := &models.AuditLog{
+		UserID:     admin.ID,
+		User:       admin,
+		ActorType:  c.GetString("user_type"),
+		Action:     "test",
+		Resource:   "audit_sink",
+		ResourceID: fmt.Sprint(config.ID),
+		Details:    "test entry, not stored in the audit log",
+		IPAddress:  c.ClientIP(),
+		Outcome:    audit.OutcomeSuccess,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := sink.Send(entry); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Sink did not accept the test entry: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Test entry delivered"})
+}
+
+// checkName writes the error response when another sink has the name
+func (h *AuditSinkHandler) checkName(c *gin.Context, sink *models.AuditSink) bool {
+	var existing int64
+	if err := h.db.Model(&models.AuditSink{}).Where("name = ? AND id <> ?", sink.Name, sink.ID).Count(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save audit sink"})
+		return false
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "An audit sink with this name already exists"})
+		return false
+	}
+	return true
+}
+
+// loadSink loads the sink in the :id parameter and writes the error response
+// when there is none
+func (h *AuditSinkHandler) loadSink(c *gin.Context) (*models.AuditSink, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sink ID"})
+		return nil, false
+	}
+
+	var sink models.AuditSink
+	if err := h.db.First(&sink, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Audit sink not found"})
+		return nil, false
+	}
+
+	return &sink, true
+}
+
+// sinkDetails describes a sink for the audit log without its secret
+func sinkDetails(sink *models.AuditSink) string {
+	target := sink.Path
+	switch sink.Type {
+	case models.AuditSinkSyslog:
+		target = sink.Network + "://" + sink.Address
+	case models.AuditSinkWebhook:
+		target = sink.URL
+	}
+	return fmt.Sprintf("name=%s type=%s target=%s enabled=%t", sink.Name, sink.Type, target, sink.Enabled)
+}
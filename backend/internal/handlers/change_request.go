@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/auth"
+	"github.com/mysticrenji/surfer/backend/internal/k8s"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// ChangeRequestHandler reviews the destructive operations on protected
+// clusters that wait for a second user
+type ChangeRequestHandler struct {
+	db          *gorm.DB
+	permissions auth.PermissionStore
+	roles       auth.RoleStore
+	k8s         *K8sHandler
+}
+
+func NewChangeRequestHandler(db *gorm.DB, permissions auth.PermissionStore, roles auth.RoleStore, k8sHandler *K8sHandler) *ChangeRequestHandler {
+	return &ChangeRequestHandler{db: db, permissions: permissions, roles: roles, k8s: k8sHandler}
+}
+
+// ListMyRequests returns the caller's change requests, newest first
+func (h *ChangeRequestHandler) ListMyRequests(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var changes []models.ChangeRequest
+	if err := h.db.Preload("Cluster").Where("user_id = ?", userID).Order("id DESC").Limit(100).Find(&changes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch change requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, changes)
+}
+
+// CancelRequest withdraws one of the caller's pending change requests
+func (h *ChangeRequestHandler) CancelRequest(c *gin.Context) {
+	change, ok := h.loadRequest(c)
+	if !ok {
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	if change.UserID != userID.(uint) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change request not found"})
+		return
+	}
+
+	if !h.end(c, change, models.ChangeRequestCancelled, nil, "") {
+		return
+	}
+
+	recordAudit(c, "cancel", "change_request", change.ID, "")
+	c.JSON(http.StatusOK, change)
+}
+
+// ListReviewable returns the pending change requests the caller may approve.
+// Namespace permissions with a label selector are not considered here.
+func (h *ChangeRequestHandler) ListReviewable(c *gin.Context) {
+	var pending []models.ChangeRequest
+	if err := h.db.Preload("User").Preload("Cluster").
+		Where("status = ? AND expires_at > ?", models.ChangeRequestPending, time.Now()).
+		Order("id").Find(&pending).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch change requests"})
+		return
+	}
+
+	reviewable := make([]models.ChangeRequest, 0, len(pending))
+	for i := range pending {
+		ok, err := h.canReview(c, &pending[i], nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check cluster access"})
+			return
+		}
+		if ok {
+			reviewable = append(reviewable, pending[i])
+		}
+	}
+
+	c.JSON(http.StatusOK, reviewable)
+}
+
+// ApproveRequest approves a pending change request and runs it on the cluster
+// as the requester. The request ends executed or failed.
+func (h *ChangeRequestHandler) ApproveRequest(c *gin.Context) {
+	change, comment, ok := h.loadForReview(c)
+	if !ok {
+		return
+	}
+
+	if change.Status != models.ChangeRequestPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Change request is " + change.Status})
+		return
+	}
+
+	now := time.Now()
+	if !now.Before(change.ExpiresAt) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Change request has expired"})
+		return
+	}
+
+	var requester models.User
+	if err := h.db.First(&requester, change.UserID).Error; err != nil || requester.Status != "approved" {
+		c.JSON(http.StatusConflict, gin.H{"error": "The requester can no longer make changes"})
+		return
+	}
+
+	client, err := h.k8s.clientFor(&change.Cluster, requester.ID, requester.Email)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get cluster client"})
+		return
+	}
+
+	// Access may have been revoked since the change was requested
+	allowed, err := h.requesterMayChange(&requester, change, client)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the requester's access"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusConflict, gin.H{"error": "The requester is no longer allowed to make this change"})
+		return
+	}
+
+	// Claim the request so that it runs once however many reviewers approve
+	reviewerID, _ := c.Get("user_id")
+	result := h.db.Model(&models.ChangeRequest{}).
+		Where("id = ? AND status = ?", change.ID, models.ChangeRequestPending).
+		Updates(map[string]interface{}{
+			"status":         models.ChangeRequestApproved,
+			"reviewed_by":    reviewerID,
+			"reviewed_at":    now,
+			"review_comment": comment,
+		})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve change request"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Change request is no longer pending"})
+		return
+	}
+
+	change.Status = models.ChangeRequestApproved
+	change.ReviewedBy = uintPtr(reviewerID.(uint))
+	change.ReviewedAt = &now
+	change.ReviewComment = comment
+	recordAudit(c, "approve", "change_request", change.ID, changeDetails(change)+" comment="+comment)
+
+	runErr := runChange(client, change)
+	status := models.ChangeRequestExecuted
+	if runErr != nil {
+		status = models.ChangeRequestFailed
+		change.Result = runErr.Error()
+	}
+
+	endedAt := time.Now()
+	if err := h.db.Model(&models.ChangeRequest{}).Where("id = ?", change.ID).
+		Updates(map[string]interface{}{"status": status, "result": change.Result, "ended_at": endedAt}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record the change request result"})
+		return
+	}
+	change.Status = status
+	change.EndedAt = &endedAt
+
+	recordAudit(c, "execute", "change_request", change.ID, fmt.Sprintf("status=%s %s", status, change.Result))
+	if runErr != nil {
+		respondK8sError(c, runErr, "Change request was approved but failed on the cluster")
+		return
+	}
+
+	c.JSON(http.StatusOK, change)
+}
+
+func (h *ChangeRequestHandler) RejectRequest(c *gin.Context) {
+	change, comment, ok := h.loadForReview(c)
+	if !ok {
+		return
+	}
+
+	if change.Status != models.ChangeRequestPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Change request is " + change.Status})
+		return
+	}
+
+	reviewerID, _ := c.Get("user_id")
+	if !h.end(c, change, models.ChangeRequestRejected, uintPtr(reviewerID.(uint)), comment) {
+		return
+	}
+
+	recordAudit(c, "reject", "change_request", change.ID, "comment="+comment)
+	c.JSON(http.StatusOK, change)
+}
+
+// end moves a pending change request to a final status and writes the error
+// response when it is no longer pending
+func (h *ChangeRequestHandler) end(c *gin.Context, change *models.ChangeRequest, status string, reviewedBy *uint, comment string) bool {
+	now := time.Now()
+	updates := map[string]interface{}{"status": status, "ended_at": now}
+	if reviewedBy != nil {
+		updates["reviewed_by"] = *reviewedBy
+		updates["reviewed_at"] = now
+	}
+	if comment != "" {
+		updates["review_comment"] = comment
+	}
+
+	result := h.db.Model(&models.ChangeRequest{}).
+		Where("id = ? AND status = ?", change.ID, models.ChangeRequestPending).
+		Updates(updates)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update change request"})
+		return false
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Change request is already " + change.Status})
+		return false
+	}
+
+	change.Status = status
+	change.EndedAt = &now
+	if reviewedBy != nil {
+		change.ReviewedBy = reviewedBy
+		change.ReviewedAt = &now
+	}
+	if comment != "" {
+		change.ReviewComment = comment
+	}
+	return true
+}
+
+// loadForReview loads the change request in the :id parameter with its
+// cluster and the review comment, and checks that the caller may review it
+func (h *ChangeRequestHandler) loadForReview(c *gin.Context) (*models.ChangeRequest, string, bool) {
+	change, ok := h.loadRequest(c)
+	if !ok {
+		return nil, "", false
+	}
+
+	var review reviewRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&review); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, "", false
+		}
+	}
+
+	if err := h.db.First(&change.Cluster, change.ClusterID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cluster not found"})
+		return nil, "", false
+	}
+
+	// Namespace labels are only needed for label selector permissions, so the
+	// client is created lazily by canReview
+	allowed, err := h.canReview(c, change, func() (*k8s.Client, error) {
+		return h.k8s.clientFor(&change.Cluster, c.GetUint("user_id"), c.GetString("user_email"))
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check cluster access"})
+		return nil, "", false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users other than the requester who could make this change themselves can review it"})
+		return nil, "", false
+	}
+
+	return change, strings.TrimSpace(review.Comment), true
+}
+
+// canReview reports whether the caller could make the change themselves: their
+// role has the action's permission and they operate the namespace through
+// standing permissions rather than access requests of their own. Nobody
+// reviews their own changes. Without newClient namespace labels are not
+// fetched and label selector permissions do not count.
+func (h *ChangeRequestHandler) canReview(c *gin.Context, change *models.ChangeRequest, newClient func() (*k8s.Client, error)) (bool, error) {
+	userID, _ := c.Get("user_id")
+	if change.UserID == userID.(uint) {
+		return false, nil
+	}
+
+	permission := auth.ChangePermission(change.Action)
+	if permission == "" || !auth.HasPermission(c, permission) {
+		return false, nil
+	}
+
+	if auth.HasPermission(c, models.PermClustersAll) {
+		return true, nil
+	}
+	return h.operatesNamespace(userID.(uint), change, true, newClient)
+}
+
+// requesterMayChange reports whether the requester's role still has the
+// action's permission and they still operate the namespace
+func (h *ChangeRequestHandler) requesterMayChange(requester *models.User, change *models.ChangeRequest, client *k8s.Client) (bool, error) {
+	permissions, err := h.roles.Permissions(requester.Role)
+	if errors.Is(err, auth.ErrRoleNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	permission := auth.ChangePermission(change.Action)
+	if permission == "" || !permissions.Has(permission) {
+		return false, nil
+	}
+	if permissions.Has(models.PermClustersAll) {
+		return true, nil
+	}
+	return h.operatesNamespace(requester.ID, change, false, func() (*k8s.Client, error) { return client, nil })
+}
+
+// operatesNamespace reports whether userID has operator level on the change's
+// namespace, counting only standing permissions when standing is set. Without
+// newClient namespace labels are not fetched and label selector permissions do
+// not count.
+func (h *ChangeRequestHandler) operatesNamespace(userID uint, change *models.ChangeRequest, standing bool, newClient func() (*k8s.Client, error)) (bool, error) {
+	access, err := h.permissions.ClusterAccess(userID, change.ClusterID)
+	if err != nil {
+		return false, err
+	}
+	if standing {
+		access = access.Standing()
+	}
+
+	var namespaceLabels map[string]string
+	if newClient != nil && access.NeedsLabels(change.Namespace, models.ClusterLevelOperator) {
+		client, err := newClient()
+		if err != nil {
+			return false, err
+		}
+		namespace, err := client.GetNamespace(change.Namespace)
+		if err != nil {
+			return false, nil
+		}
+		namespaceLabels = namespace.Labels
+	}
+
+	return models.ClusterLevelAllows(access.NamespaceLevel(change.Namespace, namespaceLabels), models.ClusterLevelOperator), nil
+}
+
+// loadRequest loads the change request in the :id parameter and writes the
+// error response when there is none
+func (h *ChangeRequestHandler) loadRequest(c *gin.Context) (*models.ChangeRequest, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid change request ID"})
+		return nil, false
+	}
+
+	var change models.ChangeRequest
+	if err := h.db.First(&change, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change request not found"})
+		return nil, false
+	}
+
+	return &change, true
+}
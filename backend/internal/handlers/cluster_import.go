@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/auth"
+	"github.com/mysticrenji/surfer/backend/internal/k8s"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"github.com/mysticrenji/surfer/backend/internal/secrets"
+	"gorm.io/gorm"
+)
+
+// maxImportedClusters caps how many clusters one import creates
+const maxImportedClusters = 50
+
+// ImportableContext is a kubeconfig context with the reason it cannot be
+// imported, if any
+type ImportableContext struct {
+	k8s.KubeconfigContext
+	Error string `json:"error,omitempty"`
+}
+
+// ListContexts lists the contexts of a kubeconfig with their servers, and
+// whether each can be imported, without storing anything
+func (h *ClusterHandler) ListContexts(c *gin.Context) {
+	var req struct {
+		KubeConfig string `json:"kubeconfig" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	parsed, err := k8s.ParseKubeconfig(req.KubeConfig)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contexts := make([]ImportableContext, 0, len(parsed.Contexts))
+	for _, context := range parsed.Contexts {
+		importable := ImportableContext{KubeconfigContext: context}
+		if _, err := parsed.Validate(context.Name); err != nil {
+			importable.Error = err.Error()
+		}
+		contexts = append(contexts, importable)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"current_context": parsed.CurrentContext, "contexts": contexts})
+}
+
+type ImportedCluster struct {
+	Context             string `json:"context" binding:"required"`
+	Name                string `json:"name"` // Defaults to the context name
+	Description         string `json:"description"`
+	Impersonate         bool   `json:"impersonate"`
+	ImpersonationPrefix string `json:"impersonation_prefix"`
+	Protected           bool   `json:"protected"`
+}
+
+// ImportClusters adds a cluster for each chosen context of a kubeconfig, all
+// or none. Each cluster stores a kubeconfig with only its own context,
+// cluster and user.
+func (h *ClusterHandler) ImportClusters(c *gin.Context) {
+	var req struct {
+		KubeConfig     string            `json:"kubeconfig" binding:"required"`
+		Clusters       []ImportedCluster `json:"clusters" binding:"required,min=1,dive"`
+		TestConnection bool              `json:"test_connection"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Clusters) > maxImportedClusters {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d clusters can be imported at once", maxImportedClusters)})
+		return
+	}
+
+	parsed, err := k8s.ParseKubeconfig(req.KubeConfig)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check every context before storing any, and report all problems at once
+	userID, _ := c.Get("user_id")
+	clusters := make([]models.Cluster, 0, len(req.Clusters))
+	problems := make(map[string]string)
+	seen := make(map[string]bool)
+	for _, imported := range req.Clusters {
+		cluster, err := h.importedCluster(parsed, &imported, req.TestConnection, seen)
+		if err != nil {
+			problems[imported.Context] = err.Error()
+			continue
+		}
+		cluster.CreatedBy = userID.(uint)
+		clusters = append(clusters, *cluster)
+	}
+	if len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Some contexts cannot be imported, nothing was imported", "contexts": problems})
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		for i := range clusters {
+			if err := createCluster(tx, &clusters[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import clusters"})
+		return
+	}
+
+	for _, cluster := range clusters {
+		recordAudit(c, "import", "cluster", cluster.ID, fmt.Sprintf("name=%s context=%s protected=%t", cluster.Name, cluster.Context, cluster.Protected))
+	}
+	c.JSON(http.StatusCreated, clusters)
+}
+
+// importedCluster builds the cluster of one imported context with its
+// minimized, encrypted kubeconfig. seen holds the contexts already imported.
+func (h *ClusterHandler) importedCluster(parsed *k8s.Kubeconfig, imported *ImportedCluster, testConnection bool, seen map[string]bool) (*models.Cluster, error) {
+	if seen[imported.Context] {
+		return nil, fmt.Errorf("context %q is listed twice", imported.Context)
+	}
+	seen[imported.Context] = true
+
+	if _, err := parsed.Validate(imported.Context); err != nil {
+		return nil, err
+	}
+	if err := auth.ValidateImpersonationPrefix(imported.ImpersonationPrefix); err != nil {
+		return nil, err
+	}
+
+	kubeconfig, err := parsed.Minify(imported.Context)
+	if err != nil {
+		return nil, err
+	}
+	if testConnection {
+		if err := h.clients.ConnectKubeconfig(kubeconfig, imported.Context); err != nil {
+			return nil, fmt.Errorf("failed to connect to cluster: %w", err)
+		}
+	}
+	encrypted, err := secrets.Encrypt(h.keys, kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt kubeconfig: %w", err)
+	}
+
+	name := strings.TrimSpace(imported.Name)
+	if name == "" {
+		name = imported.Context
+	}
+
+	return &models.Cluster{
+		Name:                name,
+		Description:         imported.Description,
+		CredentialType:      models.CredentialKubeconfig,
+		KubeConfig:          encrypted,
+		Context:             imported.Context,
+		Impersonate:         imported.Impersonate,
+		ImpersonationPrefix: imported.ImpersonationPrefix,
+		Protected:           imported.Protected,
+	}, nil
+}
@@ -1,37 +1,120 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/auth"
 	"github.com/mysticrenji/surfer/backend/internal/k8s"
 	"github.com/mysticrenji/surfer/backend/internal/models"
 	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
 type K8sHandler struct {
-	db *gorm.DB
+	db      *gorm.DB
+	clients *k8s.ClientFactory
 }
 
-func NewK8sHandler(db *gorm.DB) *K8sHandler {
-	return &K8sHandler{db: db}
+func NewK8sHandler(db *gorm.DB, clients *k8s.ClientFactory) *K8sHandler {
+	return &K8sHandler{db: db, clients: clients}
 }
 
 func (h *K8sHandler) getClusterClient(c *gin.Context) (*k8s.Client, error) {
+	_, client, err := h.getCluster(c)
+	return client, err
+}
+
+// getCluster loads the cluster in the :clusterId parameter and a client acting
+// for the caller
+func (h *K8sHandler) getCluster(c *gin.Context) (*models.Cluster, *k8s.Client, error) {
 	clusterID, err := strconv.ParseUint(c.Param("clusterId"), 10, 32)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var cluster models.Cluster
 	if err := h.db.First(&cluster, clusterID).Error; err != nil {
+		return nil, nil, err
+	}
+
+	client, err := h.clientFor(&cluster, c.GetUint("user_id"), c.GetString("user_email"))
+	return &cluster, client, err
+}
+
+// clientFor returns a client for cluster that acts as the given user when the
+// cluster has impersonation enabled
+func (h *K8sHandler) clientFor(cluster *models.Cluster, userID uint, email string) (*k8s.Client, error) {
+	client, err := h.clients.ForCluster(cluster)
+	if err != nil || !cluster.Impersonate {
+		return client, err
+	}
+
+	return h.impersonate(client, cluster, userID, email)
+}
+
+// impersonate returns a client acting as the user and their teams
+func (h *K8sHandler) impersonate(client *k8s.Client, cluster *models.Cluster, userID uint, email string) (*k8s.Client, error) {
+	var teams []string
+	if err := h.db.Model(&models.Team{}).
+		Joins("JOIN team_members ON team_members.team_id = teams.id").
+		Where("team_members.user_id = ?", userID).
+		Order("teams.name").
+		Pluck("teams.name", &teams).Error; err != nil {
 		return nil, err
 	}
 
-	return k8s.NewClient(cluster.KubeConfig, cluster.Context)
+	user, groups := auth.KubernetesIdentity(cluster.ImpersonationPrefix, email, teams)
+	return client.Impersonate(user, groups)
+}
+
+// respondK8sError writes the response for a failed cluster call. Calls the
+// cluster's own RBAC denies, such as those of impersonated users, are 403s.
+func respondK8sError(c *gin.Context, err error, message string) {
+	if apierrors.IsForbidden(err) {
+		c.JSON(http.StatusForbidden, gin.H{"error": message, "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
 }
 
+// namespaceAllowed checks the caller's level on the :namespace parameter and
+// writes the error response when it is not enough. Namespace labels are only
+// fetched when a label selector permission could make the difference.
+func (h *K8sHandler) namespaceAllowed(c *gin.Context, client *k8s.Client, level string) bool {
+	value, _ := c.Get("cluster_access")
+	access, ok := value.(*auth.ClusterAccess)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Namespace access denied"})
+		return false
+	}
+
+	namespace := c.Param("namespace")
+	var namespaceLabels map[string]string
+	if access.NeedsLabels(namespace, level) {
+		ns, err := client.GetNamespace(namespace)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Namespace access denied"})
+			return false
+		}
+		namespaceLabels = ns.Labels
+	}
+
+	if !models.ClusterLevelAllows(access.NamespaceLevel(namespace, namespaceLabels), level) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Namespace access denied, " + level + " level required"})
+		return false
+	}
+
+	return true
+}
+
+// ListNamespaces returns the namespaces the caller can view
 func (h *K8sHandler) ListNamespaces(c *gin.Context) {
 	client, err := h.getClusterClient(c)
 	if err != nil {
@@ -41,11 +124,20 @@ func (h *K8sHandler) ListNamespaces(c *gin.Context) {
 
 	namespaces, err := client.ListNamespaces()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list namespaces"})
+		respondK8sError(c, err, "Failed to list namespaces")
 		return
 	}
 
-	c.JSON(http.StatusOK, namespaces)
+	value, _ := c.Get("cluster_access")
+	access, _ := value.(*auth.ClusterAccess)
+	visible := make([]corev1.Namespace, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		if access != nil && models.ClusterLevelAllows(access.NamespaceLevel(namespace.Name, namespace.Labels), models.ClusterLevelViewer) {
+			visible = append(visible, namespace)
+		}
+	}
+
+	c.JSON(http.StatusOK, visible)
 }
 
 func (h *K8sHandler) ListPods(c *gin.Context) {
@@ -55,10 +147,14 @@ func (h *K8sHandler) ListPods(c *gin.Context) {
 		return
 	}
 
+	if !h.namespaceAllowed(c, client, models.ClusterLevelViewer) {
+		return
+	}
+
 	namespace := c.Param("namespace")
 	pods, err := client.ListPods(namespace)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list pods"})
+		respondK8sError(c, err, "Failed to list pods")
 		return
 	}
 
@@ -72,10 +168,14 @@ func (h *K8sHandler) ListDeployments(c *gin.Context) {
 		return
 	}
 
+	if !h.namespaceAllowed(c, client, models.ClusterLevelViewer) {
+		return
+	}
+
 	namespace := c.Param("namespace")
 	deployments, err := client.ListDeployments(namespace)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list deployments"})
+		respondK8sError(c, err, "Failed to list deployments")
 		return
 	}
 
@@ -89,10 +189,14 @@ func (h *K8sHandler) ListServices(c *gin.Context) {
 		return
 	}
 
+	if !h.namespaceAllowed(c, client, models.ClusterLevelViewer) {
+		return
+	}
+
 	namespace := c.Param("namespace")
 	services, err := client.ListServices(namespace)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list services"})
+		respondK8sError(c, err, "Failed to list services")
 		return
 	}
 
@@ -106,6 +210,10 @@ func (h *K8sHandler) GetPodLogs(c *gin.Context) {
 		return
 	}
 
+	if !h.namespaceAllowed(c, client, models.ClusterLevelViewer) {
+		return
+	}
+
 	namespace := c.Param("namespace")
 	podName := c.Param("pod")
 
@@ -119,27 +227,257 @@ func (h *K8sHandler) GetPodLogs(c *gin.Context) {
 
 	logs, err := client.GetPodLogs(namespace, podName, tailLines)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get pod logs"})
+		respondK8sError(c, err, "Failed to get pod logs")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"logs": logs})
 }
 
+// DeletePod deletes a pod. On protected clusters it creates a change request
+// instead.
 func (h *K8sHandler) DeletePod(c *gin.Context) {
-	client, err := h.getClusterClient(c)
+	cluster, client, err := h.getCluster(c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get cluster client"})
 		return
 	}
 
-	namespace := c.Param("namespace")
-	podName := c.Param("pod")
+	if !h.namespaceAllowed(c, client, models.ClusterLevelOperator) {
+		return
+	}
+
+	change := &models.ChangeRequest{
+		Namespace: c.Param("namespace"),
+		Action:    models.ChangeDeletePod,
+		Target:    c.Param("pod"),
+		Reason:    c.Query("reason"),
+	}
+	if h.deferChange(c, cluster, change) {
+		return
+	}
 
-	if err := client.DeletePod(namespace, podName); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete pod"})
+	if err := runChange(client, change); err != nil {
+		respondK8sError(c, err, "Failed to delete pod")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Pod deleted successfully"})
 }
+
+// ScaleDeployment sets the replicas of a deployment. On protected clusters
+// scaling to zero creates a change request instead.
+func (h *K8sHandler) ScaleDeployment(c *gin.Context) {
+	var req struct {
+		Replicas *int32 `json:"replicas" binding:"required"`
+		Reason   string `json:"reason"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if *req.Replicas < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "replicas cannot be negative"})
+		return
+	}
+
+	cluster, client, err := h.getCluster(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get cluster client"})
+		return
+	}
+
+	if !h.namespaceAllowed(c, client, models.ClusterLevelOperator) {
+		return
+	}
+
+	change := &models.ChangeRequest{
+		Namespace: c.Param("namespace"),
+		Action:    models.ChangeScaleDeployment,
+		Target:    c.Param("deployment"),
+		Replicas:  req.Replicas,
+		Reason:    strings.TrimSpace(req.Reason),
+	}
+	if h.deferChange(c, cluster, change) {
+		return
+	}
+
+	if err := runChange(client, change); err != nil {
+		respondK8sError(c, err, "Failed to scale deployment")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Deployment scaled successfully"})
+}
+
+// DeleteResource deletes a namespaced resource of any kind, given by the
+// api_version query ("v1" by default) and :kind. On protected clusters it
+// creates a change request instead.
+func (h *K8sHandler) DeleteResource(c *gin.Context) {
+	cluster, client, err := h.getCluster(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get cluster client"})
+		return
+	}
+
+	if !h.namespaceAllowed(c, client, models.ClusterLevelOperator) {
+		return
+	}
+
+	change := &models.ChangeRequest{
+		Namespace:  c.Param("namespace"),
+		Action:     models.ChangeDeleteResource,
+		APIVersion: c.DefaultQuery("api_version", "v1"),
+		Kind:       c.Param("kind"),
+		Target:     c.Param("name"),
+		Reason:     c.Query("reason"),
+	}
+	if err := client.CheckNamespaced(change.APIVersion, change.Kind); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if h.deferChange(c, cluster, change) {
+		return
+	}
+
+	if err := runChange(client, change); err != nil {
+		respondK8sError(c, err, "Failed to delete resource")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Resource deleted successfully"})
+}
+
+// ApplyResource creates or updates a namespaced object from a YAML or JSON
+// manifest with server-side apply. Secrets are refused, as change requests
+// store the manifest in plain text. On protected clusters it creates a change
+// request instead.
+func (h *K8sHandler) ApplyResource(c *gin.Context) {
+	var req struct {
+		Manifest string `json:"manifest" binding:"required"`
+		Reason   string `json:"reason"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	object, err := k8s.ParseManifest(req.Manifest)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	namespace := c.Param("namespace")
+	if object.GetNamespace() != "" && object.GetNamespace() != namespace {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "manifest namespace does not match the namespace of the request"})
+		return
+	}
+	if object.GetAPIVersion() == "v1" && object.GetKind() == "Secret" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Secrets cannot be applied"})
+		return
+	}
+	manifest, err := object.MarshalJSON()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cluster, client, err := h.getCluster(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get cluster client"})
+		return
+	}
+
+	if !h.namespaceAllowed(c, client, models.ClusterLevelOperator) {
+		return
+	}
+
+	change := &models.ChangeRequest{
+		Namespace:  namespace,
+		Action:     models.ChangeApply,
+		APIVersion: object.GetAPIVersion(),
+		Kind:       object.GetKind(),
+		Target:     object.GetName(),
+		Manifest:   string(manifest),
+		Reason:     strings.TrimSpace(req.Reason),
+	}
+	if err := client.CheckNamespaced(change.APIVersion, change.Kind); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if h.deferChange(c, cluster, change) {
+		return
+	}
+
+	if err := runChange(client, change); err != nil {
+		respondK8sError(c, err, "Failed to apply manifest")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Manifest applied successfully"})
+}
+
+// deferChange stores change as a pending change request and responds 202 when
+// the cluster needs a second user to approve it. It reports whether it handled
+// the request.
+func (h *K8sHandler) deferChange(c *gin.Context, cluster *models.Cluster, change *models.ChangeRequest) bool {
+	if !auth.ChangeNeedsApproval(cluster, change) {
+		return false
+	}
+
+	change.UserID = c.GetUint("user_id")
+	change.ClusterID = cluster.ID
+	change.Status = models.ChangeRequestPending
+	change.ExpiresAt = time.Now().Add(auth.ChangeRequestTTL)
+
+	if err := h.db.Create(change).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create change request"})
+		return true
+	}
+
+	recordAudit(c, "create", "change_request", change.ID, changeDetails(change))
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":        "The cluster is protected, another user must approve this change",
+		"change_request": change,
+	})
+	return true
+}
+
+// runChange performs the operation a change request describes
+func runChange(client *k8s.Client, change *models.ChangeRequest) error {
+	switch change.Action {
+	case models.ChangeDeletePod:
+		return client.DeletePod(change.Namespace, change.Target)
+	case models.ChangeScaleDeployment:
+		if change.Replicas == nil {
+			return errors.New("replicas missing")
+		}
+		return client.ScaleDeployment(change.Namespace, change.Target, *change.Replicas)
+	case models.ChangeDeleteResource:
+		return client.DeleteResource(change.Namespace, change.APIVersion, change.Kind, change.Target)
+	case models.ChangeApply:
+		object, err := k8s.ParseManifest(change.Manifest)
+		if err != nil {
+			return err
+		}
+		return client.ApplyResource(change.Namespace, object)
+	}
+	return fmt.Errorf("unknown action %q", change.Action)
+}
+
+func changeDetails(change *models.ChangeRequest) string {
+	details := fmt.Sprintf("cluster=%d namespace=%s action=%s target=%s", change.ClusterID, change.Namespace, change.Action, change.Target)
+	if change.Kind != "" {
+		details += fmt.Sprintf(" kind=%s api_version=%s", change.Kind, change.APIVersion)
+	}
+	if change.Replicas != nil {
+		details += fmt.Sprintf(" replicas=%d", *change.Replicas)
+	}
+	if change.Reason != "" {
+		details += " reason=" + change.Reason
+	}
+	return details
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/auth"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// RoleHandler lets admins manage the roles users are assigned
+type RoleHandler struct {
+	db *gorm.DB
+}
+
+func NewRoleHandler(db *gorm.DB) *RoleHandler {
+	return &RoleHandler{db: db}
+}
+
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+func (h *RoleHandler) ListRoles(c *gin.Context) {
+	var roles []models.Role
+	if err := h.db.Order("built_in DESC, name").Find(&roles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, roles)
+}
+
+// ListPermissions returns every permission a role can be composed of
+func (h *RoleHandler) ListPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, auth.Permissions)
+}
+
+func (h *RoleHandler) CreateRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if !resourceName.MatchString(req.Name) || req.Name == "pending" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be lowercase letters, digits and dashes"})
+		return
+	}
+
+	permissions, err := auth.NormalizePermissions(req.Permissions)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkPermissions(c, permissions) {
+		return
+	}
+
+	var existing int64
+	if err := h.db.Model(&models.Role{}).Where("name = ?", req.Name).Count(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+		return
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A role with this name already exists"})
+		return
+	}
+
+	role := models.Role{Name: req.Name, Description: req.Description, Permissions: permissions}
+	if err := h.db.Create(&role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+		return
+	}
+
+	recordAudit(c, "create", "role", role.ID, fmt.Sprintf("name=%s permissions=%s", role.Name, strings.Join(permissions, ",")))
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole replaces the description and permissions of a custom role. The
+// name cannot change because users refer to roles by name.
+func (h *RoleHandler) UpdateRole(c *gin.Context) {
+	role, ok := h.loadCustomRole(c)
+	if !ok {
+		return
+	}
+
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	permissions, err := auth.NormalizePermissions(req.Permissions)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkPermissions(c, permissions) {
+		return
+	}
+
+	role.Description = req.Description
+	role.Permissions = permissions
+	if err := h.db.Save(role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	recordAudit(c, "update", "role", role.ID, "permissions="+strings.Join(permissions, ","))
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole deletes a custom role that no user or approval rule refers to
+func (h *RoleHandler) DeleteRole(c *gin.Context) {
+	role, ok := h.loadCustomRole(c)
+	if !ok {
+		return
+	}
+
+	var users, rules int64
+	if err := h.db.Model(&models.User{}).Where("role = ?", role.Name).Count(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+		return
+	}
+	if err := h.db.Model(&models.ApprovalRule{}).Where("role = ?", role.Name).Count(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+		return
+	}
+	if users > 0 || rules > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Role is assigned to %d users and %d approval rules", users, rules)})
+		return
+	}
+
+	if err := h.db.Delete(role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+		return
+	}
+
+	recordAudit(c, "delete", "role", role.ID, "name="+role.Name)
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
+}
+
+// loadCustomRole loads the role in the :id parameter and writes the error
+// response when there is none or it is built in
+func (h *RoleHandler) loadCustomRole(c *gin.Context) (*models.Role, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return nil, false
+	}
+
+	var role models.Role
+	if err := h.db.First(&role, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return nil, false
+	}
+
+	if role.BuiltIn {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Built-in roles cannot be changed"})
+		return nil, false
+	}
+
+	return &role, true
+}
+
+// checkRole writes the error response and returns false when name is not a
+// role the caller may hand out: one with only permissions the caller has. The
+// admin role can only be handed out by admins.
+func checkRole(c *gin.Context, roles auth.RoleStore, name string) bool {
+	permissions, err := roles.Permissions(name)
+	if err != nil {
+		if errors.Is(err, auth.ErrRoleNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown role %q", name)})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check role"})
+		return false
+	}
+
+	if name == models.RoleAdmin && c.GetString("user_role") != models.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can assign the admin role"})
+		return false
+	}
+	if !auth.CanGrant(c, permissions) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Role %q has permissions you do not have", name)})
+		return false
+	}
+	return true
+}
+
+// checkPermissions writes a 403 response and returns false when permissions
+// include one the caller does not have
+func checkPermissions(c *gin.Context, permissions []string) bool {
+	if !auth.CanGrant(c, auth.NewPermissionSet(permissions)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "A role cannot have permissions you do not have"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/auth"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// serviceAccountEmailDomain gives service accounts a unique email that can
+// never be the verified email of a real login
+const serviceAccountEmailDomain = "serviceaccounts.surfer.invalid"
+
+// resourceName is the form of service account and role names
+var resourceName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ServiceAccountHandler lets admins manage non-human principals, their
+// cluster permissions and their access tokens
+type ServiceAccountHandler struct {
+	db          *gorm.DB
+	tokens      auth.AccessTokenStore
+	permissions auth.PermissionStore
+	roles       auth.RoleStore
+}
+
+func NewServiceAccountHandler(db *gorm.DB, tokens auth.AccessTokenStore, permissions auth.PermissionStore, roles auth.RoleStore) *ServiceAccountHandler {
+	return &ServiceAccountHandler{db: db, tokens: tokens, permissions: permissions, roles: roles}
+}
+
+func (h *ServiceAccountHandler) ListServiceAccounts(c *gin.Context) {
+	var accounts []models.User
+	if err := h.db.Where("type = ?", models.UserTypeServiceAccount).Order("name").Find(&accounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch service accounts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, accounts)
+}
+
+func (h *ServiceAccountHandler) CreateServiceAccount(c *gin.Context) {
+	var req struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+		Role        string `json:"role"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !resourceName.MatchString(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be lowercase letters, digits and dashes"})
+		return
+	}
+
+	if req.Role == "" {
+		req.Role = models.RoleOperator
+	}
+	if !checkRole(c, h.roles, req.Role) {
+		return
+	}
+
+	adminID, _ := c.Get("user_id")
+	createdBy := adminID.(uint)
+	now := time.Now()
+
+	// Service accounts are approved on creation and never show up as pending
+	account := models.User{
+		Email:       fmt.Sprintf("%s@%s", req.Name, serviceAccountEmailDomain),
+		Name:        req.Name,
+		Type:        models.UserTypeServiceAccount,
+		Description: req.Description,
+		Role:        req.Role,
+		Status:      "approved",
+		ApprovedBy:  &createdBy,
+		ApprovedAt:  &now,
+		CreatedBy:   &createdBy,
+	}
+
+	var existing int64
+	if err := h.db.Unscoped().Model(&models.User{}).Where("email = ?", account.Email).Count(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service account"})
+		return
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A service account with this name already exists"})
+		return
+	}
+
+	if err := h.db.Create(&account).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service account"})
+		return
+	}
+
+	recordAudit(c, "create", "service_account", account.ID, "role="+account.Role)
+	c.JSON(http.StatusCreated, account)
+}
+
+func (h *ServiceAccountHandler) UpdateServiceAccount(c *gin.Context) {
+	account, ok := h.loadServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Description *string `json:"description"`
+		Role        string  `json:"role"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := make(map[string]interface{})
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.Role != "" {
+		if !checkRole(c, h.roles, req.Role) {
+			return
+		}
+		updates["role"] = req.Role
+	}
+
+	if err := h.db.Model(account).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update service account"})
+		return
+	}
+
+	recordAudit(c, "update", "service_account", account.ID, fmt.Sprint(updates))
+	c.JSON(http.StatusOK, gin.H{"message": "Service account updated successfully"})
+}
+
+// DeleteServiceAccount deletes the account and revokes its tokens
+func (h *ServiceAccountHandler) DeleteServiceAccount(c *gin.Context) {
+	account, ok := h.loadServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.PersonalAccessToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", account.ID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", account.ID).Delete(&models.ClusterPermission{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(account).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete service account"})
+		return
+	}
+
+	recordAudit(c, "delete", "service_account", account.ID, "")
+	c.JSON(http.StatusOK, gin.H{"message": "Service account deleted successfully"})
+}
+
+func (h *ServiceAccountHandler) ListClusterPermissions(c *gin.Context) {
+	account, ok := h.loadServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	permissions, err := h.permissions.List(account.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cluster permissions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, permissions)
+}
+
+func (h *ServiceAccountHandler) GrantClusterPermission(c *gin.Context) {
+	account, ok := h.loadServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	clusterID, err := strconv.ParseUint(c.Param("clusterId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cluster ID"})
+		return
+	}
+
+	var req struct {
+		Level string `json:"level" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !models.ValidClusterLevel(req.Level) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid level. Must be 'viewer', 'operator' or 'admin'"})
+		return
+	}
+
+	var cluster models.Cluster
+	if err := h.db.First(&cluster, clusterID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cluster not found"})
+		return
+	}
+
+	adminID, _ := c.Get("user_id")
+	permission := models.ClusterPermission{
+		ClusterID: cluster.ID,
+		UserID:    account.ID,
+		Level:     req.Level,
+		GrantedBy: adminID.(uint),
+	}
+
+	if err := h.permissions.Grant(&permission); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to grant cluster permission"})
+		return
+	}
+
+	recordAudit(c, "grant", "service_account", account.ID, fmt.Sprintf("cluster=%d level=%s", cluster.ID, req.Level))
+	c.JSON(http.StatusOK, permission)
+}
+
+func (h *ServiceAccountHandler) RevokeClusterPermission(c *gin.Context) {
+	account, ok := h.loadServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	clusterID, err := strconv.ParseUint(c.Param("clusterId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cluster ID"})
+		return
+	}
+
+	if err := h.permissions.Revoke(account.ID, uint(clusterID)); err != nil {
+		if errors.Is(err, auth.ErrPermissionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Cluster permission not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke cluster permission"})
+		return
+	}
+
+	recordAudit(c, "revoke", "service_account", account.ID, fmt.Sprintf("cluster=%d", clusterID))
+	c.JSON(http.StatusOK, gin.H{"message": "Cluster permission revoked successfully"})
+}
+
+func (h *ServiceAccountHandler) ListTokens(c *gin.Context) {
+	account, ok := h.loadServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.tokens.List(account.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch access tokens"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, tokenResponse(&token))
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// CreateToken mints an access token for the service account. Like personal
+// tokens it is only shown in this response.
+func (h *ServiceAccountHandler) CreateToken(c *gin.Context) {
+	account, ok := h.loadServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	permissions, err := h.roles.Permissions(account.Role)
+	if err != nil && !errors.Is(err, auth.ErrRoleNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load role permissions"})
+		return
+	}
+
+	if token := issueToken(c, h.tokens, account.ID, permissions); token != nil {
+		recordAudit(c, "create_token", "service_account", account.ID, fmt.Sprintf("token=%d scopes=%s", token.ID, token.Scopes))
+	}
+}
+
+func (h *ServiceAccountHandler) RevokeToken(c *gin.Context) {
+	account, ok := h.loadServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	tokenID, err := strconv.ParseUint(c.Param("tokenId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	if err := h.tokens.Revoke(account.ID, uint(tokenID)); err != nil {
+		if errors.Is(err, auth.ErrAccessTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Access token not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke access token"})
+		return
+	}
+
+	recordAudit(c, "revoke_token", "service_account", account.ID, fmt.Sprintf("token=%d", tokenID))
+	c.JSON(http.StatusOK, gin.H{"message": "Access token revoked successfully"})
+}
+
+// loadServiceAccount loads the service account in the :id parameter and
+// writes the error response when there is none
+func (h *ServiceAccountHandler) loadServiceAccount(c *gin.Context) (*models.User, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service account ID"})
+		return nil, false
+	}
+
+	var account models.User
+	if err := h.db.Where("type = ?", models.UserTypeServiceAccount).First(&account, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service account not found"})
+		return nil, false
+	}
+
+	return &account, true
+}
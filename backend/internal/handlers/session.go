@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/auth"
+)
+
+type SessionHandler struct {
+	sessions auth.SessionStore
+	tokens   auth.AccessTokenStore
+}
+
+func NewSessionHandler(sessions auth.SessionStore, tokens auth.AccessTokenStore) *SessionHandler {
+	return &SessionHandler{sessions: sessions, tokens: tokens}
+}
+
+func (h *SessionHandler) ListSessions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	currentID, _ := c.Get("session_id")
+
+	sessions, err := h.sessions.ListActive(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, gin.H{
+			"id":         session.ID,
+			"ip_address": session.IPAddress,
+			"user_agent": session.UserAgent,
+			"created_at": session.CreatedAt,
+			"expires_at": session.ExpiresAt,
+			"current":    session.ID == currentID,
+		})
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *SessionHandler) RevokeSession(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	if err := h.sessions.Revoke(userID.(uint), uint(id)); err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
+
+func (h *SessionHandler) RevokeAllSessions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	revoked, err := h.sessions.RevokeAll(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Sessions revoked successfully",
+		"revoked": revoked,
+	})
+}
+
+// ForceLogout revokes every session and personal access token of another
+// user (admin only)
+func (h *SessionHandler) ForceLogout(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	revoked, err := h.sessions.RevokeAll(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	revokedTokens, err := h.tokens.RevokeAll(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke access tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "User logged out successfully",
+		"revoked":        revoked,
+		"revoked_tokens": revokedTokens,
+	})
+}
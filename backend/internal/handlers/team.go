@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/auth"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TeamHandler lets admins manage teams and their members. Clusters are
+// granted to teams through the cluster access endpoints.
+type TeamHandler struct {
+	db          *gorm.DB
+	permissions auth.PermissionStore
+}
+
+func NewTeamHandler(db *gorm.DB, permissions auth.PermissionStore) *TeamHandler {
+	return &TeamHandler{db: db, permissions: permissions}
+}
+
+type TeamRequest struct {
+	Name         string `json:"name" binding:"required"`
+	Description  string `json:"description"`
+	SyncGroup    string `json:"sync_group"`
+	SyncProvider string `json:"sync_provider"`
+}
+
+func (r *TeamRequest) team() (*models.Team, error) {
+	team := &models.Team{
+		Name:         strings.TrimSpace(r.Name),
+		Description:  r.Description,
+		SyncGroup:    strings.TrimSpace(r.SyncGroup),
+		SyncProvider: strings.ToLower(strings.TrimSpace(r.SyncProvider)),
+	}
+	if !resourceName.MatchString(team.Name) {
+		return nil, errors.New("name must be lowercase letters, digits and dashes")
+	}
+	if team.SyncProvider != "" && team.SyncGroup == "" {
+		return nil, errors.New("sync_provider only applies with sync_group")
+	}
+	return team, nil
+}
+
+func (h *TeamHandler) ListTeams(c *gin.Context) {
+	var teams []models.Team
+	if err := h.db.Order("name").Find(&teams).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch teams"})
+		return
+	}
+
+	c.JSON(http.StatusOK, teams)
+}
+
+// GetTeam returns a team with its members
+func (h *TeamHandler) GetTeam(c *gin.Context) {
+	team, ok := h.loadTeam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Preload("User").Where("team_id = ?", team.ID).Order("id").Find(&team.Members).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch team members"})
+		return
+	}
+
+	c.JSON(http.StatusOK, team)
+}
+
+func (h *TeamHandler) CreateTeam(c *gin.Context) {
+	var req TeamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	team, err := req.team()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing int64
+	if err := h.db.Model(&models.Team{}).Where("name = ?", team.Name).Count(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create team"})
+		return
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A team with this name already exists"})
+		return
+	}
+
+	adminID, _ := c.Get("user_id")
+	team.CreatedBy = adminID.(uint)
+
+	if err := h.db.Create(team).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create team"})
+		return
+	}
+
+	recordAudit(c, "create", "team", team.ID, fmt.Sprintf("name=%s sync_group=%s", team.Name, team.SyncGroup))
+	c.JSON(http.StatusCreated, team)
+}
+
+// UpdateTeam replaces a team's settings. Changing the sync group removes the
+// members the old group brought in; the new group's members join at their
+// next login.
+func (h *TeamHandler) UpdateTeam(c *gin.Context) {
+	existing, ok := h.loadTeam(c)
+	if !ok {
+		return
+	}
+
+	var req TeamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	team, err := req.team()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	team.ID = existing.ID
+	team.CreatedBy = existing.CreatedBy
+	team.CreatedAt = existing.CreatedAt
+	syncChanged := team.SyncGroup != existing.SyncGroup || team.SyncProvider != existing.SyncProvider
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if syncChanged {
+			if err := tx.Where("team_id = ? AND source = ?", team.ID, models.TeamMemberSync).
+				Delete(&models.TeamMember{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Save(team).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update team"})
+		return
+	}
+
+	recordAudit(c, "update", "team", team.ID, fmt.Sprintf("name=%s sync_group=%s", team.Name, team.SyncGroup))
+	c.JSON(http.StatusOK, team)
+}
+
+// DeleteTeam deletes a team with its memberships and cluster permissions
+func (h *TeamHandler) DeleteTeam(c *gin.Context) {
+	team, ok := h.loadTeam(c)
+	if !ok {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("team_id = ?", team.ID).Delete(&models.TeamMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("team_id = ?", team.ID).Delete(&models.ClusterPermission{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(team).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete team"})
+		return
+	}
+
+	recordAudit(c, "delete", "team", team.ID, "name="+team.Name)
+	c.JSON(http.StatusOK, gin.H{"message": "Team deleted successfully"})
+}
+
+// AddMember adds a user to a team by hand. A member added by sync becomes a
+// manual member and stays when they lose the group.
+func (h *TeamHandler) AddMember(c *gin.Context) {
+	team, ok := h.loadTeam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		UserID uint `json:"user_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, req.UserID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	adminID, _ := c.Get("user_id")
+	member := models.TeamMember{
+		TeamID:  team.ID,
+		UserID:  user.ID,
+		Source:  models.TeamMemberManual,
+		AddedBy: adminID.(uint),
+	}
+
+	if err := h.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "team_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"source", "added_by"}),
+	}).Create(&member).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add team member"})
+		return
+	}
+
+	recordAudit(c, "add_member", "team", team.ID, fmt.Sprintf("user=%d", user.ID))
+	c.JSON(http.StatusOK, member)
+}
+
+// RemoveMember removes a user from a team. Members of the sync group join
+// again at their next login.
+func (h *TeamHandler) RemoveMember(c *gin.Context) {
+	team, ok := h.loadTeam(c)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	result := h.db.Where("team_id = ? AND user_id = ?", team.ID, userID).Delete(&models.TeamMember{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove team member"})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Team member not found"})
+		return
+	}
+
+	recordAudit(c, "remove_member", "team", team.ID, fmt.Sprintf("user=%d", userID))
+	c.JSON(http.StatusOK, gin.H{"message": "Team member removed successfully"})
+}
+
+// ListClusterPermissions returns the clusters and namespaces granted to a team
+func (h *TeamHandler) ListClusterPermissions(c *gin.Context) {
+	team, ok := h.loadTeam(c)
+	if !ok {
+		return
+	}
+
+	permissions, err := h.permissions.ListForTeam(team.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cluster permissions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, permissions)
+}
+
+// loadTeam loads the team in the :id parameter and writes the error response
+// when there is none
+func (h *TeamHandler) loadTeam(c *gin.Context) (*models.Team, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid team ID"})
+		return nil, false
+	}
+
+	var team models.Team
+	if err := h.db.First(&team, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Team not found"})
+		return nil, false
+	}
+
+	return &team, true
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/auth"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+// defaultTokenExpiryDays is used when a token is created without expires_in_days
+const defaultTokenExpiryDays = 30
+
+type TokenHandler struct {
+	tokens auth.AccessTokenStore
+}
+
+func NewTokenHandler(tokens auth.AccessTokenStore) *TokenHandler {
+	return &TokenHandler{tokens: tokens}
+}
+
+type CreateTokenRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+func (h *TokenHandler) ListTokens(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	tokens, err := h.tokens.List(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch access tokens"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, tokenResponse(&token))
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// CreateToken issues a personal access token. The token itself is only
+// part of this response and cannot be retrieved later.
+func (h *TokenHandler) CreateToken(c *gin.Context) {
+	// A leaked token must not be able to mint more tokens
+	if method, _ := c.Get("auth_method"); method == "token" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access tokens cannot be created with an access token"})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	permissions, _ := c.Get("permissions")
+
+	issueToken(c, h.tokens, userID.(uint), permissions.(auth.PermissionSet))
+}
+
+func (h *TokenHandler) RevokeToken(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	if err := h.tokens.Revoke(userID.(uint), uint(id)); err != nil {
+		if errors.Is(err, auth.ErrAccessTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Access token not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke access token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Access token revoked successfully"})
+}
+
+// issueToken creates an access token for ownerID, whose role has permissions,
+// from a CreateTokenRequest and writes the response containing the raw token
+func issueToken(c *gin.Context, tokens auth.AccessTokenStore, ownerID uint, permissions auth.PermissionSet) *models.PersonalAccessToken {
+	var req CreateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil
+	}
+
+	scopes, err := auth.NormalizeScopes(req.Scopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil
+	}
+
+	if auth.HasScope(scopes, auth.ScopeAdmin) && !permissions.HasAdmin() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only roles with admin permissions can have tokens with the admin scope"})
+		return nil
+	}
+
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultTokenExpiryDays
+	}
+	ttl := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	if req.ExpiresInDays < 0 || ttl > auth.MaxAccessTokenTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_days must be between 1 and 365"})
+		return nil
+	}
+
+	raw, hash, err := auth.NewAccessToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate access token"})
+		return nil
+	}
+
+	token := models.PersonalAccessToken{
+		UserID:    ownerID,
+		Name:      strings.TrimSpace(req.Name),
+		TokenHash: hash,
+		Prefix:    raw[:len(auth.AccessTokenPrefix)+4],
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	if err := tokens.Create(&token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create access token"})
+		return nil
+	}
+
+	response := tokenResponse(&token)
+	response["token"] = raw
+	c.JSON(http.StatusCreated, response)
+	return &token
+}
+
+func tokenResponse(token *models.PersonalAccessToken) gin.H {
+	return gin.H{
+		"id":           token.ID,
+		"name":         token.Name,
+		"prefix":       token.Prefix,
+		"scopes":       strings.Split(token.Scopes, ","),
+		"expires_at":   token.ExpiresAt,
+		"last_used_at": token.LastUsedAt,
+		"last_used_ip": token.LastUsedIP,
+		"created_at":   token.CreatedAt,
+	}
+}
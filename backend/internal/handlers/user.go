@@ -1,20 +1,24 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/auth"
 	"github.com/mysticrenji/surfer/backend/internal/models"
 	"gorm.io/gorm"
 )
 
 type UserHandler struct {
-	db *gorm.DB
+	db    *gorm.DB
+	roles auth.RoleStore
 }
 
-func NewUserHandler(db *gorm.DB) *UserHandler {
-	return &UserHandler{db: db}
+func NewUserHandler(db *gorm.DB, roles auth.RoleStore) *UserHandler {
+	return &UserHandler{db: db, roles: roles}
 }
 
 func (h *UserHandler) GetCurrentUser(c *gin.Context) {
@@ -33,6 +37,17 @@ func (h *UserHandler) GetCurrentUser(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
+// GetMyPermissions returns the permissions the caller can use with the
+// current credential, so that clients can hide what they cannot do
+func (h *UserHandler) GetMyPermissions(c *gin.Context) {
+	role, _ := c.Get("user_role")
+
+	c.JSON(http.StatusOK, gin.H{
+		"role":        role,
+		"permissions": auth.EffectivePermissions(c),
+	})
+}
+
 func (h *UserHandler) ListUsers(c *gin.Context) {
 	var users []models.User
 	if err := h.db.Find(&users).Error; err != nil {
@@ -43,9 +58,11 @@ func (h *UserHandler) ListUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, users)
 }
 
+// GetPendingUsers lists humans waiting for approval. Service accounts are
+// approved when an admin creates them and are never pending.
 func (h *UserHandler) GetPendingUsers(c *gin.Context) {
 	var users []models.User
-	if err := h.db.Where("status = ?", "pending").Find(&users).Error; err != nil {
+	if err := h.db.Where("status = ? AND type = ?", "pending", models.UserTypeHuman).Find(&users).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pending users"})
 		return
 	}
@@ -53,6 +70,8 @@ func (h *UserHandler) GetPendingUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, users)
 }
 
+// ApproveUser approves a pending human with the operator role, which admins
+// can change afterwards
 func (h *UserHandler) ApproveUser(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
@@ -64,10 +83,10 @@ func (h *UserHandler) ApproveUser(c *gin.Context) {
 	approvedAt := gorm.Expr("NOW()")
 
 	result := h.db.Model(&models.User{}).
-		Where("id = ?", id).
+		Where("id = ? AND type = ?", id, models.UserTypeHuman).
 		Updates(map[string]interface{}{
 			"status":      "approved",
-			"role":        "user",
+			"role":        models.RoleOperator,
 			"approved_by": adminID,
 			"approved_at": approvedAt,
 		})
@@ -106,6 +125,14 @@ func (h *UserHandler) RejectUser(c *gin.Context) {
 		return
 	}
 
+	// A rejected user must not keep using tokens issued while approved
+	if err := h.db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke user sessions"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "User rejected successfully"})
 }
 
@@ -125,25 +152,32 @@ func (h *UserHandler) UpdateUserRole(c *gin.Context) {
 		return
 	}
 
-	// Validate role
-	if req.Role != "user" && req.Role != "admin" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role. Must be 'user' or 'admin'"})
+	if uint(id) == c.GetUint("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot change your own role"})
 		return
 	}
 
-	result := h.db.Model(&models.User{}).
-		Where("id = ?", id).
-		Update("role", req.Role)
+	var user models.User
+	if err := h.db.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
 
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
+	if !checkRole(c, h.roles, req.Role) {
+		return
+	}
+	// Nor can user managers demote someone with more permissions than them
+	current, err := h.roles.Permissions(user.Role)
+	if err == nil && (user.Role == models.RoleAdmin && c.GetString("user_role") != models.RoleAdmin || !auth.CanGrant(c, current)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot change the role of a user with permissions you do not have"})
 		return
 	}
 
-	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	if err := h.db.Model(&user).Update("role", req.Role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
 		return
 	}
 
+	recordAudit(c, "set_role", "user", user.ID, fmt.Sprintf("old_role=%s new_role=%s", user.Role, req.Role))
 	c.JSON(http.StatusOK, gin.H{"message": "User role updated successfully"})
 }
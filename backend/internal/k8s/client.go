@@ -6,31 +6,80 @@ import (
 	"io"
 
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/restmapper"
 )
 
 type Client struct {
 	clientset *kubernetes.Clientset
-	config    *rest.Config
+	dynamic   dynamic.Interface
+	// mapper resolves kinds to resources through discovery. Impersonating
+	// clients share it with the client they derive from.
+	mapper meta.RESTMapper
+	config *rest.Config
 }
 
+// NewClient returns a client for the named context of kubeconfig, or for its
+// current context when context is ""
 func NewClient(kubeconfig, context string) (*Client, error) {
-	config, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfig))
+	config, err := restConfig(kubeconfig, context)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create config: %w", err)
 	}
 
+	return NewClientForConfig(config)
+}
+
+// NewClientForConfig returns a client for a REST config
+func NewClientForConfig(config *rest.Config) (*Client, error) {
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create clientset: %w", err)
 	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
 
 	return &Client{
 		clientset: clientset,
+		dynamic:   dynamicClient,
+		mapper:    restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery())),
+		config:    config,
+	}, nil
+}
+
+// Impersonate returns a client that acts as user with groups through the
+// Impersonate-User and Impersonate-Group headers, so that the cluster applies
+// its own RBAC and audits the real actor. The stored identity must be allowed
+// to impersonate users and groups.
+func (c *Client) Impersonate(user string, groups []string) (*Client, error) {
+	config := rest.CopyConfig(c.config)
+	config.Impersonate = rest.ImpersonationConfig{
+		UserName: user,
+		Groups:   groups,
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create impersonating clientset: %w", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create impersonating dynamic client: %w", err)
+	}
+
+	return &Client{
+		clientset: clientset,
+		dynamic:   dynamicClient,
+		mapper:    c.mapper,
 		config:    config,
 	}, nil
 }
@@ -51,6 +100,10 @@ func (c *Client) ListNamespaces() ([]corev1.Namespace, error) {
 	return namespaces.Items, nil
 }
 
+func (c *Client) GetNamespace(name string) (*corev1.Namespace, error) {
+	return c.clientset.CoreV1().Namespaces().Get(context.Background(), name, metav1.GetOptions{})
+}
+
 func (c *Client) ListPods(namespace string) ([]corev1.Pod, error) {
 	pods, err := c.clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
 	if err != nil {
@@ -104,3 +157,12 @@ func (c *Client) DeletePod(namespace, podName string) error {
 		metav1.DeleteOptions{},
 	)
 }
+
+func (c *Client) ScaleDeployment(namespace, name string, replicas int32) error {
+	scale := &autoscalingv1.Scale{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       autoscalingv1.ScaleSpec{Replicas: replicas},
+	}
+	_, err := c.clientset.AppsV1().Deployments(namespace).UpdateScale(context.Background(), name, scale, metav1.UpdateOptions{})
+	return err
+}
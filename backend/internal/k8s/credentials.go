@@ -0,0 +1,71 @@
+package k8s
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"k8s.io/client-go/rest"
+)
+
+// Credentials are the secret part of structured cluster credentials. They are
+// stored encrypted in Cluster.Credentials.
+type Credentials struct {
+	Token             string `json:"token,omitempty"`              // token
+	ClientCertificate string `json:"client_certificate,omitempty"` // client_certificate, PEM
+	ClientKey         string `json:"client_key,omitempty"`         // client_certificate, PEM
+}
+
+// inClusterConfig is rest.InClusterConfig, replaced in tests
+var inClusterConfig = rest.InClusterConfig
+
+// StructuredConfig validates structured credentials of the given type and
+// builds their REST config. caData is a PEM bundle; without it the system
+// roots are trusted.
+func StructuredConfig(credentialType, server, caData string, credentials *Credentials) (*rest.Config, error) {
+	if credentialType == models.CredentialInCluster {
+		if server != "" || caData != "" || *credentials != (Credentials{}) {
+			return nil, errors.New("in_cluster credentials take no server, CA or secrets, they come from the service account Surfer runs as")
+		}
+		config, err := inClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("surfer is not running in a cluster: %w", err)
+		}
+		return config, nil
+	}
+
+	target, err := url.Parse(server)
+	if err != nil || target.Scheme != "https" || target.Host == "" {
+		return nil, errors.New("server must be an https URL")
+	}
+	if caData != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(caData)) {
+		return nil, errors.New("ca_data must be PEM encoded certificates")
+	}
+
+	config := &rest.Config{Host: server, TLSClientConfig: rest.TLSClientConfig{CAData: []byte(caData)}}
+	switch credentialType {
+	case models.CredentialToken:
+		if credentials.Token == "" {
+			return nil, errors.New("token is required")
+		}
+		if credentials.ClientCertificate != "" || credentials.ClientKey != "" {
+			return nil, errors.New("client_certificate and client_key do not apply to token credentials")
+		}
+		config.BearerToken = credentials.Token
+	case models.CredentialClientCertificate:
+		if credentials.Token != "" {
+			return nil, errors.New("token does not apply to client_certificate credentials")
+		}
+		if _, err := tls.X509KeyPair([]byte(credentials.ClientCertificate), []byte(credentials.ClientKey)); err != nil {
+			return nil, fmt.Errorf("client_certificate and client_key must be a matching PEM certificate and key: %w", err)
+		}
+		config.CertData = []byte(credentials.ClientCertificate)
+		config.KeyData = []byte(credentials.ClientKey)
+	default:
+		return nil, fmt.Errorf("unknown credential type %q", credentialType)
+	}
+	return config, nil
+}
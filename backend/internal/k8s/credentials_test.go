@@ -0,0 +1,106 @@
+package k8s
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"k8s.io/client-go/rest"
+)
+
+// testCertificate returns a self-signed PEM certificate and its key
+func testCertificate(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "surfer"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestStructuredConfig(t *testing.T) {
+	cert, key := testCertificate(t)
+	otherCert, _ := testCertificate(t)
+	server := "https://10.0.0.1:6443"
+
+	tests := []struct {
+		name           string
+		credentialType string
+		server         string
+		caData         string
+		credentials    Credentials
+		wantErr        bool
+	}{
+		{"token", models.CredentialToken, server, cert, Credentials{Token: "abc"}, false},
+		{"token with system roots", models.CredentialToken, server, "", Credentials{Token: "abc"}, false},
+		{"token missing", models.CredentialToken, server, cert, Credentials{}, true},
+		{"token with certificate", models.CredentialToken, server, cert, Credentials{Token: "abc", ClientCertificate: cert}, true},
+		{"http server", models.CredentialToken, "http://10.0.0.1:6443", "", Credentials{Token: "abc"}, true},
+		{"invalid CA", models.CredentialToken, server, "not a certificate", Credentials{Token: "abc"}, true},
+		{"client certificate", models.CredentialClientCertificate, server, cert, Credentials{ClientCertificate: cert, ClientKey: key}, false},
+		{"mismatched key", models.CredentialClientCertificate, server, cert, Credentials{ClientCertificate: otherCert, ClientKey: key}, true},
+		{"certificate with token", models.CredentialClientCertificate, server, cert, Credentials{ClientCertificate: cert, ClientKey: key, Token: "abc"}, true},
+		{"unknown type", "password", server, "", Credentials{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := StructuredConfig(tt.credentialType, tt.server, tt.caData, &tt.credentials)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("StructuredConfig() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("StructuredConfig() error = %v", err)
+			}
+			if config.Host != tt.server || string(config.CAData) != tt.caData ||
+				config.BearerToken != tt.credentials.Token || string(config.KeyData) != tt.credentials.ClientKey {
+				t.Errorf("StructuredConfig() = %+v, want the given server and credentials", config)
+			}
+		})
+	}
+}
+
+func TestStructuredConfigInCluster(t *testing.T) {
+	defer func(original func() (*rest.Config, error)) { inClusterConfig = original }(inClusterConfig)
+
+	inClusterConfig = func() (*rest.Config, error) { return nil, rest.ErrNotInCluster }
+	if _, err := StructuredConfig(models.CredentialInCluster, "", "", &Credentials{}); !errors.Is(err, rest.ErrNotInCluster) {
+		t.Errorf("StructuredConfig() outside a cluster error = %v, want ErrNotInCluster", err)
+	}
+
+	inClusterConfig = func() (*rest.Config, error) { return &rest.Config{Host: "https://10.96.0.1:443"}, nil }
+	config, err := StructuredConfig(models.CredentialInCluster, "", "", &Credentials{})
+	if err != nil || config.Host != "https://10.96.0.1:443" {
+		t.Errorf("StructuredConfig() = %v, %v, want the in-cluster config", config, err)
+	}
+
+	if _, err := StructuredConfig(models.CredentialInCluster, "https://10.0.0.1", "", &Credentials{Token: "abc"}); err == nil {
+		t.Error("StructuredConfig() accepted in_cluster credentials with a server and token")
+	}
+}
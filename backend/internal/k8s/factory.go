@@ -0,0 +1,212 @@
+package k8s
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"github.com/mysticrenji/surfer/backend/internal/secrets"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// clientIdleTimeout is how long a cached client stays unused before it is
+// dropped, such as that of a cluster another replica deleted
+const clientIdleTimeout = 30 * time.Minute
+
+// ClientOptions limit the calls surfer makes to a cluster
+type ClientOptions struct {
+	QPS     float32       // Sustained calls per second to one cluster
+	Burst   int           // Calls allowed above QPS in a burst
+	Timeout time.Duration // Limit of a single call, 0 for none
+}
+
+// DefaultClientOptions are used for the options LoadClientOptions finds unset
+var DefaultClientOptions = ClientOptions{QPS: 50, Burst: 100, Timeout: 30 * time.Second}
+
+// LoadClientOptions reads the client options from K8S_CLIENT_QPS,
+// K8S_CLIENT_BURST and K8S_REQUEST_TIMEOUT, such as "30s"
+func LoadClientOptions() (ClientOptions, error) {
+	options := DefaultClientOptions
+	if raw := os.Getenv("K8S_CLIENT_QPS"); raw != "" {
+		qps, err := strconv.ParseFloat(raw, 32)
+		if err != nil || qps <= 0 {
+			return options, fmt.Errorf("K8S_CLIENT_QPS must be a positive number")
+		}
+		options.QPS = float32(qps)
+	}
+	if raw := os.Getenv("K8S_CLIENT_BURST"); raw != "" {
+		burst, err := strconv.Atoi(raw)
+		if err != nil || burst <= 0 {
+			return options, fmt.Errorf("K8S_CLIENT_BURST must be a positive integer")
+		}
+		options.Burst = burst
+	}
+	if raw := os.Getenv("K8S_REQUEST_TIMEOUT"); raw != "" {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil || timeout < 0 {
+			return options, fmt.Errorf("K8S_REQUEST_TIMEOUT must be a duration, such as 30s")
+		}
+		options.Timeout = timeout
+	}
+	return options, nil
+}
+
+// apply returns a copy of config with the options. Clients derived from it,
+// such as impersonating ones, share its rate limiter.
+func (o ClientOptions) apply(config *rest.Config) *rest.Config {
+	config = rest.CopyConfig(config)
+	config.QPS = o.QPS
+	config.Burst = o.Burst
+	config.Timeout = o.Timeout
+	config.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(o.QPS, o.Burst)
+	return config
+}
+
+// cachedClient is the client of a stored cluster for one version of its
+// credentials
+type cachedClient struct {
+	version  string
+	client   *Client
+	lastUsed time.Time
+}
+
+// ClientFactory creates clients for stored clusters, decrypting their
+// kubeconfigs. Clients are cached by cluster ID and reused until the
+// cluster's credentials change, so that calls share connections.
+type ClientFactory struct {
+	keys    secrets.KeyProvider
+	options ClientOptions
+
+	mu    sync.Mutex
+	cache map[uint]*cachedClient
+}
+
+func NewClientFactory(keys secrets.KeyProvider, options ClientOptions) *ClientFactory {
+	return &ClientFactory{keys: keys, options: options, cache: make(map[uint]*cachedClient)}
+}
+
+// ForCluster returns a client for cluster with its stored identity
+func (f *ClientFactory) ForCluster(cluster *models.Cluster) (*Client, error) {
+	version := configVersion(cluster)
+	now := time.Now()
+
+	f.mu.Lock()
+	if entry, ok := f.cache[cluster.ID]; ok && entry.version == version {
+		entry.lastUsed = now
+		f.mu.Unlock()
+		return entry.client, nil
+	}
+	f.mu.Unlock()
+
+	client, err := f.build(cluster)
+	if err != nil || cluster.ID == 0 {
+		return client, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, entry := range f.cache {
+		if now.Sub(entry.lastUsed) > clientIdleTimeout {
+			delete(f.cache, id)
+		}
+	}
+	f.cache[cluster.ID] = &cachedClient{version: version, client: client, lastUsed: now}
+	return client, nil
+}
+
+// Invalidate drops the cached client of a cluster, after its credentials
+// were changed or it was deleted
+func (f *ClientFactory) Invalidate(clusterID uint) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.cache, clusterID)
+}
+
+// build creates a client for cluster without the cache
+func (f *ClientFactory) build(cluster *models.Cluster) (*Client, error) {
+	var config *rest.Config
+	if cluster.CredentialType == "" || cluster.CredentialType == models.CredentialKubeconfig {
+		kubeconfig, err := secrets.Decrypt(f.keys, cluster.KubeConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt kubeconfig: %w", err)
+		}
+		if config, err = restConfig(kubeconfig, cluster.Context); err != nil {
+			return nil, fmt.Errorf("failed to create config: %w", err)
+		}
+	} else {
+		credentials, err := f.Credentials(cluster)
+		if err != nil {
+			return nil, err
+		}
+		if config, err = StructuredConfig(cluster.CredentialType, cluster.Server, cluster.CAData, credentials); err != nil {
+			return nil, fmt.Errorf("failed to create config: %w", err)
+		}
+	}
+	return NewClientForConfig(f.options.apply(config))
+}
+
+// configVersion identifies the stored credentials of cluster. It changes when
+// they are updated or re-encrypted.
+func configVersion(cluster *models.Cluster) string {
+	data, _ := json.Marshal([]string{cluster.CredentialType, cluster.KubeConfig, cluster.Context, cluster.Server, cluster.CAData, cluster.Credentials})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Connect checks that a cluster can be reached with config, before its
+// credentials are stored
+func (f *ClientFactory) Connect(config *rest.Config) error {
+	client, err := NewClientForConfig(f.options.apply(config))
+	if err != nil {
+		return err
+	}
+	_, err = client.GetVersion()
+	return err
+}
+
+// ConnectKubeconfig checks that the cluster of a kubeconfig context can be
+// reached, the current context for ""
+func (f *ClientFactory) ConnectKubeconfig(kubeconfig, context string) error {
+	config, err := restConfig(kubeconfig, context)
+	if err != nil {
+		return fmt.Errorf("failed to create config: %w", err)
+	}
+	return f.Connect(config)
+}
+
+// Credentials decrypts the structured credentials of cluster
+func (f *ClientFactory) Credentials(cluster *models.Cluster) (*Credentials, error) {
+	credentials := &Credentials{}
+	if cluster.Credentials == "" {
+		return credentials, nil
+	}
+
+	decrypted, err := secrets.Decrypt(f.keys, cluster.Credentials)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credentials: %w", err)
+	}
+	if err := json.Unmarshal([]byte(decrypted), credentials); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+	return credentials, nil
+}
+
+// EncryptCredentials encrypts structured credentials for Cluster.Credentials.
+// Empty credentials, those of in_cluster, are stored as "".
+func (f *ClientFactory) EncryptCredentials(credentials *Credentials) (string, error) {
+	if *credentials == (Credentials{}) {
+		return "", nil
+	}
+	data, err := json.Marshal(credentials)
+	if err != nil {
+		return "", err
+	}
+	return secrets.Encrypt(f.keys, string(data))
+}
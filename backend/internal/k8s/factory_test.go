@@ -0,0 +1,111 @@
+package k8s
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"github.com/mysticrenji/surfer/backend/internal/secrets"
+)
+
+func testFactory(t *testing.T) *ClientFactory {
+	t.Helper()
+	keys, err := secrets.NewLocalKeyProvider(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewClientFactory(keys, ClientOptions{QPS: 20, Burst: 40, Timeout: 10 * time.Second})
+}
+
+func testTokenCluster(t *testing.T, factory *ClientFactory, token string) *models.Cluster {
+	t.Helper()
+	credentials, err := factory.EncryptCredentials(&Credentials{Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.Cluster{
+		ID:             7,
+		CredentialType: models.CredentialToken,
+		Server:         "https://10.0.0.1:6443",
+		Credentials:    credentials,
+	}
+}
+
+func TestClientFactoryCache(t *testing.T) {
+	factory := testFactory(t)
+	cluster := testTokenCluster(t, factory, "first")
+
+	client, err := factory.ForCluster(cluster)
+	if err != nil {
+		t.Fatalf("ForCluster() error = %v", err)
+	}
+	if client.config.QPS != 20 || client.config.Burst != 40 || client.config.Timeout != 10*time.Second || client.config.RateLimiter == nil {
+		t.Errorf("ForCluster() config has QPS %v, burst %d, timeout %s, want the factory options", client.config.QPS, client.config.Burst, client.config.Timeout)
+	}
+
+	again, _ := factory.ForCluster(cluster)
+	if again != client {
+		t.Error("ForCluster() rebuilt the client of an unchanged cluster")
+	}
+
+	impersonating, err := client.Impersonate("jane@example.com", nil)
+	if err != nil || impersonating.config.RateLimiter != client.config.RateLimiter {
+		t.Error("Impersonate() does not share the rate limiter of the cached client")
+	}
+
+	updated := testTokenCluster(t, factory, "second")
+	rebuilt, _ := factory.ForCluster(updated)
+	if rebuilt == client || rebuilt.config.BearerToken != "second" {
+		t.Error("ForCluster() reused the client after the credentials changed")
+	}
+
+	factory.Invalidate(updated.ID)
+	if fresh, _ := factory.ForCluster(updated); fresh == rebuilt {
+		t.Error("ForCluster() reused the client after Invalidate()")
+	}
+}
+
+func TestClientFactoryEvictsIdleClients(t *testing.T) {
+	factory := testFactory(t)
+	cluster := testTokenCluster(t, factory, "token")
+	if _, err := factory.ForCluster(cluster); err != nil {
+		t.Fatal(err)
+	}
+	factory.cache[cluster.ID].lastUsed = time.Now().Add(-2 * clientIdleTimeout)
+
+	other := testTokenCluster(t, factory, "token")
+	other.ID = 8
+	if _, err := factory.ForCluster(other); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := factory.cache[cluster.ID]; ok {
+		t.Error("ForCluster() kept a client unused for longer than clientIdleTimeout")
+	}
+}
+
+func TestLoadClientOptions(t *testing.T) {
+	t.Setenv("K8S_CLIENT_QPS", "")
+	t.Setenv("K8S_CLIENT_BURST", "")
+	t.Setenv("K8S_REQUEST_TIMEOUT", "")
+	if options, err := LoadClientOptions(); err != nil || options != DefaultClientOptions {
+		t.Errorf("LoadClientOptions() = %+v, %v, want the defaults", options, err)
+	}
+
+	t.Setenv("K8S_CLIENT_QPS", "12.5")
+	t.Setenv("K8S_CLIENT_BURST", "25")
+	t.Setenv("K8S_REQUEST_TIMEOUT", "1m")
+	want := ClientOptions{QPS: 12.5, Burst: 25, Timeout: time.Minute}
+	if options, err := LoadClientOptions(); err != nil || options != want {
+		t.Errorf("LoadClientOptions() = %+v, %v, want %+v", options, err, want)
+	}
+
+	for name, value := range map[string]string{"K8S_CLIENT_QPS": "0", "K8S_CLIENT_BURST": "many", "K8S_REQUEST_TIMEOUT": "30"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := LoadClientOptions(); err == nil {
+				t.Errorf("LoadClientOptions() accepted %s=%s", name, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package k8s
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// ErrUnknownContext is returned when a kubeconfig has no context of the
+// requested name
+var ErrUnknownContext = errors.New("context not found in kubeconfig")
+
+// KubeconfigContext describes a context of a kubeconfig
+type KubeconfigContext struct {
+	Name      string `json:"name"`
+	Cluster   string `json:"cluster"`
+	User      string `json:"user"`
+	Namespace string `json:"namespace,omitempty"`
+	Server    string `json:"server"`
+	// Plugin names the exec or auth-provider plugin the user authenticates
+	// with, such as "exec: aws". Contexts with plugins cannot be used.
+	Plugin string `json:"plugin,omitempty"`
+}
+
+// Kubeconfig is a parsed kubeconfig
+type Kubeconfig struct {
+	CurrentContext string              `json:"current_context"`
+	Contexts       []KubeconfigContext `json:"contexts"`
+	config         *clientcmdapi.Config
+}
+
+// ParseKubeconfig parses a kubeconfig and lists its contexts by name
+func ParseKubeconfig(kubeconfig string) (*Kubeconfig, error) {
+	config, err := clientcmd.Load([]byte(kubeconfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+
+	parsed := &Kubeconfig{CurrentContext: config.CurrentContext, Contexts: make([]KubeconfigContext, 0, len(config.Contexts)), config: config}
+	for name, context := range config.Contexts {
+		described := KubeconfigContext{Name: name, Cluster: context.Cluster, User: context.AuthInfo, Namespace: context.Namespace}
+		if cluster, ok := config.Clusters[context.Cluster]; ok {
+			described.Server = cluster.Server
+		}
+		if user, ok := config.AuthInfos[context.AuthInfo]; ok {
+			described.Plugin = authPlugin(user)
+		}
+		parsed.Contexts = append(parsed.Contexts, described)
+	}
+	sort.Slice(parsed.Contexts, func(i, j int) bool { return parsed.Contexts[i].Name < parsed.Contexts[j].Name })
+	return parsed, nil
+}
+
+// ContextNames returns the names of the contexts
+func (k *Kubeconfig) ContextNames() []string {
+	names := make([]string, 0, len(k.Contexts))
+	for _, context := range k.Contexts {
+		names = append(names, context.Name)
+	}
+	return names
+}
+
+// Validate checks that the named context, or the current context for "", can
+// be used by Surfer, and returns its name. Contexts must embed their
+// certificates and credentials: file paths and plugins would be resolved and
+// run on the Surfer server.
+func (k *Kubeconfig) Validate(name string) (string, error) {
+	if name == "" {
+		name = k.CurrentContext
+	}
+	if name == "" {
+		return "", fmt.Errorf("kubeconfig has no current-context, choose one of: %s", strings.Join(k.ContextNames(), ", "))
+	}
+
+	context, ok := k.config.Contexts[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %q, available contexts: %s", ErrUnknownContext, name, strings.Join(k.ContextNames(), ", "))
+	}
+
+	cluster, ok := k.config.Clusters[context.Cluster]
+	if !ok {
+		return "", fmt.Errorf("context %q refers to cluster %q, which is not in the kubeconfig", name, context.Cluster)
+	}
+	if cluster.Server == "" {
+		return "", fmt.Errorf("cluster %q has no server", context.Cluster)
+	}
+	if cluster.CertificateAuthority != "" {
+		return "", fmt.Errorf("cluster %q refers to the file %s, use certificate-authority-data instead", context.Cluster, cluster.CertificateAuthority)
+	}
+
+	user, ok := k.config.AuthInfos[context.AuthInfo]
+	if !ok {
+		return "", fmt.Errorf("context %q refers to user %q, which is not in the kubeconfig", name, context.AuthInfo)
+	}
+	if plugin := authPlugin(user); plugin != "" {
+		return "", fmt.Errorf("user %q authenticates with the %s plugin, which would run on the Surfer server; use a token or client certificate instead", context.AuthInfo, plugin)
+	}
+	for _, file := range []string{user.ClientCertificate, user.ClientKey, user.TokenFile} {
+		if file != "" {
+			return "", fmt.Errorf("user %q refers to the file %s, embed its content with client-certificate-data, client-key-data or token instead", context.AuthInfo, file)
+		}
+	}
+
+	if err := clientcmd.ConfirmUsable(*k.config, name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Minify returns a kubeconfig holding only the named context, as its current
+// context, with its cluster and user
+func (k *Kubeconfig) Minify(name string) (string, error) {
+	context, ok := k.config.Contexts[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownContext, name)
+	}
+
+	minified := clientcmdapi.NewConfig()
+	minified.CurrentContext = name
+	minified.Contexts[name] = context.DeepCopy()
+	if cluster, ok := k.config.Clusters[context.Cluster]; ok {
+		minified.Clusters[context.Cluster] = cluster.DeepCopy()
+	}
+	if user, ok := k.config.AuthInfos[context.AuthInfo]; ok {
+		minified.AuthInfos[context.AuthInfo] = user.DeepCopy()
+	}
+
+	data, err := clientcmd.Write(*minified)
+	if err != nil {
+		return "", fmt.Errorf("failed to write kubeconfig: %w", err)
+	}
+	return string(data), nil
+}
+
+// authPlugin describes the exec or auth-provider plugin of a user, or returns
+// "" when it has none
+func authPlugin(user *clientcmdapi.AuthInfo) string {
+	switch {
+	case user.Exec != nil:
+		return "exec: " + user.Exec.Command
+	case user.AuthProvider != nil:
+		return "auth-provider: " + user.AuthProvider.Name
+	}
+	return ""
+}
+
+// restConfig builds the REST config of the named context, or of the current
+// context for ""
+func restConfig(kubeconfig, context string) (*rest.Config, error) {
+	parsed, err := ParseKubeconfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	name, err := parsed.Validate(context)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: name}
+	return clientcmd.NewNonInteractiveClientConfig(*parsed.config, name, overrides, nil).ClientConfig()
+}
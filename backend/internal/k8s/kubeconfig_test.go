@@ -0,0 +1,191 @@
+package k8s
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443
+    certificate-authority-data: dGVzdC1jYQ==
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443
+- name: local
+  cluster:
+    server: https://127.0.0.1:6443
+    certificate-authority: /etc/kubernetes/ca.crt
+users:
+- name: dev-admin
+  user:
+    token: dev-token
+- name: prod-admin
+  user:
+    token: prod-token
+- name: eks
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: aws
+      args: ["eks", "get-token"]
+- name: cert-file
+  user:
+    client-certificate: /home/me/.kube/client.crt
+    client-key: /home/me/.kube/client.key
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: dev-admin
+    namespace: apps
+- name: prod
+  context:
+    cluster: prod
+    user: prod-admin
+- name: eks
+  context:
+    cluster: prod
+    user: eks
+- name: local
+  context:
+    cluster: local
+    user: dev-admin
+- name: files
+  context:
+    cluster: dev
+    user: cert-file
+- name: broken
+  context:
+    cluster: missing
+    user: dev-admin
+`
+
+func TestParseKubeconfig(t *testing.T) {
+	parsed, err := ParseKubeconfig(testKubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.CurrentContext != "dev" {
+		t.Errorf("CurrentContext = %q, want dev", parsed.CurrentContext)
+	}
+	if got := strings.Join(parsed.ContextNames(), ","); got != "broken,dev,eks,files,local,prod" {
+		t.Errorf("ContextNames() = %s, want sorted names", got)
+	}
+
+	dev := parsed.Contexts[1]
+	if dev.Server != "https://dev.example.com:6443" || dev.Namespace != "apps" || dev.User != "dev-admin" {
+		t.Errorf("dev context = %+v", dev)
+	}
+	if eks := parsed.Contexts[2]; eks.Plugin != "exec: aws" {
+		t.Errorf("eks context Plugin = %q, want exec: aws", eks.Plugin)
+	}
+
+	if _, err := ParseKubeconfig("not: [a kubeconfig"); err == nil {
+		t.Error("ParseKubeconfig() accepted invalid YAML")
+	}
+}
+
+func TestKubeconfigValidate(t *testing.T) {
+	parsed, err := ParseKubeconfig(testKubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		context string
+		want    string
+		wantErr string
+	}{
+		{"", "dev", ""},
+		{"prod", "prod", ""},
+		{"staging", "", "available contexts: broken, dev, eks, files, local, prod"},
+		{"eks", "", "exec: aws plugin"},
+		{"local", "", "certificate-authority-data"},
+		{"files", "", "client-certificate-data"},
+		{"broken", "", "not in the kubeconfig"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.context, func(t *testing.T) {
+			got, err := parsed.Validate(tt.context)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Validate(%q) error = %v, want %q", tt.context, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Validate(%q) = %q, %v, want %q", tt.context, got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := parsed.Validate("staging"); !errors.Is(err, ErrUnknownContext) {
+		t.Errorf("Validate() error = %v, want ErrUnknownContext", err)
+	}
+}
+
+func TestKubeconfigMinify(t *testing.T) {
+	parsed, err := ParseKubeconfig(testKubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	minified, err := parsed.Minify("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, other := range []string{"prod", "eks", "prod-token"} {
+		if strings.Contains(minified, other) {
+			t.Errorf("minified kubeconfig contains %q:\n%s", other, minified)
+		}
+	}
+
+	reparsed, err := ParseKubeconfig(minified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reparsed.CurrentContext != "dev" || len(reparsed.Contexts) != 1 {
+		t.Errorf("minified kubeconfig has current context %q and %d contexts, want only dev", reparsed.CurrentContext, len(reparsed.Contexts))
+	}
+
+	config, err := restConfig(minified, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "https://dev.example.com:6443" || config.BearerToken != "dev-token" || string(config.CAData) != "test-ca" {
+		t.Errorf("minified kubeconfig gives %s with token %q and CA %q", config.Host, config.BearerToken, config.CAData)
+	}
+
+	if _, err := parsed.Minify("staging"); !errors.Is(err, ErrUnknownContext) {
+		t.Errorf("Minify() error = %v, want ErrUnknownContext", err)
+	}
+}
+
+func TestRestConfigUsesContext(t *testing.T) {
+	tests := []struct {
+		context string
+		host    string
+		token   string
+	}{
+		{"", "https://dev.example.com:6443", "dev-token"},
+		{"prod", "https://prod.example.com:6443", "prod-token"},
+	}
+
+	for _, tt := range tests {
+		config, err := restConfig(testKubeconfig, tt.context)
+		if err != nil {
+			t.Fatalf("restConfig(%q) error = %v", tt.context, err)
+		}
+		if config.Host != tt.host || config.BearerToken != tt.token {
+			t.Errorf("restConfig(%q) = %s with token %s, want %s with token %s", tt.context, config.Host, config.BearerToken, tt.host, tt.token)
+		}
+	}
+}
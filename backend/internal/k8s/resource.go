@@ -0,0 +1,100 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+)
+
+// FieldManager owns the fields Surfer sets with server-side apply
+const FieldManager = "surfer"
+
+// ErrClusterScoped is returned for kinds that are not namespaced, which
+// namespace permissions cannot cover
+var ErrClusterScoped = errors.New("only namespaced resources are supported")
+
+// ParseManifest decodes a single YAML or JSON object with an apiVersion, kind
+// and name
+func ParseManifest(manifest string) (*unstructured.Unstructured, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(manifest), 4096)
+
+	object := &unstructured.Unstructured{}
+	if err := decoder.Decode(&object.Object); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	var extra map[string]interface{}
+	if err := decoder.Decode(&extra); err != io.EOF || extra != nil {
+		return nil, errors.New("manifest must hold a single object")
+	}
+
+	if object.GetAPIVersion() == "" || object.GetKind() == "" || object.GetName() == "" {
+		return nil, errors.New("manifest needs apiVersion, kind and metadata.name")
+	}
+	if _, err := schema.ParseGroupVersion(object.GetAPIVersion()); err != nil {
+		return nil, fmt.Errorf("invalid apiVersion: %w", err)
+	}
+	return object, nil
+}
+
+// CheckNamespaced returns ErrClusterScoped unless kind of apiVersion is a
+// namespaced resource of the cluster
+func (c *Client) CheckNamespaced(apiVersion, kind string) error {
+	_, err := c.namespacedResource(apiVersion, kind)
+	return err
+}
+
+// DeleteResource deletes a namespaced resource of any kind
+func (c *Client) DeleteResource(namespace, apiVersion, kind, name string) error {
+	resource, err := c.namespacedResource(apiVersion, kind)
+	if err != nil {
+		return err
+	}
+	return resource.Namespace(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+}
+
+// ApplyResource creates or updates a namespaced object in namespace with
+// server-side apply. Fields owned by other managers are not overwritten, such
+// a conflict is returned as an error.
+func (c *Client) ApplyResource(namespace string, object *unstructured.Unstructured) error {
+	resource, err := c.namespacedResource(object.GetAPIVersion(), object.GetKind())
+	if err != nil {
+		return err
+	}
+
+	object = object.DeepCopy()
+	object.SetNamespace(namespace)
+	data, err := object.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	_, err = resource.Namespace(namespace).Patch(context.Background(), object.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: FieldManager})
+	return err
+}
+
+// namespacedResource resolves kind of apiVersion to its resource with the
+// cluster's discovery information
+func (c *Client) namespacedResource(apiVersion, kind string) (dynamic.NamespaceableResourceInterface, error) {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid apiVersion: %w", err)
+	}
+
+	mapping, err := c.mapper.RESTMapping(gv.WithKind(kind).GroupKind(), gv.Version)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return nil, ErrClusterScoped
+	}
+	return c.dynamic.Resource(mapping.Resource), nil
+}
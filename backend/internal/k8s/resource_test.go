@@ -0,0 +1,87 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestParseManifest(t *testing.T) {
+	object, err := ParseManifest("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n  namespace: shop\nspec:\n  replicas: 2\n")
+	if err != nil {
+		t.Fatalf("ParseManifest() error = %v", err)
+	}
+	if object.GetAPIVersion() != "apps/v1" || object.GetKind() != "Deployment" || object.GetName() != "web" || object.GetNamespace() != "shop" {
+		t.Errorf("ParseManifest() = %v", object.Object)
+	}
+
+	if _, err := ParseManifest(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "settings"}}`); err != nil {
+		t.Errorf("ParseManifest() rejected a JSON manifest: %v", err)
+	}
+
+	for name, manifest := range map[string]string{
+		"no name":          "apiVersion: v1\nkind: ConfigMap\n",
+		"no kind":          "apiVersion: v1\nmetadata:\n  name: settings\n",
+		"several objects":  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: b\n",
+		"invalid version":  "apiVersion: a/b/c\nkind: ConfigMap\nmetadata:\n  name: settings\n",
+		"not an object":    "- one\n- two\n",
+		"malformed object": "{",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseManifest(manifest); err == nil {
+				t.Errorf("ParseManifest() accepted %q", manifest)
+			}
+		})
+	}
+}
+
+func testResourceClient(objects ...runtime.Object) *Client {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Node"}, meta.RESTScopeRoot)
+
+	dynamic := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Version: "v1", Resource: "configmaps"}: "ConfigMapList",
+	}, objects...)
+	return &Client{dynamic: dynamic, mapper: mapper}
+}
+
+func TestResourceScope(t *testing.T) {
+	client := testResourceClient()
+	if err := client.CheckNamespaced("v1", "ConfigMap"); err != nil {
+		t.Errorf("CheckNamespaced(ConfigMap) error = %v", err)
+	}
+	if err := client.CheckNamespaced("v1", "Node"); !errors.Is(err, ErrClusterScoped) {
+		t.Errorf("CheckNamespaced(Node) error = %v, want ErrClusterScoped", err)
+	}
+	if err := client.CheckNamespaced("v1", "Unknown"); err == nil {
+		t.Error("CheckNamespaced() accepted an unknown kind")
+	}
+	if err := client.DeleteResource("", "v1", "Node", "worker-1"); !errors.Is(err, ErrClusterScoped) {
+		t.Errorf("DeleteResource(Node) error = %v, want ErrClusterScoped", err)
+	}
+}
+
+func TestDeleteResource(t *testing.T) {
+	configMap := &unstructured.Unstructured{}
+	configMap.SetAPIVersion("v1")
+	configMap.SetKind("ConfigMap")
+	configMap.SetName("settings")
+	configMap.SetNamespace("shop")
+	client := testResourceClient(configMap)
+
+	if err := client.DeleteResource("shop", "v1", "ConfigMap", "settings"); err != nil {
+		t.Fatalf("DeleteResource() error = %v", err)
+	}
+	resource := client.dynamic.Resource(schema.GroupVersionResource{Version: "v1", Resource: "configmaps"})
+	if _, err := resource.Namespace("shop").Get(context.Background(), "settings", metav1.GetOptions{}); err == nil {
+		t.Error("DeleteResource() left the config map")
+	}
+}
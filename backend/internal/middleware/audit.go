@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/audit"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+// Audit records every request that is not a GET, and GETs marked with
+// SensitiveRead, with the caller, the resource, a redacted summary of the
+// request, the outcome and the latency. It must run after AuthRequired.
+// Entries of requests whose handler recorded events are written before the
+// middleware returns, the others are queued.
+func Audit(recorder audit.Recorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		summary := audit.Summarize(c.Request)
+
+		c.Next()
+
+		method := c.Request.Method
+		if (method == http.MethodGet || method == http.MethodHead) && !c.GetBool("audit_read") {
+			return
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		described := audit.DescribeRoute(method, route)
+
+		actorType := c.GetString("user_type")
+		if actorType == "" {
+			actorType = models.UserTypeHuman
+		}
+
+		entry := &models.AuditLog{
+			UserID:     c.GetUint("user_id"),
+			ActorType:  actorType,
+			Action:     described.Action,
+			Resource:   described.Resource,
+			Details:    summary,
+			IPAddress:  c.ClientIP(),
+			Namespace:  c.Param("namespace"),
+			Method:     method,
+			Route:      route,
+			StatusCode: c.Writer.Status(),
+			Outcome:    audit.Outcome(c.Writer.Status()),
+			LatencyMs:  time.Since(start).Milliseconds(),
+		}
+		if described.IDParam != "" {
+			entry.ResourceID = c.Param(described.IDParam)
+		}
+		if described.ClusterParam != "" {
+			if clusterID, err := strconv.ParseUint(c.Param(described.ClusterParam), 10, 32); err == nil {
+				id := uint(clusterID)
+				entry.ClusterID = &id
+			}
+		}
+		// Entries with handler events, such as grants and approvals, are
+		// written right away rather than queued, where they could be dropped
+		if events, ok := c.Get("audit_events"); ok {
+			audit.Enrich(entry, events.([]audit.Event))
+			if err := recorder.Write(entry); err != nil {
+				log.Printf("Failed to write audit log entry %s %s %s: %v", entry.Action, entry.Resource, entry.ResourceID, err)
+			}
+			return
+		}
+
+		recorder.Record(entry)
+	}
+}
+
+// SensitiveRead marks a GET route for Audit to record, such as pod logs
+func SensitiveRead() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("audit_read", true)
+		c.Next()
+	}
+}
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mysticrenji/surfer/backend/internal/audit"
+	"github.com/mysticrenji/surfer/backend/internal/models"
+)
+
+// fakeRecorder collects audit log entries in memory
+type fakeRecorder struct {
+	entries []*models.AuditLog
+	written []*models.AuditLog
+}
+
+func (r *fakeRecorder) Record(entry *models.AuditLog) {
+	r.entries = append(r.entries, entry)
+}
+
+func (r *fakeRecorder) Write(entry *models.AuditLog) error {
+	r.written = append(r.written, entry)
+	return nil
+}
+
+func newAuditRouter(recorder audit.Recorder) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Mock auth context
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", uint(7))
+		c.Set("user_type", models.UserTypeServiceAccount)
+		c.Next()
+	}, Audit(recorder))
+
+	return router
+}
+
+func TestAuditRecordsMutatingRequest(t *testing.T) {
+	recorder := &fakeRecorder{}
+	router := newAuditRouter(recorder)
+
+	var body string
+	router.PUT("/api/v1/clusters/:id", func(c *gin.Context) {
+		raw, _ := io.ReadAll(c.Request.Body)
+		body = string(raw)
+		c.JSON(http.StatusForbidden, gin.H{"error": "denied"})
+	})
+
+	payload := `{"name":"prod","kubeconfig":"apiVersion: v1"}`
+	req, _ := http.NewRequest("PUT", "/api/v1/clusters/3", strings.NewReader(payload))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if body != payload {
+		t.Errorf("Expected the handler to read the original body, got %q", body)
+	}
+
+	if len(recorder.entries) != 1 {
+		t.Fatalf("Expected 1 audit entry, got %d", len(recorder.entries))
+	}
+
+	entry := recorder.entries[0]
+	if entry.UserID != 7 || entry.ActorType != models.UserTypeServiceAccount {
+		t.Errorf("Expected user 7 as service account, got %d as %s", entry.UserID, entry.ActorType)
+	}
+	if entry.Action != "update" || entry.Resource != "clusters" || entry.ResourceID != "3" {
+		t.Errorf("Expected update clusters 3, got %s %s %s", entry.Action, entry.Resource, entry.ResourceID)
+	}
+	if entry.ClusterID == nil || *entry.ClusterID != 3 {
+		t.Errorf("Expected cluster 3, got %v", entry.ClusterID)
+	}
+	if entry.StatusCode != http.StatusForbidden || entry.Outcome != audit.OutcomeDenied {
+		t.Errorf("Expected a denied 403, got %s %d", entry.Outcome, entry.StatusCode)
+	}
+	if strings.Contains(entry.Details, "apiVersion") || !strings.Contains(entry.Details, audit.Redacted) {
+		t.Errorf("Expected the kubeconfig to be redacted, got %s", entry.Details)
+	}
+}
+
+func TestAuditSkipsReads(t *testing.T) {
+	recorder := &fakeRecorder{}
+	router := newAuditRouter(recorder)
+
+	router.GET("/api/v1/clusters", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{})
+	})
+
+	req, _ := http.NewRequest("GET", "/api/v1/clusters", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(recorder.entries) != 0 {
+		t.Errorf("Expected reads not to be recorded, got %d entries", len(recorder.entries))
+	}
+}
+
+func TestAuditRecordsSensitiveRead(t *testing.T) {
+	recorder := &fakeRecorder{}
+	router := newAuditRouter(recorder)
+
+	router.GET("/api/v1/k8s/clusters/:clusterId/namespaces/:namespace/pods/:pod/logs", SensitiveRead(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"logs": ""})
+	})
+
+	req, _ := http.NewRequest("GET", "/api/v1/k8s/clusters/2/namespaces/payments/pods/api-0/logs?tail=50", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(recorder.entries) != 1 {
+		t.Fatalf("Expected 1 audit entry, got %d", len(recorder.entries))
+	}
+
+	entry := recorder.entries[0]
+	if entry.Action != "read_logs" || entry.Resource != "pods" || entry.ResourceID != "api-0" || entry.Namespace != "payments" {
+		t.Errorf("Expected read_logs of pod payments/api-0, got %s %s %s/%s", entry.Action, entry.Resource, entry.Namespace, entry.ResourceID)
+	}
+	if entry.ClusterID == nil || *entry.ClusterID != 2 {
+		t.Errorf("Expected cluster 2, got %v", entry.ClusterID)
+	}
+	if entry.Details != "query=tail=50" {
+		t.Errorf("Expected the query in the summary, got %q", entry.Details)
+	}
+}
+
+func TestAuditAddsHandlerEvents(t *testing.T) {
+	recorder := &fakeRecorder{}
+	router := newAuditRouter(recorder)
+
+	router.POST("/api/v1/admin/teams", func(c *gin.Context) {
+		c.Set("audit_events", []audit.Event{
+			{Action: "create", Resource: "team", ResourceID: "12", Details: "name=platform"},
+			{Action: "add_member", Resource: "team", ResourceID: "12", Details: "user=7"},
+		})
+		c.JSON(http.StatusCreated, gin.H{"id": 12})
+	})
+
+	req, _ := http.NewRequest("POST", "/api/v1/admin/teams", strings.NewReader(`{"name":"platform"}`))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(recorder.entries) != 0 || len(recorder.written) != 1 {
+		t.Fatalf("Expected 1 entry written without the queue, got %d queued and %d written", len(recorder.entries), len(recorder.written))
+	}
+
+	entry := recorder.written[0]
+	if entry.Action != "create" || entry.ResourceID != "12" {
+		t.Errorf("Expected create with the ID of the new team, got %s %s", entry.Action, entry.ResourceID)
+	}
+	want := `body={"name":"platform"}; create team 12 name=platform; add_member team 12 user=7`
+	if entry.Details != want {
+		t.Errorf("Expected details %q, got %q", want, entry.Details)
+	}
+}
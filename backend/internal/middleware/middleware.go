@@ -1,11 +1,15 @@
 package middleware
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mysticrenji/surfer/backend/internal/auth"
+	"github.com/mysticrenji/surfer/backend/internal/models"
 )
 
 // CORS middleware
@@ -25,8 +29,10 @@ func CORS() gin.HandlerFunc {
 	}
 }
 
-// AuthRequired middleware
-func AuthRequired() gin.HandlerFunc {
+// AuthRequired middleware validates the bearer token, either a JWT whose
+// session has not been revoked or a personal access token, and stores the
+// permissions of the caller's role as "permissions"
+func AuthRequired(keys *auth.KeySet, sessions auth.SessionStore, tokens auth.AccessTokenStore, roles auth.RoleStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -44,32 +50,179 @@ func AuthRequired() gin.HandlerFunc {
 		}
 
 		token := parts[1]
-		claims, err := auth.ValidateToken(token)
+		if auth.IsAccessToken(token) {
+			authenticateAccessToken(c, tokens, roles, token)
+			return
+		}
+
+		claims, err := keys.ValidateToken(token)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
+		session, err := sessions.Validate(claims.ID, claims.UserID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session revoked or expired"})
+			c.Abort()
+			return
+		}
+
 		// Store user info in context
+		// Only humans log in through a provider, so sessions are always theirs
+		c.Set("auth_method", "session")
+		c.Set("user_type", models.UserTypeHuman)
+		c.Set("session_id", session.ID)
 		c.Set("user_id", claims.UserID)
 		c.Set("user_email", claims.Email)
 		c.Set("user_role", claims.Role)
 
+		if !setPermissions(c, roles, claims.Role) {
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// authenticateAccessToken handles requests made with a personal access
+// token. Reads need the read scope and everything else the write scope.
+func authenticateAccessToken(c *gin.Context, tokens auth.AccessTokenStore, roles auth.RoleStore, raw string) {
+	token, err := tokens.Authenticate(raw)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, revoked or expired access token"})
+		c.Abort()
+		return
+	}
+
+	// The user's current status and role apply, not those at creation time
+	if token.User.Status != "approved" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User account is not approved"})
+		c.Abort()
+		return
+	}
+
+	required := auth.ScopeWrite
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		required = auth.ScopeRead
+	}
+	if !auth.HasScope(token.Scopes, required) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access token lacks the " + required + " scope"})
+		c.Abort()
+		return
+	}
+
+	if err := tokens.MarkUsed(token.ID, c.ClientIP()); err != nil {
+		log.Printf("Failed to record access token usage: %v", err)
+	}
+
+	c.Set("auth_method", "token")
+	c.Set("token_id", token.ID)
+	c.Set("token_scopes", token.Scopes)
+	c.Set("user_type", token.User.Type)
+	c.Set("user_id", token.UserID)
+	c.Set("user_email", token.User.Email)
+	c.Set("user_role", token.User.Role)
+
+	if !setPermissions(c, roles, token.User.Role) {
+		return
+	}
+
+	c.Next()
+}
+
+// setPermissions stores the permissions of role in the context. A role that
+// no longer exists grants nothing.
+func setPermissions(c *gin.Context, roles auth.RoleStore, role string) bool {
+	permissions, err := roles.Permissions(role)
+	if errors.Is(err, auth.ErrRoleNotFound) {
+		permissions = auth.PermissionSet{}
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load role permissions"})
+		c.Abort()
+		return false
+	}
+
+	c.Set("permissions", permissions)
+	return true
+}
+
+// PermissionRequired checks that the caller's role grants permission. Admin
+// permissions also need the admin scope on access tokens.
+func PermissionRequired(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth.HasPermission(c, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission " + permission + " required"})
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
 
-// AdminRequired middleware
-func AdminRequired() gin.HandlerFunc {
+// ClusterAccessRequired checks that the caller has at least level on the
+// whole cluster in the :id or :clusterId route parameter, through a
+// permission of their own or of one of their groups. Roles with clusters:all
+// can access every cluster, except with access tokens that lack the admin scope.
+func ClusterAccessRequired(permissions auth.PermissionStore, level string) gin.HandlerFunc {
+	return clusterAccess(permissions, level, func(access *auth.ClusterAccess) bool {
+		return models.ClusterLevelAllows(access.Level, level)
+	})
+}
+
+// StandingAccessRequired is like ClusterAccessRequired but ignores approved
+// access requests, for changes that outlive them such as granting access
+func StandingAccessRequired(permissions auth.PermissionStore, level string) gin.HandlerFunc {
+	return clusterAccess(permissions, level, func(access *auth.ClusterAccess) bool {
+		return models.ClusterLevelAllows(access.StandingLevel, level)
+	})
+}
+
+// NamespaceAccessRequired is like ClusterAccessRequired but also lets through
+// callers that have level only in some namespaces. The handler must check the
+// namespace against the *auth.ClusterAccess stored as "cluster_access".
+func NamespaceAccessRequired(permissions auth.PermissionStore, level string) gin.HandlerFunc {
+	return clusterAccess(permissions, level, func(access *auth.ClusterAccess) bool {
+		return access.Allows(level)
+	})
+}
+
+func clusterAccess(permissions auth.PermissionStore, level string, allows func(*auth.ClusterAccess) bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role, exists := c.Get("user_role")
-		if !exists || role != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		param := c.Param("clusterId")
+		if param == "" {
+			param = c.Param("id")
+		}
+		clusterID, err := strconv.ParseUint(param, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cluster ID"})
+			c.Abort()
+			return
+		}
+
+		if auth.HasPermission(c, models.PermClustersAll) {
+			c.Set("cluster_access", &auth.ClusterAccess{Level: models.ClusterLevelAdmin, StandingLevel: models.ClusterLevelAdmin})
+			c.Next()
+			return
+		}
+
+		userID, _ := c.Get("user_id")
+		access, err := permissions.ClusterAccess(userID.(uint), uint(clusterID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check cluster access"})
+			c.Abort()
+			return
+		}
+
+		if !allows(access) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Cluster access denied, " + level + " level required"})
 			c.Abort()
 			return
 		}
 
+		c.Set("cluster_access", access)
 		c.Next()
 	}
 }
@@ -3,13 +3,222 @@ package middleware
 import (
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mysticrenji/surfer/backend/internal/auth"
+	"github.com/mysticrenji/surfer/backend/internal/models"
 )
 
+var testKeys, _ = auth.GenerateKeySet("surfer")
+
+// fakeSessionStore is an in-memory auth.SessionStore keyed by token ID
+type fakeSessionStore struct {
+	sessions map[string]*models.Session
+}
+
+func newFakeSessionStore() *fakeSessionStore {
+	return &fakeSessionStore{sessions: make(map[string]*models.Session)}
+}
+
+func (s *fakeSessionStore) Create(session *models.Session, refreshTokenHash string) error {
+	session.ID = uint(len(s.sessions) + 1)
+	s.sessions[session.Token] = session
+	return nil
+}
+
+func (s *fakeSessionStore) Validate(tokenID string, userID uint) (*models.Session, error) {
+	session, ok := s.sessions[tokenID]
+	if !ok || session.UserID != userID {
+		return nil, auth.ErrSessionNotFound
+	}
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return nil, auth.ErrSessionInvalid
+	}
+	return session, nil
+}
+
+func (s *fakeSessionStore) ListActive(userID uint) ([]models.Session, error) {
+	var active []models.Session
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			active = append(active, *session)
+		}
+	}
+	return active, nil
+}
+
+func (s *fakeSessionStore) Revoke(userID, sessionID uint) error {
+	for _, session := range s.sessions {
+		if session.ID == sessionID && session.UserID == userID {
+			now := time.Now()
+			session.RevokedAt = &now
+			return nil
+		}
+	}
+	return auth.ErrSessionNotFound
+}
+
+func (s *fakeSessionStore) Rotate(refreshTokenHash, newRefreshTokenHash, newTokenID string) (*models.Session, error) {
+	return nil, auth.ErrRefreshTokenInvalid
+}
+
+func (s *fakeSessionStore) RevokeAll(userID uint) (int64, error) {
+	var revoked int64
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			now := time.Now()
+			session.RevokedAt = &now
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// fakeAccessTokenStore is an in-memory auth.AccessTokenStore keyed by raw token
+type fakeAccessTokenStore struct {
+	tokens map[string]*models.PersonalAccessToken
+}
+
+func newFakeAccessTokenStore() *fakeAccessTokenStore {
+	return &fakeAccessTokenStore{tokens: make(map[string]*models.PersonalAccessToken)}
+}
+
+// add stores a token for an approved user and returns the raw token
+func (s *fakeAccessTokenStore) add(role, scopes string, expiresAt time.Time) string {
+	raw, hash, _ := auth.NewAccessToken()
+	s.tokens[raw] = &models.PersonalAccessToken{
+		ID:        uint(len(s.tokens) + 1),
+		UserID:    1,
+		TokenHash: hash,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		User:      models.User{ID: 1, Email: "ci@example.com", Role: role, Status: "approved"},
+	}
+	return raw
+}
+
+func (s *fakeAccessTokenStore) Create(token *models.PersonalAccessToken) error {
+	token.ID = uint(len(s.tokens) + 1)
+	s.tokens[token.TokenHash] = token
+	return nil
+}
+
+func (s *fakeAccessTokenStore) Authenticate(raw string) (*models.PersonalAccessToken, error) {
+	token, ok := s.tokens[raw]
+	if !ok {
+		return nil, auth.ErrAccessTokenNotFound
+	}
+	if token.RevokedAt != nil || time.Now().After(token.ExpiresAt) {
+		return nil, auth.ErrAccessTokenInvalid
+	}
+	return token, nil
+}
+
+func (s *fakeAccessTokenStore) MarkUsed(tokenID uint, ip string) error {
+	for _, token := range s.tokens {
+		if token.ID == tokenID {
+			now := time.Now()
+			token.LastUsedAt = &now
+			token.LastUsedIP = ip
+		}
+	}
+	return nil
+}
+
+func (s *fakeAccessTokenStore) List(userID uint) ([]models.PersonalAccessToken, error) {
+	var tokens []models.PersonalAccessToken
+	for _, token := range s.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			tokens = append(tokens, *token)
+		}
+	}
+	return tokens, nil
+}
+
+func (s *fakeAccessTokenStore) Revoke(userID, tokenID uint) error {
+	for _, token := range s.tokens {
+		if token.ID == tokenID && token.UserID == userID {
+			now := time.Now()
+			token.RevokedAt = &now
+			return nil
+		}
+	}
+	return auth.ErrAccessTokenNotFound
+}
+
+func (s *fakeAccessTokenStore) RevokeAll(userID uint) (int64, error) {
+	var revoked int64
+	for _, token := range s.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			now := time.Now()
+			token.RevokedAt = &now
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// fakePermissionStore is an in-memory auth.PermissionStore keyed by user
+type fakePermissionStore struct {
+	permissions []models.ClusterPermission
+	requests    []models.AccessRequest // Approved and active
+}
+
+func (s *fakePermissionStore) ClusterAccess(userID, clusterID uint) (*auth.ClusterAccess, error) {
+	access := auth.ResolveClusterAccess(s.permissions, s.requests, userID, nil, nil)
+	if access[clusterID] == nil {
+		return &auth.ClusterAccess{}, nil
+	}
+	return access[clusterID], nil
+}
+
+func (s *fakePermissionStore) AccessibleClusters(userID uint) ([]uint, error) {
+	var ids []uint
+	for id := range auth.ResolveClusterAccess(s.permissions, s.requests, userID, nil, nil) {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *fakePermissionStore) List(userID uint) ([]models.ClusterPermission, error) {
+	return nil, nil
+}
+
+func (s *fakePermissionStore) ListForCluster(clusterID uint) ([]models.ClusterPermission, error) {
+	return nil, nil
+}
+
+func (s *fakePermissionStore) ListForTeam(teamID uint) ([]models.ClusterPermission, error) {
+	return nil, nil
+}
+
+func (s *fakePermissionStore) Grant(permission *models.ClusterPermission) error {
+	s.permissions = append(s.permissions, *permission)
+	return nil
+}
+
+func (s *fakePermissionStore) Revoke(userID, clusterID uint) error {
+	return auth.ErrPermissionNotFound
+}
+
+func (s *fakePermissionStore) RevokeByID(clusterID, permissionID uint) error {
+	return auth.ErrPermissionNotFound
+}
+
+// fakeRoleStore is an auth.RoleStore with the built-in roles
+type fakeRoleStore struct{}
+
+func (fakeRoleStore) Permissions(name string) (auth.PermissionSet, error) {
+	for _, role := range models.BuiltInRoles() {
+		if role.Name == name {
+			return auth.NewPermissionSet(role.Permissions), nil
+		}
+	}
+	return nil, auth.ErrRoleNotFound
+}
+
 func TestCORS(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
@@ -53,7 +262,7 @@ func TestCORSOptions(t *testing.T) {
 func TestAuthRequiredNoToken(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
-	router.Use(AuthRequired())
+	router.Use(AuthRequired(testKeys, newFakeSessionStore(), newFakeAccessTokenStore(), fakeRoleStore{}))
 
 	router.GET("/protected", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "ok"})
@@ -71,7 +280,7 @@ func TestAuthRequiredNoToken(t *testing.T) {
 func TestAuthRequiredInvalidFormat(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
-	router.Use(AuthRequired())
+	router.Use(AuthRequired(testKeys, newFakeSessionStore(), newFakeAccessTokenStore(), fakeRoleStore{}))
 
 	router.GET("/protected", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "ok"})
@@ -90,7 +299,7 @@ func TestAuthRequiredInvalidFormat(t *testing.T) {
 func TestAuthRequiredInvalidToken(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
-	router.Use(AuthRequired())
+	router.Use(AuthRequired(testKeys, newFakeSessionStore(), newFakeAccessTokenStore(), fakeRoleStore{}))
 
 	router.GET("/protected", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "ok"})
@@ -107,12 +316,12 @@ func TestAuthRequiredInvalidToken(t *testing.T) {
 }
 
 func TestAuthRequiredValidToken(t *testing.T) {
-	os.Setenv("JWT_SECRET", "test-secret-key")
-	defer os.Unsetenv("JWT_SECRET")
+	sessions := newFakeSessionStore()
+	sessions.Create(&models.Session{UserID: 1, Token: "test-token-id", ExpiresAt: time.Now().Add(time.Hour)}, "")
 
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
-	router.Use(AuthRequired())
+	router.Use(AuthRequired(testKeys, sessions, newFakeAccessTokenStore(), fakeRoleStore{}))
 
 	router.GET("/protected", func(c *gin.Context) {
 		userID, _ := c.Get("user_id")
@@ -126,7 +335,7 @@ func TestAuthRequiredValidToken(t *testing.T) {
 	})
 
 	// Generate valid token
-	token, _ := auth.GenerateToken(1, "test@example.com", "user")
+	token, _ := testKeys.GenerateToken(1, "test@example.com", "operator", "test-token-id")
 
 	req, _ := http.NewRequest("GET", "/protected", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -138,7 +347,161 @@ func TestAuthRequiredValidToken(t *testing.T) {
 	}
 }
 
-func TestAdminRequiredNotAdmin(t *testing.T) {
+func TestAuthRequiredRevokedSession(t *testing.T) {
+	sessions := newFakeSessionStore()
+	session := &models.Session{UserID: 1, Token: "revoked-token-id", ExpiresAt: time.Now().Add(time.Hour)}
+	sessions.Create(session, "")
+	sessions.Revoke(1, session.ID)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(AuthRequired(testKeys, sessions, newFakeAccessTokenStore(), fakeRoleStore{}))
+
+	router.GET("/protected", func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": "ok"})
+	})
+
+	token, _ := testKeys.GenerateToken(1, "test@example.com", "operator", "revoked-token-id")
+
+	req, _ := http.NewRequest("GET", "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestAuthRequiredUnknownSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(AuthRequired(testKeys, newFakeSessionStore(), newFakeAccessTokenStore(), fakeRoleStore{}))
+
+	router.GET("/protected", func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": "ok"})
+	})
+
+	// A correctly signed token without a session row must be rejected
+	token, _ := testKeys.GenerateToken(1, "test@example.com", "operator", "unknown-token-id")
+
+	req, _ := http.NewRequest("GET", "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestAuthRequiredAccessToken(t *testing.T) {
+	tokens := newFakeAccessTokenStore()
+	readToken := tokens.add("operator", "read", time.Now().Add(time.Hour))
+	writeToken := tokens.add("operator", "write", time.Now().Add(time.Hour))
+	expiredToken := tokens.add("operator", "write", time.Now().Add(-time.Hour))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(AuthRequired(testKeys, newFakeSessionStore(), tokens, fakeRoleStore{}))
+
+	router.GET("/protected", func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": "ok"})
+	})
+	router.DELETE("/protected", func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": "ok"})
+	})
+
+	testCases := []struct {
+		name   string
+		method string
+		token  string
+		want   int
+	}{
+		{"read token can read", "GET", readToken, http.StatusOK},
+		{"read token cannot write", "DELETE", readToken, http.StatusForbidden},
+		{"write token can read", "GET", writeToken, http.StatusOK},
+		{"write token can write", "DELETE", writeToken, http.StatusOK},
+		{"expired token", "GET", expiredToken, http.StatusUnauthorized},
+		{"unknown token", "GET", auth.AccessTokenPrefix + "unknown", http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tc.method, "/protected", nil)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.want {
+				t.Errorf("Expected status code %d, got %d", tc.want, w.Code)
+			}
+		})
+	}
+
+	if tokens.tokens[readToken].LastUsedAt == nil {
+		t.Error("Expected last used time to be recorded")
+	}
+}
+
+func TestAuthRequiredAccessTokenAdminScope(t *testing.T) {
+	tokens := newFakeAccessTokenStore()
+	withoutScope := tokens.add("admin", "read,write", time.Now().Add(time.Hour))
+	withScope := tokens.add("admin", "admin,read", time.Now().Add(time.Hour))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(AuthRequired(testKeys, newFakeSessionStore(), tokens, fakeRoleStore{}))
+	router.Use(PermissionRequired(models.PermUsersApprove))
+
+	router.GET("/admin", func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": "ok"})
+	})
+
+	for token, want := range map[string]int{withoutScope: http.StatusForbidden, withScope: http.StatusOK} {
+		req, _ := http.NewRequest("GET", "/admin", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != want {
+			t.Errorf("Expected status code %d, got %d", want, w.Code)
+		}
+	}
+}
+
+func TestAuthRequiredRolePermissions(t *testing.T) {
+	tokens := newFakeAccessTokenStore()
+	viewerToken := tokens.add("viewer", "write", time.Now().Add(time.Hour))
+	operatorToken := tokens.add("operator", "write", time.Now().Add(time.Hour))
+	deletedRoleToken := tokens.add("deleted-role", "write", time.Now().Add(time.Hour))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(AuthRequired(testKeys, newFakeSessionStore(), tokens, fakeRoleStore{}))
+	router.Use(PermissionRequired(models.PermPodsDelete))
+
+	router.DELETE("/pods", func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": "ok"})
+	})
+
+	for token, want := range map[string]int{
+		viewerToken:      http.StatusForbidden,
+		operatorToken:    http.StatusOK,
+		deletedRoleToken: http.StatusForbidden,
+	} {
+		req, _ := http.NewRequest("DELETE", "/pods", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != want {
+			t.Errorf("Expected status code %d, got %d", want, w.Code)
+		}
+	}
+}
+
+func TestPermissionRequiredMissing(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
 
@@ -146,11 +509,12 @@ func TestAdminRequiredNotAdmin(t *testing.T) {
 	router.Use(func(c *gin.Context) {
 		c.Set("user_id", uint(1))
 		c.Set("user_email", "user@example.com")
-		c.Set("user_role", "user")
+		c.Set("user_role", "operator")
+		c.Set("permissions", rolePermissions("operator"))
 		c.Next()
 	})
 
-	router.Use(AdminRequired())
+	router.Use(PermissionRequired(models.PermUsersApprove))
 
 	router.GET("/admin", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "ok"})
@@ -165,7 +529,7 @@ func TestAdminRequiredNotAdmin(t *testing.T) {
 	}
 }
 
-func TestAdminRequiredIsAdmin(t *testing.T) {
+func TestPermissionRequiredGranted(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
 
@@ -174,10 +538,11 @@ func TestAdminRequiredIsAdmin(t *testing.T) {
 		c.Set("user_id", uint(1))
 		c.Set("user_email", "admin@example.com")
 		c.Set("user_role", "admin")
+		c.Set("permissions", rolePermissions("admin"))
 		c.Next()
 	})
 
-	router.Use(AdminRequired())
+	router.Use(PermissionRequired(models.PermUsersApprove))
 
 	router.GET("/admin", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "ok"})
@@ -192,18 +557,18 @@ func TestAdminRequiredIsAdmin(t *testing.T) {
 	}
 }
 
-func TestAdminRequiredNoRole(t *testing.T) {
+func TestPermissionRequiredNoPermissions(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
 
-	// Mock auth context without role
+	// Mock auth context without role or permissions
 	router.Use(func(c *gin.Context) {
 		c.Set("user_id", uint(1))
 		c.Set("user_email", "user@example.com")
 		c.Next()
 	})
 
-	router.Use(AdminRequired())
+	router.Use(PermissionRequired(models.PermUsersApprove))
 
 	router.GET("/admin", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "ok"})
@@ -217,3 +582,73 @@ func TestAdminRequiredNoRole(t *testing.T) {
 		t.Errorf("Expected status code %d, got %d", http.StatusForbidden, w.Code)
 	}
 }
+
+func TestClusterAccessRequired(t *testing.T) {
+	permissions := &fakePermissionStore{permissions: []models.ClusterPermission{
+		{ClusterID: 1, UserID: 2, Level: models.ClusterLevelViewer},
+		{ClusterID: 4, UserID: 2, Namespace: "team-a-*", Level: models.ClusterLevelOperator},
+		{ClusterID: 5, UserID: 2, Level: models.ClusterLevelAdmin},
+	}, requests: []models.AccessRequest{
+		{ClusterID: 6, UserID: 2, Level: models.ClusterLevelAdmin},
+	}}
+
+	testCases := []struct {
+		name   string
+		role   string
+		scopes string
+		method string
+		path   string
+		want   int
+	}{
+		{"viewer can read", "viewer", "", "GET", "/k8s/clusters/1/pods", http.StatusOK},
+		{"viewer cannot delete", "viewer", "", "DELETE", "/k8s/clusters/1/pods/web", http.StatusForbidden},
+		{"user without permission", "viewer", "", "GET", "/clusters/3", http.StatusForbidden},
+		{"admin without permission", "admin", "", "DELETE", "/k8s/clusters/3/pods/web", http.StatusOK},
+		{"admin token without admin scope", "admin", "read,write", "GET", "/clusters/3", http.StatusForbidden},
+		{"admin token with admin scope", "admin", "admin,write", "GET", "/clusters/3", http.StatusOK},
+		{"invalid cluster ID", "viewer", "", "GET", "/clusters/abc", http.StatusBadRequest},
+		{"namespace grant reaches resources", "viewer", "", "DELETE", "/k8s/clusters/4/pods/web", http.StatusOK},
+		{"namespace grant is not cluster-wide", "viewer", "", "GET", "/clusters/4", http.StatusForbidden},
+		{"standing admin can grant", "viewer", "", "POST", "/clusters/5/access", http.StatusOK},
+		{"access request reaches the cluster", "viewer", "", "GET", "/clusters/6", http.StatusOK},
+		{"access request cannot grant", "viewer", "", "POST", "/clusters/6/access", http.StatusForbidden},
+		{"admin can grant", "admin", "", "POST", "/clusters/3/access", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+
+			// Mock auth context
+			router.Use(func(c *gin.Context) {
+				c.Set("user_id", uint(2))
+				c.Set("user_role", tc.role)
+				c.Set("permissions", rolePermissions(tc.role))
+				if tc.scopes != "" {
+					c.Set("token_scopes", tc.scopes)
+				}
+				c.Next()
+			})
+
+			ok := func(c *gin.Context) { c.JSON(200, gin.H{"message": "ok"}) }
+			router.GET("/clusters/:id", ClusterAccessRequired(permissions, models.ClusterLevelViewer), ok)
+			router.POST("/clusters/:id/access", StandingAccessRequired(permissions, models.ClusterLevelAdmin), ok)
+			router.GET("/k8s/clusters/:clusterId/pods", NamespaceAccessRequired(permissions, models.ClusterLevelViewer), ok)
+			router.DELETE("/k8s/clusters/:clusterId/pods/:pod", NamespaceAccessRequired(permissions, models.ClusterLevelOperator), ok)
+
+			req, _ := http.NewRequest(tc.method, tc.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.want {
+				t.Errorf("Expected status code %d, got %d", tc.want, w.Code)
+			}
+		})
+	}
+}
+
+func rolePermissions(role string) auth.PermissionSet {
+	permissions, _ := fakeRoleStore{}.Permissions(role)
+	return permissions
+}
@@ -6,55 +6,469 @@ import (
 	"gorm.io/gorm"
 )
 
+// Principal types of a User
+const (
+	UserTypeHuman          = "human"
+	UserTypeServiceAccount = "service_account"
+)
+
 // User represents a user in the system
 type User struct {
-	ID            uint           `gorm:"primarykey" json:"id"`
-	Email         string         `gorm:"unique;not null" json:"email"`
-	Name          string         `json:"name"`
-	Picture       string         `json:"picture"`
-	GoogleID      string         `gorm:"unique;not null" json:"google_id"`
-	Role          string         `gorm:"default:'pending'" json:"role"` // pending, user, admin
-	Status        string         `gorm:"default:'pending'" json:"status"` // pending, approved, rejected
-	ApprovedBy    *uint          `json:"approved_by,omitempty"`
-	ApprovedAt    *time.Time     `json:"approved_at,omitempty"`
-	CreatedAt     time.Time      `json:"created_at"`
-	UpdatedAt     time.Time      `json:"updated_at"`
-	DeletedAt     gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	ID             uint           `gorm:"primarykey" json:"id"`
+	Email          string         `gorm:"unique;not null" json:"email"`
+	Name           string         `json:"name"`
+	Picture        string         `json:"picture"`
+	GoogleID       string         `gorm:"index" json:"google_id,omitempty"`  // Set for users who logged in with Google, see UserIdentity
+	Type           string         `gorm:"default:'human';index" json:"type"` // human, service_account
+	Description    string         `json:"description,omitempty"`             // Purpose of a service account
+	Role           string         `gorm:"default:'pending'" json:"role"`     // pending, or the name of a Role
+	Status         string         `gorm:"default:'pending'" json:"status"`   // pending, approved, rejected
+	ApprovedBy     *uint          `json:"approved_by,omitempty"`
+	ApprovedAt     *time.Time     `json:"approved_at,omitempty"`
+	ApprovedByRule *uint          `json:"approved_by_rule,omitempty"` // ApprovalRule that approved the user at login or in a bulk apply
+	CreatedBy      *uint          `json:"created_by,omitempty"`       // Admin who created a service account
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	Identities     []UserIdentity `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// ActorTypeSystem marks audit log entries written by Surfer itself, such as
+// expirations, rather than by a user
+const ActorTypeSystem = "system"
+
+// IsServiceAccount reports whether the user is a non-human principal
+func (u *User) IsServiceAccount() bool {
+	return u.Type == UserTypeServiceAccount
+}
+
+// UserIdentity links a user to an account at a login provider
+type UserIdentity struct {
+	ID       uint   `gorm:"primarykey" json:"id"`
+	UserID   uint   `gorm:"not null;index" json:"user_id"`
+	Provider string `gorm:"not null;uniqueIndex:idx_identity_provider_subject" json:"provider"`
+	Subject  string `gorm:"not null;uniqueIndex:idx_identity_provider_subject" json:"subject"`
+	Email    string `json:"email"`
+	// EmailVerified is whether the provider vouched for Email at the last login
+	EmailVerified bool      `json:"email_verified"`
+	Groups        []string  `gorm:"serializer:json;type:text" json:"groups"` // Groups reported by the provider at the last login
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Approval rule match types
+const (
+	ApprovalMatchEmailDomain = "email_domain"
+	ApprovalMatchGroup       = "group"
+)
+
+// ApprovalRule approves pending users whose email domain or provider group
+// matches, with the given role
+type ApprovalRule struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	Name      string    `gorm:"not null" json:"name"`
+	MatchType string    `gorm:"not null" json:"match_type"` // email_domain, group
+	Value     string    `gorm:"not null" json:"value"`      // Domain without @, or group name
+	Provider  string    `json:"provider,omitempty"`         // Restricts group rules to one login provider
+	Role      string    `gorm:"not null" json:"role"`       // Name of a Role
+	Disabled  bool      `json:"disabled"`                   // Disabled rules are skipped at login
+	CreatedBy uint      `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Permissions that roles are composed of
+const (
+	PermClustersRead          = "clusters:read"
+	PermClustersCreate        = "clusters:create"
+	PermClustersUpdate        = "clusters:update"
+	PermClustersDelete        = "clusters:delete"
+	PermClustersGrant         = "clusters:grant" // Manage who can access a cluster
+	PermClustersAll           = "clusters:all"   // Access every cluster without a ClusterPermission
+	PermWorkloadsRead         = "workloads:read"
+	PermLogsRead              = "logs:read"
+	PermPodsDelete            = "pods:delete"
+	PermDeploymentsScale      = "deployments:scale"
+	PermResourcesDelete       = "resources:delete" // Delete namespaced resources of any kind
+	PermResourcesApply        = "resources:apply"  // Create and update namespaced resources from manifests
+	PermUsersRead             = "users:read"
+	PermUsersApprove          = "users:approve"
+	PermUsersManage           = "users:manage"
+	PermRolesManage           = "roles:manage"
+	PermServiceAccountsManage = "service_accounts:manage"
+	PermApprovalRulesManage   = "approval_rules:manage"
+	PermTeamsManage           = "teams:manage"
+	PermAccessRequestsCreate  = "access_requests:create"
+	PermAccessRequestsApprove = "access_requests:approve" // Also needs admin level on the requested cluster
+	PermAuditRead             = "audit:read"
+	PermAuditManage           = "audit:manage" // Configure audit sinks
+	PermAll                   = "*"
+)
+
+// Built-in role names
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+// Role is a named set of permissions assigned to users. Permissions that act
+// on a cluster also need a ClusterPermission on it, unless the role has
+// clusters:all.
+type Role struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	Name        string    `gorm:"unique;not null" json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `gorm:"serializer:json;type:text" json:"permissions"`
+	BuiltIn     bool      `json:"built_in"` // Built-in roles are created at startup and cannot be changed
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// BuiltInRoles returns the roles every installation has. Operator can do
+// what the former "user" role could.
+func BuiltInRoles() []Role {
+	viewer := []string{PermClustersRead, PermWorkloadsRead, PermLogsRead, PermUsersRead, PermAccessRequestsCreate}
+	operator := append([]string{PermClustersCreate, PermClustersUpdate, PermClustersDelete, PermClustersGrant, PermPodsDelete, PermDeploymentsScale}, viewer...)
+
+	return []Role{
+		{Name: RoleViewer, Description: "Read clusters they have been granted and their workloads and logs", Permissions: viewer, BuiltIn: true},
+		{Name: RoleOperator, Description: "Viewer, plus add clusters, manage the clusters they administer, delete pods and scale deployments", Permissions: operator, BuiltIn: true},
+		{Name: RoleAdmin, Description: "Every permission on every cluster", Permissions: []string{PermAll}, BuiltIn: true},
+	}
 }
 
 // Cluster represents a Kubernetes cluster configuration
 type Cluster struct {
-	ID          uint           `gorm:"primarykey" json:"id"`
-	Name        string         `gorm:"not null" json:"name"`
-	Description string         `json:"description"`
-	KubeConfig  string         `gorm:"type:text;not null" json:"-"` // Encrypted kubeconfig
-	Context     string         `json:"context"`
-	CreatedBy   uint           `json:"created_by"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
-	Creator     User           `gorm:"foreignKey:CreatedBy" json:"creator,omitempty"`
+	ID                  uint           `gorm:"primarykey" json:"id"`
+	Name                string         `gorm:"not null" json:"name"`
+	Description         string         `json:"description"`
+	CredentialType      string         `gorm:"not null;default:kubeconfig" json:"credential_type"` // kubeconfig, token, client_certificate, in_cluster
+	KubeConfig          string         `gorm:"type:text;not null" json:"-"`                        // Kubeconfig encrypted with secrets.Encrypt
+	Context             string         `json:"context"`
+	Server              string         `json:"server,omitempty"`                   // API server URL of token and client_certificate credentials
+	CAData              string         `gorm:"type:text" json:"ca_data,omitempty"` // PEM CA bundle of token and client_certificate credentials
+	Credentials         string         `gorm:"type:text" json:"-"`                 // k8s.Credentials as JSON encrypted with secrets.Encrypt
+	Impersonate         bool           `json:"impersonate"`                        // Act on the cluster as the calling user and their teams, not the kubeconfig identity
+	ImpersonationPrefix string         `json:"impersonation_prefix,omitempty"`     // Prepended to impersonated user and group names, such as surfer:
+	Protected           bool           `json:"protected"`                          // Destructive operations wait for a second user to approve a ChangeRequest
+	CreatedBy           uint           `json:"created_by"`
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+	DeletedAt           gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	Creator             User           `gorm:"foreignKey:CreatedBy" json:"creator,omitempty"`
 }
 
-// Session represents a user session
-type Session struct {
+// How Surfer authenticates to a cluster
+const (
+	CredentialKubeconfig        = "kubeconfig"         // A kubeconfig and one of its contexts
+	CredentialToken             = "token"              // API server URL, CA bundle and bearer token
+	CredentialClientCertificate = "client_certificate" // API server URL, CA bundle and client certificate and key
+	CredentialInCluster         = "in_cluster"         // The service account of the cluster Surfer runs in
+)
+
+// Cluster access levels, each one includes the ones before it
+const (
+	ClusterLevelViewer   = "viewer"
+	ClusterLevelOperator = "operator"
+	ClusterLevelAdmin    = "admin"
+)
+
+var clusterLevelRank = map[string]int{
+	ClusterLevelViewer:   1,
+	ClusterLevelOperator: 2,
+	ClusterLevelAdmin:    3,
+}
+
+// ValidClusterLevel reports whether level is a known cluster access level
+func ValidClusterLevel(level string) bool {
+	return clusterLevelRank[level] > 0
+}
+
+// ClusterLevelAllows reports whether the granted level includes required
+func ClusterLevelAllows(granted, required string) bool {
+	return ValidClusterLevel(granted) && clusterLevelRank[granted] >= clusterLevelRank[required]
+}
+
+// HigherClusterLevel returns whichever of two levels grants more
+func HigherClusterLevel(a, b string) string {
+	if clusterLevelRank[b] > clusterLevelRank[a] {
+		return b
+	}
+	return a
+}
+
+// ClusterPermission grants a user, every member of a provider group or every
+// member of a team access to a cluster at a level. Exactly one of UserID,
+// Group and TeamID is set.
+// Namespace or NamespaceSelector narrow the grant to matching namespaces;
+// without them it covers the whole cluster.
+type ClusterPermission struct {
+	ID                uint      `gorm:"primarykey" json:"id"`
+	ClusterID         uint      `gorm:"not null;uniqueIndex:idx_cluster_permission_grant" json:"cluster_id"`
+	UserID            uint      `gorm:"not null;default:0;uniqueIndex:idx_cluster_permission_grant;index" json:"user_id,omitempty"` // 0 for group and team grants
+	TeamID            uint      `gorm:"not null;default:0;uniqueIndex:idx_cluster_permission_grant;index" json:"team_id,omitempty"`
+	Group             string    `gorm:"column:group_name;not null;default:'';uniqueIndex:idx_cluster_permission_grant" json:"group,omitempty"`
+	Provider          string    `gorm:"not null;default:'';uniqueIndex:idx_cluster_permission_grant" json:"provider,omitempty"`           // Restricts a group grant to one login provider
+	Namespace         string    `gorm:"not null;default:'';uniqueIndex:idx_cluster_permission_grant" json:"namespace,omitempty"`          // Glob such as team-a-*
+	NamespaceSelector string    `gorm:"not null;default:'';uniqueIndex:idx_cluster_permission_grant" json:"namespace_selector,omitempty"` // Label selector such as team=a
+	Level             string    `gorm:"not null" json:"level"`                                                                            // viewer, operator, admin
+	GrantedBy         uint      `json:"granted_by"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	Cluster           Cluster   `gorm:"foreignKey:ClusterID" json:"cluster,omitempty"`
+}
+
+// IsNamespaced reports whether the permission is limited to some namespaces
+func (p *ClusterPermission) IsNamespaced() bool {
+	return p.Namespace != "" || p.NamespaceSelector != ""
+}
+
+// Team groups users so that clusters can be granted to all of them at once.
+// With SyncGroup set, membership also follows that provider group at login.
+type Team struct {
+	ID           uint         `gorm:"primarykey" json:"id"`
+	Name         string       `gorm:"unique;not null" json:"name"`
+	Description  string       `json:"description"`
+	SyncGroup    string       `json:"sync_group,omitempty"`    // Provider group whose members join the team at login
+	SyncProvider string       `json:"sync_provider,omitempty"` // Restricts SyncGroup to one login provider
+	CreatedBy    uint         `json:"created_by"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+	Members      []TeamMember `gorm:"foreignKey:TeamID" json:"members,omitempty"`
+}
+
+// Sources of a team membership
+const (
+	TeamMemberManual = "manual"
+	TeamMemberSync   = "sync"
+)
+
+// TeamMember is the membership of a user in a team. Members added by group
+// sync are removed when they lose the group; manual members stay.
+type TeamMember struct {
 	ID        uint      `gorm:"primarykey" json:"id"`
-	UserID    uint      `gorm:"not null" json:"user_id"`
-	Token     string    `gorm:"unique;not null" json:"-"`
-	ExpiresAt time.Time `json:"expires_at"`
+	TeamID    uint      `gorm:"not null;uniqueIndex:idx_team_member" json:"team_id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_team_member;index" json:"user_id"`
+	Source    string    `gorm:"not null;default:'manual'" json:"source"` // manual, sync
+	AddedBy   uint      `json:"added_by,omitempty"`                      // 0 for sync
 	CreatedAt time.Time `json:"created_at"`
 	User      User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
 }
 
+// Access request statuses
+const (
+	AccessRequestPending   = "pending"
+	AccessRequestApproved  = "approved"
+	AccessRequestDenied    = "denied"
+	AccessRequestCancelled = "cancelled" // By the requester
+	AccessRequestRevoked   = "revoked"   // By an approver, before it expired
+	AccessRequestExpired   = "expired"
+)
+
+// AccessRequest asks for temporary access to a cluster, or to some of its
+// namespaces. Once approved it counts as a ClusterPermission of the requester
+// until ExpiresAt.
+type AccessRequest struct {
+	ID              uint       `gorm:"primarykey" json:"id"`
+	UserID          uint       `gorm:"not null;index" json:"user_id"`
+	ClusterID       uint       `gorm:"not null;index" json:"cluster_id"`
+	Namespace       string     `gorm:"not null;default:''" json:"namespace,omitempty"` // Glob such as team-a-*, empty for the whole cluster
+	Level           string     `gorm:"not null" json:"level"`                          // viewer, operator, admin
+	DurationMinutes int        `gorm:"not null" json:"duration_minutes"`
+	Reason          string     `gorm:"type:text;not null" json:"reason"`
+	Status          string     `gorm:"not null;default:'pending';index" json:"status"`
+	ReviewedBy      *uint      `json:"reviewed_by,omitempty"`
+	ReviewedAt      *time.Time `json:"reviewed_at,omitempty"`
+	ReviewComment   string     `json:"review_comment,omitempty"`
+	ExpiresAt       *time.Time `gorm:"index" json:"expires_at,omitempty"` // Set on approval
+	EndedAt         *time.Time `json:"ended_at,omitempty"`                // When it was cancelled, revoked or expired
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	User            User       `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Cluster         Cluster    `gorm:"foreignKey:ClusterID" json:"cluster,omitempty"`
+}
+
+// Permission returns the cluster permission an approved request grants
+func (r *AccessRequest) Permission() ClusterPermission {
+	return ClusterPermission{
+		ClusterID: r.ClusterID,
+		UserID:    r.UserID,
+		Namespace: r.Namespace,
+		Level:     r.Level,
+	}
+}
+
+// Change request actions
+const (
+	ChangeDeletePod       = "delete_pod"
+	ChangeScaleDeployment = "scale_deployment" // Only scaling to zero needs approval
+	ChangeDeleteResource  = "delete_resource"
+	ChangeApply           = "apply"
+)
+
+// Change request statuses
+const (
+	ChangeRequestPending   = "pending"
+	ChangeRequestApproved  = "approved" // Approved and running on the cluster
+	ChangeRequestExecuted  = "executed" // Approved and run successfully
+	ChangeRequestFailed    = "failed"   // Approved, but the cluster call failed
+	ChangeRequestRejected  = "rejected"
+	ChangeRequestCancelled = "cancelled" // By the requester
+	ChangeRequestExpired   = "expired"
+)
+
+// ChangeRequest is a destructive operation on a protected cluster waiting for
+// a second user to approve it. Surfer runs the operation on approval, as the
+// requester.
+type ChangeRequest struct {
+	ID            uint       `gorm:"primarykey" json:"id"`
+	UserID        uint       `gorm:"not null;index" json:"user_id"`
+	ClusterID     uint       `gorm:"not null;index" json:"cluster_id"`
+	Namespace     string     `gorm:"not null" json:"namespace"`
+	Action        string     `gorm:"not null" json:"action"`              // delete_pod, scale_deployment, delete_resource, apply
+	Target        string     `gorm:"not null" json:"target"`              // Name of the pod, deployment or resource
+	Replicas      *int32     `json:"replicas,omitempty"`                  // For scale_deployment
+	APIVersion    string     `json:"api_version,omitempty"`               // For delete_resource and apply
+	Kind          string     `json:"kind,omitempty"`                      // For delete_resource and apply
+	Manifest      string     `gorm:"type:text" json:"manifest,omitempty"` // Object to apply as JSON
+	Reason        string     `gorm:"type:text" json:"reason,omitempty"`
+	Status        string     `gorm:"not null;default:'pending';index" json:"status"`
+	ReviewedBy    *uint      `json:"reviewed_by,omitempty"`
+	ReviewedAt    *time.Time `json:"reviewed_at,omitempty"`
+	ReviewComment string     `json:"review_comment,omitempty"`
+	Result        string     `gorm:"type:text" json:"result,omitempty"` // Error of a failed execution
+	ExpiresAt     time.Time  `gorm:"index" json:"expires_at"`           // When a pending request expires
+	EndedAt       *time.Time `json:"ended_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	User          User       `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Cluster       Cluster    `gorm:"foreignKey:ClusterID" json:"cluster,omitempty"`
+}
+
+// Session represents a user session
+type Session struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	Token     string     `gorm:"unique;not null" json:"-"` // jti of the token issued for this session
+	IPAddress string     `json:"ip_address"`
+	UserAgent string     `json:"user_agent"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	User      User       `gorm:"foreignKey:UserID" json:"user,omitempty"`
+}
+
+// RefreshToken is a one-time-use token that renews the access token of a
+// session. All refresh tokens of a session form one rotation family.
+type RefreshToken struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	SessionID uint       `gorm:"not null;index" json:"session_id"`
+	UserID    uint       `gorm:"not null" json:"user_id"`
+	TokenHash string     `gorm:"unique;not null" json:"-"` // SHA-256 of the refresh token
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// PersonalAccessToken is a long-lived, scoped credential for API and CI use
+type PersonalAccessToken struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	Name       string     `gorm:"not null" json:"name"`
+	TokenHash  string     `gorm:"unique;not null" json:"-"` // SHA-256 of the token
+	Prefix     string     `json:"prefix"`                   // Start of the token, to recognise it in lists
+	Scopes     string     `gorm:"not null" json:"scopes"`   // Comma separated: read, write, admin
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	User       User       `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// OAuthState is a login that was started at a provider and not yet completed
+type OAuthState struct {
+	StateHash    string    `gorm:"primarykey" json:"-"` // SHA-256 of the state parameter
+	Provider     string    `gorm:"not null" json:"provider"`
+	Nonce        string    `json:"-"`
+	CodeVerifier string    `json:"-"` // PKCE code verifier
+	LinkUserID   *uint     `json:"-"` // Set when a signed-in user links an identity instead of logging in
+	ExpiresAt    time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
 // AuditLog represents an audit log entry
 type AuditLog struct {
 	ID         uint      `gorm:"primarykey" json:"id"`
 	UserID     uint      `json:"user_id"`
+	ActorType  string    `gorm:"default:'human';index" json:"actor_type"` // human, service_account, system
 	Action     string    `json:"action"`
 	Resource   string    `json:"resource"`
 	ResourceID string    `json:"resource_id"`
 	Details    string    `gorm:"type:text" json:"details"`
 	IPAddress  string    `json:"ip_address"`
-	CreatedAt  time.Time `json:"created_at"`
+	ClusterID  *uint     `gorm:"index" json:"cluster_id,omitempty"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Method     string    `json:"method,omitempty"` // This and the fields below are set on entries of the request audit middleware
+	Route      string    `json:"route,omitempty"`  // Route template, such as /api/v1/clusters/:id
+	StatusCode int       `json:"status_code,omitempty"`
+	Outcome    string    `gorm:"index" json:"outcome,omitempty"` // success, denied, failure
+	LatencyMs  int64     `json:"latency_ms,omitempty"`
+	PrevHash   string    `gorm:"size:64" json:"prev_hash"`  // Hash of the entry before this one, empty for the first
+	Hash       string    `gorm:"size:64;index" json:"hash"` // SHA-256 over PrevHash and the fields above
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
 	User       User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
 }
+
+// AuditCheckpoint records the end of the audit chain at a point in time,
+// signed with the JWT signing key, so that entries removed from the end of the
+// chain are noticed
+type AuditCheckpoint struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	LastEntryID uint      `gorm:"not null" json:"last_entry_id"`
+	LastHash    string    `gorm:"size:64;not null" json:"last_hash"`
+	Signature   string    `gorm:"type:text;not null" json:"signature"` // JWS over last_entry_id and last_hash, verifiable with the JWKS
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Audit sink types
+const (
+	AuditSinkSyslog  = "syslog"
+	AuditSinkWebhook = "webhook"
+	AuditSinkFile    = "file"
+)
+
+// AuditSink is a destination audit log entries are forwarded to as they are
+// written. Only the fields of its type are used.
+type AuditSink struct {
+	ID              uint       `gorm:"primarykey" json:"id"`
+	Name            string     `gorm:"unique;not null" json:"name"`
+	Type            string     `gorm:"not null" json:"type"` // syslog, webhook, file
+	Enabled         bool       `gorm:"not null;default:true" json:"enabled"`
+	Network         string     `json:"network,omitempty"` // syslog: tcp or udp
+	Address         string     `json:"address,omitempty"` // syslog: host:port
+	URL             string     `json:"url,omitempty"`     // webhook
+	Secret          string     `json:"-"`                 // webhook: HMAC-SHA256 key signing each request, encrypted
+	Path            string     `json:"path,omitempty"`    // file
+	MaxSizeMB       int        `json:"max_size_mb,omitempty"`
+	MaxFiles        int        `json:"max_files,omitempty"` // file: rotated files kept besides the current one
+	LastError       string     `json:"last_error,omitempty"`
+	LastDeliveredAt *time.Time `json:"last_delivered_at,omitempty"`
+	CreatedBy       uint       `json:"created_by"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// AuditOutbox is an audit log entry waiting to be delivered to a sink. Rows are
+// written with the entry and deleted once the sink accepted it.
+type AuditOutbox struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	SinkID        uint      `gorm:"not null;index:idx_audit_outbox_sink" json:"sink_id"`
+	EntryID       uint      `gorm:"not null" json:"entry_id"`
+	Attempts      int       `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time `gorm:"not null;index:idx_audit_outbox_sink" json:"next_attempt_at"`
+	LastError     string    `gorm:"type:text" json:"last_error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	Entry         AuditLog  `gorm:"foreignKey:EntryID" json:"-"`
+}
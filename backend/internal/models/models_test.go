@@ -152,3 +152,30 @@ func TestUserSoftDelete(t *testing.T) {
 		t.Error("Expected user to be soft deleted")
 	}
 }
+
+func TestServiceAccount(t *testing.T) {
+	human := User{Email: "jane@example.com", Type: UserTypeHuman}
+	account := User{Email: "ci@serviceaccounts.surfer.invalid", Type: UserTypeServiceAccount}
+
+	if human.IsServiceAccount() {
+		t.Error("Expected human user not to be a service account")
+	}
+
+	if !account.IsServiceAccount() {
+		t.Error("Expected service account to be a service account")
+	}
+}
+
+func TestClusterLevelAllows(t *testing.T) {
+	if !ClusterLevelAllows(ClusterLevelAdmin, ClusterLevelOperator) {
+		t.Error("Expected admin to include operator")
+	}
+
+	if ClusterLevelAllows(ClusterLevelViewer, ClusterLevelOperator) {
+		t.Error("Expected viewer not to include operator")
+	}
+
+	if ClusterLevelAllows("", ClusterLevelViewer) || ClusterLevelAllows("owner", ClusterLevelViewer) {
+		t.Error("Expected missing or unknown levels to allow nothing")
+	}
+}
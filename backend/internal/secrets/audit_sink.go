@@ -0,0 +1,46 @@
+package secrets
+
+import (
+	"fmt"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// EncryptAuditSinks encrypts the webhook secrets of audit sinks like
+// EncryptClusters does kubeconfigs. It returns how many sinks were changed.
+func EncryptAuditSinks(db *gorm.DB, provider KeyProvider, rotate bool) (int, error) {
+	var sinks []models.AuditSink
+	if err := db.Select("id", "secret").Where("secret <> ''").Order("id").Find(&sinks).Error; err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, sink := range sinks {
+		if IsEncrypted(sink.Secret) && (!rotate || KeyID(sink.Secret) == provider.KeyID()) {
+			continue
+		}
+
+		plaintext, err := Decrypt(provider, sink.Secret)
+		if err != nil {
+			return changed, fmt.Errorf("audit sink %d secret: %w", sink.ID, err)
+		}
+		encrypted, err := Encrypt(provider, plaintext)
+		if err != nil {
+			return changed, fmt.Errorf("audit sink %d secret: %w", sink.ID, err)
+		}
+
+		// As for clusters, a secret replaced in the meantime is left alone
+		result := db.Model(&models.AuditSink{}).
+			Where("id = ? AND secret = ?", sink.ID, sink.Secret).
+			UpdateColumn("secret", encrypted)
+		if result.Error != nil {
+			return changed, fmt.Errorf("audit sink %d secret: %w", sink.ID, result.Error)
+		}
+		if result.RowsAffected > 0 {
+			changed++
+		}
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,60 @@
+package secrets
+
+import (
+	"fmt"
+
+	"github.com/mysticrenji/surfer/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// clusterSecretColumns are the encrypted columns of clusters
+var clusterSecretColumns = []string{"kube_config", "credentials"}
+
+// EncryptClusters encrypts the kubeconfigs and credentials of clusters,
+// deleted ones included, with the provider's current master key. Without
+// rotate only values that are not encrypted yet are changed. It returns how
+// many clusters were changed.
+func EncryptClusters(db *gorm.DB, provider KeyProvider, rotate bool) (int, error) {
+	var clusters []models.Cluster
+	if err := db.Unscoped().Select("id", "kube_config", "credentials").Order("id").Find(&clusters).Error; err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, cluster := range clusters {
+		values := map[string]string{"kube_config": cluster.KubeConfig, "credentials": cluster.Credentials}
+		updated := false
+		for _, column := range clusterSecretColumns {
+			stored := values[column]
+			if stored == "" || IsEncrypted(stored) && (!rotate || KeyID(stored) == provider.KeyID()) {
+				continue
+			}
+
+			plaintext, err := Decrypt(provider, stored)
+			if err != nil {
+				return changed, fmt.Errorf("cluster %d %s: %w", cluster.ID, column, err)
+			}
+			encrypted, err := Encrypt(provider, plaintext)
+			if err != nil {
+				return changed, fmt.Errorf("cluster %d %s: %w", cluster.ID, column, err)
+			}
+
+			// Leave values that were replaced in the meantime alone. updated_at
+			// stays, the value itself did not change.
+			result := db.Unscoped().Model(&models.Cluster{}).
+				Where("id = ? AND "+column+" = ?", cluster.ID, stored).
+				UpdateColumn(column, encrypted)
+			if result.Error != nil {
+				return changed, fmt.Errorf("cluster %d %s: %w", cluster.ID, column, result.Error)
+			}
+			if result.RowsAffected > 0 {
+				updated = true
+			}
+		}
+		if updated {
+			changed++
+		}
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,131 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// envelopePrefix marks encrypted values. Values without it were stored before
+// encryption and are returned as they are.
+const envelopePrefix = "enc:v1:"
+
+// dataKeySize is the size of the per-value AES-256 data keys
+const dataKeySize = 32
+
+// envelope is an encrypted value: the ciphertext under a fresh data key, and
+// the data key wrapped with the master key KeyID
+type envelope struct {
+	KeyID      string `json:"kid"`
+	WrappedKey []byte `json:"key"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"data"`
+}
+
+// Encrypt encrypts plaintext with a new data key and wraps the data key with
+// the provider's current master key
+func Encrypt(provider KeyProvider, plaintext string) (string, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	wrapped, err := provider.WrapKey(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	data, err := json.Marshal(envelope{
+		KeyID:      provider.KeyID(),
+		WrappedKey: wrapped,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, []byte(plaintext), nil),
+	})
+	if err != nil {
+		return "", err
+	}
+	return envelopePrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Decrypt returns the plaintext of a value Encrypt returned. Values that are
+// not encrypted are returned unchanged.
+func Decrypt(provider KeyProvider, stored string) (string, error) {
+	if !IsEncrypted(stored) {
+		return stored, nil
+	}
+
+	sealed, err := parseEnvelope(stored)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := provider.UnwrapKey(sealed.KeyID, sealed.WrappedKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed.Nonce) != aead.NonceSize() {
+		return "", errors.New("encrypted value has an invalid nonce")
+	}
+	plaintext, err := aead.Open(nil, sealed.Nonce, sealed.Ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted reports whether a stored value was encrypted by Encrypt
+func IsEncrypted(stored string) bool {
+	return strings.HasPrefix(stored, envelopePrefix)
+}
+
+// KeyID returns the master key a stored value was encrypted with, or "" when
+// it is not encrypted
+func KeyID(stored string) string {
+	if !IsEncrypted(stored) {
+		return ""
+	}
+	sealed, err := parseEnvelope(stored)
+	if err != nil {
+		return ""
+	}
+	return sealed.KeyID
+}
+
+func parseEnvelope(stored string) (*envelope, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, envelopePrefix))
+	if err != nil {
+		return nil, fmt.Errorf("encrypted value is malformed: %w", err)
+	}
+	var sealed envelope
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return nil, fmt.Errorf("encrypted value is malformed: %w", err)
+	}
+	return &sealed, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,112 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testProvider(t *testing.T, current byte, retired ...byte) *LocalKeyProvider {
+	t.Helper()
+	var retiredKeys [][]byte
+	for _, b := range retired {
+		retiredKeys = append(retiredKeys, bytes.Repeat([]byte{b}, masterKeySize))
+	}
+	provider, err := NewLocalKeyProvider(bytes.Repeat([]byte{current}, masterKeySize), retiredKeys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+const testKubeconfig = "apiVersion: v1\nkind: Config\nusers:\n- name: admin\n  user:\n    token: secret-token\n"
+
+func TestEncryptDecrypt(t *testing.T) {
+	provider := testProvider(t, 1)
+
+	encrypted, err := Encrypt(provider, testKubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encrypted) || strings.Contains(encrypted, "secret-token") {
+		t.Fatalf("Encrypt() = %q, want an envelope without the plaintext", encrypted)
+	}
+	if KeyID(encrypted) != provider.KeyID() {
+		t.Errorf("KeyID() = %q, want %q", KeyID(encrypted), provider.KeyID())
+	}
+
+	again, _ := Encrypt(provider, testKubeconfig)
+	if again == encrypted {
+		t.Error("encrypting twice gave the same value, want a new data key and nonce each time")
+	}
+
+	decrypted, err := Decrypt(provider, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != testKubeconfig {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, testKubeconfig)
+	}
+}
+
+func TestDecryptPlaintext(t *testing.T) {
+	decrypted, err := Decrypt(testProvider(t, 1), testKubeconfig)
+	if err != nil || decrypted != testKubeconfig {
+		t.Errorf("Decrypt() = %q, %v, want the value unchanged", decrypted, err)
+	}
+	if KeyID(testKubeconfig) != "" {
+		t.Errorf("KeyID() = %q, want empty for plaintext", KeyID(testKubeconfig))
+	}
+}
+
+func TestDecryptRotatedKey(t *testing.T) {
+	encrypted, _ := Encrypt(testProvider(t, 1), testKubeconfig)
+
+	// The new master key is current and the old one retired
+	rotated := testProvider(t, 2, 1)
+	decrypted, err := Decrypt(rotated, encrypted)
+	if err != nil || decrypted != testKubeconfig {
+		t.Errorf("Decrypt() with retired key = %q, %v", decrypted, err)
+	}
+	if KeyID(encrypted) == rotated.KeyID() {
+		t.Error("value encrypted with the old key reports the current key")
+	}
+
+	// Without the old key the value cannot be read
+	_, err = Decrypt(testProvider(t, 2), encrypted)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt() without the key error = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	provider := testProvider(t, 1)
+	encrypted, _ := Encrypt(provider, testKubeconfig)
+
+	data, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, envelopePrefix))
+	sealed := string(data)
+	// Flip a character of the base64 ciphertext in the JSON
+	i := strings.Index(sealed, `"data":"`) + len(`"data":"`)
+	flipped := []byte(sealed)
+	if flipped[i] == 'A' {
+		flipped[i] = 'B'
+	} else {
+		flipped[i] = 'A'
+	}
+	tampered := envelopePrefix + base64.StdEncoding.EncodeToString(flipped)
+
+	if _, err := Decrypt(provider, tampered); err == nil {
+		t.Error("Decrypt() of a modified ciphertext succeeded")
+	}
+	if _, err := Decrypt(provider, envelopePrefix+"not base64!"); err == nil {
+		t.Error("Decrypt() of a malformed envelope succeeded")
+	}
+}
+
+func TestNewLocalKeyProviderKeySize(t *testing.T) {
+	if _, err := NewLocalKeyProvider(make([]byte, 16)); err == nil {
+		t.Error("NewLocalKeyProvider() accepted a 16 byte key")
+	}
+}
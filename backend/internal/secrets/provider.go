@@ -0,0 +1,197 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoEncryptionKey is returned when no kubeconfig encryption key is
+// configured and key generation has not been enabled
+var ErrNoEncryptionKey = errors.New("no kubeconfig encryption key configured: set KUBECONFIG_ENCRYPTION_KEY_FILE or KUBECONFIG_ENCRYPTION_KEY to 32 random bytes in base64 (openssl rand -base64 32), or KUBECONFIG_GENERATE_ENCRYPTION_KEY=true with KUBECONFIG_ENCRYPTION_KEY_FILE to create the file")
+
+// ErrUnknownKey is returned when a value was encrypted with a master key the
+// provider does not have
+var ErrUnknownKey = errors.New("encrypted with an unknown master key")
+
+// masterKeySize is the size of local master keys, for AES-256
+const masterKeySize = 32
+
+// KeyProvider wraps the data keys values are encrypted with under a master
+// key. Providers backed by a KMS keep the master key out of the process.
+type KeyProvider interface {
+	// KeyID identifies the master key WrapKey uses
+	KeyID() string
+	// WrapKey encrypts a data key with the current master key
+	WrapKey(dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key wrapped with the master key keyID, or
+	// returns ErrUnknownKey
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// LocalKeyProvider wraps data keys with AES-256-GCM master keys held in
+// memory. Retired master keys still unwrap data keys until everything is
+// re-encrypted with the current one.
+type LocalKeyProvider struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewLocalKeyProvider returns a provider that wraps with current and unwraps
+// with current and retired
+func NewLocalKeyProvider(current []byte, retired ...[]byte) (*LocalKeyProvider, error) {
+	provider := &LocalKeyProvider{keys: make(map[string]cipher.AEAD)}
+	for i, key := range append([][]byte{current}, retired...) {
+		if len(key) != masterKeySize {
+			return nil, fmt.Errorf("master key must be %d bytes, got %d", masterKeySize, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		id := localKeyID(key)
+		provider.keys[id] = aead
+		if i == 0 {
+			provider.current = id
+		}
+	}
+	return provider, nil
+}
+
+// localKeyID names a master key by its fingerprint so that the key itself is
+// not needed to tell which one encrypted a value
+func localKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "local:" + hex.EncodeToString(sum[:8])
+}
+
+func (p *LocalKeyProvider) KeyID() string {
+	return p.current
+}
+
+func (p *LocalKeyProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	aead := p.keys[p.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(p.current)), nil
+}
+
+func (p *LocalKeyProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownKey, keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped data key is truncated")
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, []byte(keyID))
+}
+
+// LoadKeyProvider builds the key provider from the environment. The master
+// key comes from KUBECONFIG_ENCRYPTION_KEY_FILE or KUBECONFIG_ENCRYPTION_KEY,
+// and retired keys from KUBECONFIG_DECRYPTION_KEY_FILES and
+// KUBECONFIG_DECRYPTION_KEYS, all base64.
+func LoadKeyProvider() (KeyProvider, error) {
+	var retired [][]byte
+	if files := os.Getenv("KUBECONFIG_DECRYPTION_KEY_FILES"); files != "" {
+		for _, file := range strings.Split(files, ",") {
+			key, err := readKeyFile(strings.TrimSpace(file))
+			if err != nil {
+				return nil, err
+			}
+			retired = append(retired, key)
+		}
+	}
+	if keys := os.Getenv("KUBECONFIG_DECRYPTION_KEYS"); keys != "" {
+		for _, encoded := range strings.Split(keys, ",") {
+			key, err := decodeKey(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse KUBECONFIG_DECRYPTION_KEYS: %w", err)
+			}
+			retired = append(retired, key)
+		}
+	}
+
+	var current []byte
+	file := os.Getenv("KUBECONFIG_ENCRYPTION_KEY_FILE")
+	switch {
+	case file != "":
+		key, err := readKeyFile(file)
+		if errors.Is(err, os.ErrNotExist) && os.Getenv("KUBECONFIG_GENERATE_ENCRYPTION_KEY") == "true" {
+			key, err = generateKeyFile(file)
+		}
+		if err != nil {
+			return nil, err
+		}
+		current = key
+	case os.Getenv("KUBECONFIG_ENCRYPTION_KEY") != "":
+		key, err := decodeKey(os.Getenv("KUBECONFIG_ENCRYPTION_KEY"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse KUBECONFIG_ENCRYPTION_KEY: %w", err)
+		}
+		current = key
+	default:
+		return nil, ErrNoEncryptionKey
+	}
+
+	return NewLocalKeyProvider(current, retired...)
+}
+
+func readKeyFile(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key: %w", err)
+	}
+	key, err := decodeKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse encryption key %s: %w", file, err)
+	}
+	return key, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != masterKeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", masterKeySize, len(key))
+	}
+	return key, nil
+}
+
+// generateKeyFile writes a new random master key to file. The file must be
+// kept: kubeconfigs encrypted with it cannot be read without it.
+func generateKeyFile(file string) ([]byte, error) {
+	key := make([]byte, masterKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create encryption key: %w", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(key) + "\n"
+	if err := os.WriteFile(file, []byte(encoded), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to create encryption key: %w", err)
+	}
+	log.Printf("WARNING: generated a kubeconfig encryption key in %s; back it up, stored kubeconfigs cannot be decrypted without it", file)
+	return key, nil
+}
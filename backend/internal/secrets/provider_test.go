@@ -0,0 +1,78 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadKeyProvider(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, masterKeySize))
+	old := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, masterKeySize))
+	oldFile := filepath.Join(t.TempDir(), "old.key")
+	os.WriteFile(oldFile, []byte(old+"\n"), 0o600)
+
+	t.Setenv("KUBECONFIG_ENCRYPTION_KEY", key)
+	t.Setenv("KUBECONFIG_DECRYPTION_KEY_FILES", oldFile)
+
+	provider, err := LoadKeyProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.KeyID() != testProvider(t, 1).KeyID() {
+		t.Errorf("KeyID() = %q, want the KUBECONFIG_ENCRYPTION_KEY key", provider.KeyID())
+	}
+
+	encrypted, _ := Encrypt(testProvider(t, 2), "kubeconfig")
+	if decrypted, err := Decrypt(provider, encrypted); err != nil || decrypted != "kubeconfig" {
+		t.Errorf("Decrypt() with a retired key = %q, %v", decrypted, err)
+	}
+}
+
+func TestLoadKeyProviderMissing(t *testing.T) {
+	t.Setenv("KUBECONFIG_ENCRYPTION_KEY", "")
+	t.Setenv("KUBECONFIG_ENCRYPTION_KEY_FILE", "")
+
+	if _, err := LoadKeyProvider(); !errors.Is(err, ErrNoEncryptionKey) {
+		t.Errorf("LoadKeyProvider() error = %v, want ErrNoEncryptionKey", err)
+	}
+
+	t.Setenv("KUBECONFIG_ENCRYPTION_KEY", "c2hvcnQ=")
+	if _, err := LoadKeyProvider(); err == nil {
+		t.Error("LoadKeyProvider() accepted a short key")
+	}
+}
+
+func TestLoadKeyProviderGenerate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys", "kubeconfig.key")
+	t.Setenv("KUBECONFIG_ENCRYPTION_KEY_FILE", file)
+
+	if _, err := LoadKeyProvider(); err == nil {
+		t.Fatal("LoadKeyProvider() succeeded without the key file or generation enabled")
+	}
+
+	t.Setenv("KUBECONFIG_GENERATE_ENCRYPTION_KEY", "true")
+	generated, err := LoadKeyProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// The file is reused on the next start
+	loaded, err := LoadKeyProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.KeyID() != generated.KeyID() {
+		t.Errorf("KeyID() = %q after restart, want %q", loaded.KeyID(), generated.KeyID())
+	}
+}
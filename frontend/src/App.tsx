@@ -172,6 +172,7 @@ function App() {
           <Routes>
             <Route path="/login" element={<LoginPage />} />
             <Route path="/auth/google/callback" element={<GoogleCallback />} />
+            <Route path="/auth/callback" element={<GoogleCallback />} />
             <Route path="/pending-approval" element={<PendingApprovalPage />} />
             <Route
               path="/dashboard"
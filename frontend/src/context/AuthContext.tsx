@@ -41,6 +41,7 @@ export const AuthProvider: React.FC<{ children: ReactNode }> = ({ children }) =>
     }
     setUser(null);
     localStorage.removeItem('auth_token');
+    localStorage.removeItem('refresh_token');
     window.location.href = '/login';
   };
 
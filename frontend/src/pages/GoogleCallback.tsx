@@ -1,14 +1,21 @@
 import React, { useEffect } from 'react';
 import { useNavigate, useSearchParams } from 'react-router-dom';
 import { Box, CircularProgress, Typography } from '@mui/material';
-import api from '../services/api';
+import { API_BASE_URL } from '../services/api';
 
 const GoogleCallback: React.FC = () => {
   const navigate = useNavigate();
   const [searchParams] = useSearchParams();
 
   useEffect(() => {
-    const handleCallback = async () => {
+    const handleCallback = () => {
+      // Tokens arrive in the fragment, which is dropped from the history entry
+      const fragment = new URLSearchParams(window.location.hash.slice(1));
+      const token = fragment.get('token');
+      const refreshToken = fragment.get('refresh_token');
+      if (window.location.hash) {
+        window.history.replaceState(null, '', window.location.pathname + window.location.search);
+      }
       const code = searchParams.get('code');
       const state = searchParams.get('state');
       const error = searchParams.get('error');
@@ -19,27 +26,26 @@ const GoogleCallback: React.FC = () => {
         return;
       }
 
+      // The backend redirects here with a token once the user is approved
+      if (token) {
+        localStorage.setItem('auth_token', token);
+        if (refreshToken) {
+          localStorage.setItem('refresh_token', refreshToken);
+        }
+        navigate('/dashboard');
+        return;
+      }
+
       if (!code || !state) {
         console.error('Missing code or state parameter');
         navigate('/login?error=invalid_callback');
         return;
       }
 
-      try {
-        // Send the code and state to backend for processing
-        const response = await api.get(`/auth/google/callback?code=${code}&state=${state}`);
-
-        if (response.data.token) {
-          localStorage.setItem('auth_token', response.data.token);
-          navigate('/dashboard');
-        } else {
-          // User needs approval
-          navigate('/pending-approval');
-        }
-      } catch (error) {
-        console.error('Callback processing error:', error);
-        navigate('/login?error=callback_failed');
-      }
+      // Hand the code to the backend, which redirects back with a token or
+      // to the pending approval page
+      const params = new URLSearchParams({ code, state });
+      window.location.href = `${API_BASE_URL}/auth/google/callback?${params.toString()}`;
     };
 
     handleCallback();
@@ -1,6 +1,6 @@
 import axios from 'axios';
 
-const API_BASE_URL = process.env.REACT_APP_API_URL || 'http://localhost:8080/api/v1';
+export const API_BASE_URL = process.env.REACT_APP_API_URL || 'http://localhost:8080/api/v1';
 
 const api = axios.create({
   baseURL: API_BASE_URL,
@@ -18,12 +18,35 @@ api.interceptors.request.use((config) => {
   return config;
 });
 
-// Handle 401 responses
+// Handle 401 responses: try to renew the access token once with the refresh
+// token, otherwise send the user back to the login page
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
+  async (error) => {
+    const original = error.config;
+    const refreshToken = localStorage.getItem('refresh_token');
+
+    if (
+      error.response?.status === 401 &&
+      refreshToken &&
+      original &&
+      !original._retry &&
+      !original.url?.includes('/auth/refresh')
+    ) {
+      original._retry = true;
+      try {
+        const response = await api.post('/auth/refresh', { refresh_token: refreshToken });
+        localStorage.setItem('auth_token', response.data.token);
+        localStorage.setItem('refresh_token', response.data.refresh_token);
+        return api(original);
+      } catch (refreshError) {
+        // Fall through to the logout below
+      }
+    }
+
     if (error.response?.status === 401) {
       localStorage.removeItem('auth_token');
+      localStorage.removeItem('refresh_token');
       window.location.href = '/login';
     }
     return Promise.reject(error);
@@ -1,6 +1,6 @@
 import '@testing-library/jest-dom';
 import { authService, userService, clusterService, k8sService } from './index';
-import api from './api';
+import api, { API_BASE_URL } from './api';
 
 jest.mock('./api');
 
@@ -11,12 +11,8 @@ describe('Services', () => {
 
   describe('authService', () => {
     test('getGoogleLoginUrl returns URL', async () => {
-      const mockUrl = 'https://google.com/auth';
-      (api.get as jest.Mock).mockResolvedValue({ data: { url: mockUrl } });
-
       const url = await authService.getGoogleLoginUrl();
-      expect(url).toBe(mockUrl);
-      expect(api.get).toHaveBeenCalledWith('/auth/google/login');
+      expect(url).toBe(`${API_BASE_URL}/auth/google/login?redirect=true`);
     });
 
     test('logout clears token and calls API', async () => {
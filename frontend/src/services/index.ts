@@ -1,21 +1,28 @@
-import api from './api';
-import { User } from '../types';
+import api, { API_BASE_URL } from './api';
+import { EffectivePermissions, User } from '../types';
 
 export const authService = {
+  // The login has to be a top-level navigation so the backend can set the
+  // state cookie that the callback is checked against
   getGoogleLoginUrl: async () => {
-    const response = await api.get('/auth/google/login');
-    return response.data.url;
+    return `${API_BASE_URL}/auth/google/login?redirect=true`;
   },
 
   logout: async () => {
     await api.post('/auth/logout');
     localStorage.removeItem('auth_token');
+    localStorage.removeItem('refresh_token');
   },
 
   getCurrentUser: async (): Promise<User> => {
     const response = await api.get('/users/me');
     return response.data;
   },
+
+  getMyPermissions: async (): Promise<EffectivePermissions> => {
+    const response = await api.get('/users/me/permissions');
+    return response.data;
+  },
 };
 
 export const userService = {
@@ -4,7 +4,9 @@ export interface User {
   name: string;
   picture: string;
   google_id: string;
-  role: 'pending' | 'user' | 'admin';
+  type?: 'human' | 'service_account';
+  description?: string;
+  role: string; // 'pending' or the name of a role
   status: 'pending' | 'approved' | 'rejected';
   approved_by?: number;
   approved_at?: string;
@@ -12,11 +14,22 @@ export interface User {
   updated_at: string;
 }
 
+export interface EffectivePermissions {
+  role: string;
+  permissions: string[];
+}
+
 export interface Cluster {
   id: number;
   name: string;
   description: string;
+  credential_type?: 'kubeconfig' | 'token' | 'client_certificate' | 'in_cluster';
   context: string;
+  server?: string;
+  ca_data?: string;
+  impersonate?: boolean;
+  impersonation_prefix?: string;
+  protected?: boolean;
   created_by: number;
   created_at: string;
   updated_at: string;
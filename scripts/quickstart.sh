@@ -72,14 +72,6 @@ if grep -q "your-google-client-id" .env; then
     read -p "Press Enter to continue anyway or Ctrl+C to exit..."
 fi
 
-# Generate JWT secret if not set
-if grep -q "your-jwt-secret-change-this" .env; then
-    echo -e "${YELLOW}Generating JWT secret...${NC}"
-    JWT_SECRET=$(openssl rand -hex 32 2>/dev/null || head -c 32 /dev/urandom | base64)
-    sed -i "s/your-jwt-secret-change-this/$JWT_SECRET/" .env
-    echo -e "${GREEN}✓ JWT secret generated${NC}"
-fi
-
 echo -e "${BLUE}"
 echo "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━"
 echo "  🚀 Starting Surfer"